@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"golangify.com/snippetbox/repository"
+)
+
+// accountOrdersHandler показывает покупателю его заказы. Гостевые заказы
+// сюда не попадают: их находят по ссылке со страницы оформления.
+func (srv *server) accountOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := srv.store.Get(r, "session-name")
+	userID, ok := sessionUserID(session)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	list, err := srv.orders.ListOrders(r.Context(), repository.OrderFilter{UserID: userID})
+	if err != nil {
+		log.Println("Ошибка при загрузке заказов покупателя:", err)
+		http.Error(w, "Ошибка при загрузке заказов", http.StatusInternalServerError)
+		return
+	}
+
+	username, _ := session.Values["username"].(string)
+	err = accountOrdersTpl.Execute(w, map[string]interface{}{
+		"Username": username,
+		"Orders":   list,
+	})
+	if err != nil {
+		log.Println("Ошибка при рендеринге заказов покупателя:", err)
+		http.Error(w, "Ошибка при отображении страницы", http.StatusInternalServerError)
+	}
+}
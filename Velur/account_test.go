@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var trackingLink = regexp.MustCompile(`href="(/order/[0-9a-f]{32})"`)
+
+func TestAccountOrders(t *testing.T) {
+	app := newTestApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 5)
+	app.createUser(t, "anna", "secret", "user")
+	app.createUser(t, "olga", "secret", "user")
+
+	anna := app.client(t)
+	assertRedirect(t, anna.get("/account/orders"), "/login")
+	anna.login("anna", "secret")
+	anna.postForm("/cart/add", accessoryItem(accessory, "2"))
+	assertStatus(t, anna.postForm("/order", orderForm()), http.StatusOK)
+	guestOrder := placeOrder(t, app, accessoryItem(accessory, "1"), "+79990000000")
+
+	placed := app.store.Orders()
+	if placed[0].UserID == 0 || placed[1].UserID != 0 {
+		t.Fatalf("владельцы заказов: %d, %d", placed[0].UserID, placed[1].UserID)
+	}
+
+	resp := anna.get("/account/orders")
+	assertStatus(t, resp, http.StatusOK)
+	assertContains(t, resp.body, `href="/order/`+placed[0].PublicID+`"`)
+	assertContains(t, resp.body, "Новый")
+	if strings.Contains(resp.body, placed[1].PublicID) {
+		t.Fatalf("в списке anna гостевой заказ %d", guestOrder)
+	}
+
+	olga := app.client(t)
+	olga.login("olga", "secret")
+	assertContains(t, olga.get("/account/orders").body, "Вы ещё ничего не заказывали")
+	assertContains(t, olga.get("/").body, `href="/account/orders"`)
+}
+
+func TestOrderTracking(t *testing.T) {
+	app := newTestApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 5)
+	guest := app.client(t)
+	guest.postForm("/cart/add", accessoryItem(accessory, "1"))
+
+	resp := guest.postForm("/order", orderForm())
+	assertStatus(t, resp, http.StatusOK)
+	m := trackingLink.FindStringSubmatch(resp.body)
+	if m == nil {
+		t.Fatal("на странице оформления нет ссылки на заказ")
+	}
+	track := m[1]
+
+	resp = app.client(t).get(track)
+	assertStatus(t, resp, http.StatusOK)
+	assertContains(t, resp.body, "Кожаная сумка")
+	assertContains(t, resp.body, "Статус: Новый")
+
+	id := app.store.Orders()[0].ID
+	admin := app.adminClient(t)
+	form := url.Values{"status": {"confirmed"}, "comment": {"Клиент просил перезвонить"}}
+	assertRedirect(t, admin.postForm(orderPath(id)+"/status", form), orderPath(id))
+
+	resp = guest.get(track)
+	assertContains(t, resp.body, "Статус: Подтверждён")
+	if strings.Contains(resp.body, "Клиент просил перезвонить") || strings.Contains(resp.body, "admin") {
+		t.Fatal("покупателю показаны служебные данные истории")
+	}
+
+	assertStatus(t, guest.get("/order/"+strings.Repeat("0", 32)), http.StatusNotFound)
+	assertStatus(t, guest.get("/order/1"), http.StatusNotFound)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"golangify.com/snippetbox/jobs"
+	"golangify.com/snippetbox/repository"
+)
+
+// adminJobsLimit — сколько заданий показывает список.
+const adminJobsLimit = 200
+
+// adminJobs показывает задания со статусом из фильтра; по умолчанию —
+// невыполненные, которые ждут разбора.
+func (srv *server) adminJobs(w http.ResponseWriter, r *http.Request) {
+	status := jobs.Status(r.URL.Query().Get("status"))
+	if status == "" {
+		status = jobs.Dead
+	}
+	if !jobs.Known(status) {
+		http.Error(w, "Неизвестный статус задания", http.StatusBadRequest)
+		return
+	}
+
+	list, err := srv.jobs.ListJobs(r.Context(), status, adminJobsLimit)
+	if err != nil {
+		log.Println("Ошибка при загрузке заданий:", err)
+		http.Error(w, "Ошибка при загрузке заданий", http.StatusInternalServerError)
+		return
+	}
+
+	err = adminJobsTpl.Execute(w, map[string]interface{}{
+		"Jobs":      list,
+		"Statuses":  jobs.Statuses,
+		"Status":    status,
+		"Limited":   len(list) == adminJobsLimit,
+		"CSRFToken": srv.csrfToken(w, r),
+	})
+	if err != nil {
+		log.Println("Ошибка при рендеринге списка заданий:", err)
+		http.Error(w, "Ошибка при отображении страницы", http.StatusInternalServerError)
+	}
+}
+
+// retryJob возвращает невыполненное задание в очередь.
+func (srv *server) retryJob(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	err := srv.jobs.RetryJob(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if errors.Is(err, repository.ErrConflict) {
+		http.Error(w, "Такое задание уже стоит в очереди", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при перезапуске задания:", err)
+		http.Error(w, "Ошибка при перезапуске задания", http.StatusInternalServerError)
+		return
+	}
+
+	srv.wakeWorker()
+	log.Printf("Задание №%d перезапущено пользователем %s", id, accessFrom(r.Context()).user.Username)
+	http.Redirect(w, r, "/admin/jobs", http.StatusSeeOther)
+}
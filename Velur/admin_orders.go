@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golangify.com/snippetbox/notify"
+	"golangify.com/snippetbox/orders"
+	"golangify.com/snippetbox/repository"
+	"golangify.com/snippetbox/roles"
+)
+
+// adminOrdersLimit — сколько заказов показывает список; остальные находятся
+// фильтрами.
+const adminOrdersLimit = 200
+
+const dateLayout = "2006-01-02"
+
+// orderFilter разбирает фильтры списка заказов из строки запроса. Дата «по»
+// включает весь указанный день.
+func orderFilter(r *http.Request) (repository.OrderFilter, error) {
+	query := r.URL.Query()
+	filter := repository.OrderFilter{
+		Status: orders.Status(query.Get("status")),
+		Phone:  query.Get("phone"),
+		Limit:  adminOrdersLimit,
+	}
+	if filter.Status != "" && !orders.Known(filter.Status) {
+		return filter, errors.New("Неизвестный статус заказа")
+	}
+	if value := query.Get("from"); value != "" {
+		from, err := time.ParseInLocation(dateLayout, value, time.Local)
+		if err != nil {
+			return filter, errors.New("Некорректная дата")
+		}
+		filter.From = from
+	}
+	if value := query.Get("to"); value != "" {
+		to, err := time.ParseInLocation(dateLayout, value, time.Local)
+		if err != nil {
+			return filter, errors.New("Некорректная дата")
+		}
+		filter.To = to.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+func (srv *server) adminOrders(w http.ResponseWriter, r *http.Request) {
+	filter, err := orderFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	list, err := srv.orders.ListOrders(r.Context(), filter)
+	if err != nil {
+		log.Println("Ошибка при загрузке заказов:", err)
+		http.Error(w, "Ошибка при загрузке заказов", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	err = adminOrdersTpl.Execute(w, map[string]interface{}{
+		"Orders":    list,
+		"Statuses":  orders.Statuses,
+		"Status":    filter.Status,
+		"From":      query.Get("from"),
+		"To":        query.Get("to"),
+		"Phone":     filter.Phone,
+		"Limited":   len(list) == adminOrdersLimit,
+		"Catalog":   accessFrom(r.Context()).can(roles.CatalogManage),
+		"Jobs":      accessFrom(r.Context()).can(roles.JobsManage),
+		"CSRFToken": srv.csrfToken(w, r),
+	})
+	if err != nil {
+		log.Println("Ошибка при рендеринге списка заказов:", err)
+		http.Error(w, "Ошибка при отображении страницы", http.StatusInternalServerError)
+	}
+}
+
+func (srv *server) adminOrder(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	order, err := srv.orders.OrderByID(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при загрузке заказа:", err)
+		http.Error(w, "Ошибка при загрузке заказа", http.StatusInternalServerError)
+		return
+	}
+
+	orderReturns, err := srv.returns.OrderReturns(r.Context(), order.ID)
+	if err != nil {
+		log.Println("Ошибка при загрузке возвратов:", err)
+		http.Error(w, "Ошибка при загрузке заказа", http.StatusInternalServerError)
+		return
+	}
+
+	manage := accessFrom(r.Context()).can(roles.OrdersManage)
+	var next []orders.Status
+	if manage {
+		next = order.Status.Next()
+	}
+	err = adminOrderTpl.Execute(w, map[string]interface{}{
+		"Order":     order,
+		"Next":      next,
+		"Returns":   orderReturns,
+		"Manage":    manage,
+		"CSRFToken": srv.csrfToken(w, r),
+	})
+	if err != nil {
+		log.Println("Ошибка при рендеринге заказа:", err)
+		http.Error(w, "Ошибка при отображении страницы", http.StatusInternalServerError)
+	}
+}
+
+func (srv *server) setOrderStatus(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	status := orders.Status(r.FormValue("status"))
+	user := accessFrom(r.Context()).user
+
+	order, err := srv.orders.OrderByID(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при загрузке заказа:", err)
+		http.Error(w, "Ошибка при загрузке заказа", http.StatusInternalServerError)
+		return
+	}
+
+	// Деньги возвращаются до смены статуса: если шлюз откажет, заказ
+	// останется как был и сотрудник сможет повторить. Возвращается то, что
+	// не вернули раньше по заявкам на возврат. Переход проверяется и возврат
+	// резервируется под блокировкой заказа: двойная отправка формы получит
+	// тот же резерв, а другой переход не пройдёт, пока шлюз не подтвердит
+	// возврат.
+	refund, err := srv.orders.ReserveOrderRefund(r.Context(), id, status)
+	if errors.Is(err, orders.ErrTransition) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка при резервировании возврата по заказу №%d: %v", order.ID, err)
+		http.Error(w, "Ошибка при возврате оплаты", http.StatusInternalServerError)
+		return
+	}
+	if payment := order.Payment; payment != nil {
+		if err := srv.refundPayment(r.Context(), *payment, refund); err != nil {
+			log.Printf("Не удалось вернуть оплату заказа №%d: %v", order.ID, err)
+			http.Error(w, "Не удалось вернуть оплату, попробуйте позже", http.StatusBadGateway)
+			return
+		}
+	}
+
+	order, err = srv.orders.SetOrderStatus(r.Context(), id, status, user.ID, r.FormValue("comment"))
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if errors.Is(err, orders.ErrTransition) || errors.Is(err, repository.ErrRefundPending) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при смене статуса заказа:", err)
+		http.Error(w, "Ошибка при смене статуса заказа", http.StatusInternalServerError)
+		return
+	}
+
+	if orders.Restocks(status) {
+		srv.catalog.Invalidate()
+	}
+	log.Printf("Заказ №%d переведён в статус %q пользователем %s", order.ID, order.Status, user.Username)
+	srv.enqueueMail(r.Context(), notify.StatusChanged, order.Customer.Email, map[string]interface{}{
+		"Order":   order,
+		"Comment": r.FormValue("comment"),
+		"URL":     srv.cfg.URL("/order/" + order.PublicID),
+	})
+	http.Redirect(w, r, "/admin/orders/"+strconv.Itoa(order.ID), http.StatusSeeOther)
+}
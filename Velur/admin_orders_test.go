@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golangify.com/snippetbox/orders"
+	"golangify.com/snippetbox/roles"
+)
+
+// placeOrder оформляет гостевой заказ на позицию item с указанным телефоном
+// и возвращает его номер.
+func placeOrder(t *testing.T, app *testApp, item url.Values, phone string) int {
+	t.Helper()
+	c := app.client(t)
+	c.postForm("/cart/add", item)
+	order := orderForm()
+	order.Set("phone", phone)
+	assertStatus(t, c.postForm("/order", order), http.StatusOK)
+
+	placed := app.store.Orders()
+	return placed[len(placed)-1].ID
+}
+
+func orderPath(id int) string {
+	return "/admin/orders/" + strconv.Itoa(id)
+}
+
+func TestAdminOrderList(t *testing.T) {
+	app := newTestApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 5)
+	first := placeOrder(t, app, accessoryItem(accessory, "1"), "+7 (999) 111-22-33")
+	second := placeOrder(t, app, accessoryItem(accessory, "1"), "+7 (999) 444-55-66")
+	admin := app.adminClient(t)
+	assertRedirect(t, admin.postForm(orderPath(second)+"/status", url.Values{"status": {"confirmed"}}), orderPath(second))
+
+	link := func(id int) string { return `href="` + orderPath(id) + `"` }
+	today := time.Now().Format(dateLayout)
+	tomorrow := time.Now().AddDate(0, 0, 1).Format(dateLayout)
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"", []int{first, second}},
+		{"?status=new", []int{first}},
+		{"?status=confirmed", []int{second}},
+		{"?phone=444-55", []int{second}},
+		{"?phone=8999111", nil},
+		{"?from=" + today + "&to=" + today, []int{first, second}},
+		{"?from=" + tomorrow, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			resp := admin.get("/admin/orders" + tt.query)
+			assertStatus(t, resp, http.StatusOK)
+			for _, id := range []int{first, second} {
+				want := slices.Contains(tt.want, id)
+				if got := strings.Contains(resp.body, link(id)); got != want {
+					t.Fatalf("заказ %d в списке: %v, ожидалось %v", id, got, want)
+				}
+			}
+		})
+	}
+
+	for _, query := range []string{"?status=lost", "?from=01.02.2026", "?to=2026-13-01"} {
+		assertStatus(t, admin.get("/admin/orders"+query), http.StatusBadRequest)
+	}
+}
+
+func TestAdminOrderStatus(t *testing.T) {
+	app := newTestApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 3)
+	id := placeOrder(t, app, accessoryItem(accessory, "2"), "+79990000000")
+	admin := app.adminClient(t)
+
+	resp := admin.get(orderPath(id))
+	assertStatus(t, resp, http.StatusOK)
+	assertContains(t, resp.body, "Кожаная сумка")
+	assertContains(t, resp.body, `value="confirmed"`)
+	assertContains(t, resp.body, `value="cancelled"`)
+	assertStatus(t, admin.get("/admin/orders/999"), http.StatusNotFound)
+
+	form := url.Values{"status": {"confirmed"}, "comment": {"Позвонили покупателю"}}
+	assertRedirect(t, admin.postForm(orderPath(id)+"/status", form), orderPath(id))
+	resp = admin.get(orderPath(id))
+	assertContains(t, resp.body, "Новый → Подтверждён")
+	assertContains(t, resp.body, "Позвонили покупателю")
+	assertContains(t, resp.body, "<td>admin</td>")
+
+	// Подтверждённый заказ нельзя сразу отправить или вернуть в «новые».
+	for _, status := range []string{"shipped", "new", "lost"} {
+		resp := admin.postForm(orderPath(id)+"/status", url.Values{"status": {status}})
+		assertStatus(t, resp, http.StatusConflict)
+	}
+	assertStatus(t, admin.postForm("/admin/orders/999/status", url.Values{"status": {"confirmed"}}), http.StatusNotFound)
+
+	order, err := app.store.OrderByID(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != orders.Confirmed || len(order.History) != 2 {
+		t.Fatalf("статус %q, записей в истории %d", order.Status, len(order.History))
+	}
+	if change := order.History[1]; change.From != orders.New || change.To != orders.Confirmed || change.ChangedBy != "admin" {
+		t.Fatalf("запись истории: %+v", change)
+	}
+}
+
+func TestCancelledOrderRestocks(t *testing.T) {
+	app := newTestApp(t)
+	clothing := app.seedClothing(t, "Льняное платье", 2)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 3)
+	id := placeOrder(t, app, clothingItem(clothing, 0, "2"), "+79990000000")
+	admin := app.adminClient(t)
+
+	app.reload(t)
+	if variant, _ := app.srv.catalog.Snapshot().Clothes[clothing.ID].Variant(clothing.Variants[0].ID); variant.Stock != 0 {
+		t.Fatalf("остаток после заказа %d", variant.Stock)
+	}
+
+	assertRedirect(t, admin.postForm(orderPath(id)+"/status", url.Values{"status": {"cancelled"}}), orderPath(id))
+	app.reload(t)
+	if variant, _ := app.srv.catalog.Snapshot().Clothes[clothing.ID].Variant(clothing.Variants[0].ID); variant.Stock != 2 {
+		t.Fatalf("остаток после отмены %d, ожидался 2", variant.Stock)
+	}
+
+	// Возврат доставленного заказа остатки не меняет: товар сначала
+	// осматривают.
+	other := placeOrder(t, app, accessoryItem(accessory, "1"), "+79990000000")
+	for _, status := range []string{"confirmed", "packed", "shipped", "delivered", "returned"} {
+		assertRedirect(t, admin.postForm(orderPath(other)+"/status", url.Values{"status": {status}}), orderPath(other))
+	}
+	app.reload(t)
+	if a, _ := app.srv.catalog.Accessory(accessory.ID); a.Stock != 2 {
+		t.Fatalf("остаток аксессуара %d, ожидался 2", a.Stock)
+	}
+	assertStatus(t, admin.get(orderPath(other)), http.StatusOK)
+}
+
+func TestAdminOrdersPermissions(t *testing.T) {
+	app := newTestApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 3)
+	id := placeOrder(t, app, accessoryItem(accessory, "1"), "+79990000000")
+
+	clients := map[string]*testClient{}
+	for _, role := range []string{roles.Customer, roles.Support, roles.CatalogManager, roles.OrderManager} {
+		app.createUser(t, role, "secret", role)
+		clients[role] = app.client(t)
+		clients[role].login(role, "secret")
+	}
+
+	for role, c := range clients {
+		view := http.StatusForbidden
+		if role == roles.Support || role == roles.OrderManager {
+			view = http.StatusOK
+		}
+		assertStatus(t, c.get("/admin/orders"), view)
+		resp := c.get(orderPath(id))
+		assertStatus(t, resp, view)
+		if role == roles.Support && strings.Contains(resp.body, "Сменить статус") {
+			t.Fatal("поддержке показана смена статуса")
+		}
+	}
+
+	for _, role := range []string{roles.Customer, roles.Support, roles.CatalogManager} {
+		resp := clients[role].postForm(orderPath(id)+"/status", url.Values{"status": {"confirmed"}})
+		assertStatus(t, resp, http.StatusForbidden)
+	}
+	if order, _ := app.store.OrderByID(context.Background(), id); order.Status != orders.New {
+		t.Fatalf("статус изменён без прав: %q", order.Status)
+	}
+
+	// Ссылку на заказы из каталога видят только те, кому они доступны.
+	app.createUser(t, "admin", "secret", roles.Admin)
+	admin := app.client(t)
+	admin.login("admin", "secret")
+	assertContains(t, admin.get("/admin").body, `href="/admin/orders"`)
+	if strings.Contains(clients[roles.CatalogManager].get("/admin").body, `href="/admin/orders"`) {
+		t.Fatal("менеджеру каталога показана ссылка на заказы")
+	}
+
+	c := clients[roles.OrderManager]
+	assertRedirect(t, c.postForm(orderPath(id)+"/status", url.Values{"status": {"confirmed"}}), orderPath(id))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/gorilla/sessions"
+	"golangify.com/snippetbox/repository"
+	"golangify.com/snippetbox/roles"
+)
+
+type accessKey struct{}
+
+// access — пользователь запроса и права его роли. Роль читается из базы на
+// каждый запрос, а не из cookie, поэтому смена роли действует сразу.
+type access struct {
+	user        repository.User
+	permissions []roles.Permission
+}
+
+func (a *access) can(permission roles.Permission) bool {
+	return a != nil && slices.Contains(a.permissions, permission)
+}
+
+func accessFrom(ctx context.Context) *access {
+	a, _ := ctx.Value(accessKey{}).(*access)
+	return a
+}
+
+// requireStaff пропускает в админ-панель только пользователей, у роли которых
+// есть хотя бы одно право.
+func (srv *server) requireStaff(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, _ := srv.store.Get(r, "session-name")
+		userID, ok := sessionUserID(session)
+		if !ok {
+			http.Error(w, "Доступ запрещен", http.StatusForbidden)
+			return
+		}
+
+		user, err := srv.users.UserByID(r.Context(), userID)
+		if errors.Is(err, repository.ErrNotFound) {
+			http.Error(w, "Доступ запрещен", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Println("Ошибка при загрузке пользователя:", err)
+			http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+
+		permissions, err := srv.users.RolePermissions(r.Context(), user.Role)
+		if err != nil {
+			log.Println("Ошибка при загрузке прав роли:", err)
+			http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		if len(permissions) == 0 {
+			http.Error(w, "Доступ запрещен", http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), accessKey{}, &access{user: user, permissions: permissions})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// require оборачивает обработчик админ-панели проверкой права permission.
+// Работает только под requireStaff.
+func (srv *server) require(permission roles.Permission, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !accessFrom(r.Context()).can(permission) {
+			http.Error(w, "Доступ запрещен", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// endSession выходит из учётной записи, сохраняя гостевую корзину.
+// CSRF-токен тоже сбрасывается: он был выдан вошедшему пользователю.
+func endSession(session *sessions.Session) {
+	for _, key := range []string{"user_id", "username", "role", "session_version", "csrf_token"} {
+		delete(session.Values, key)
+	}
+}
+
+// checkSession завершает сессию, если пользователь удалён или сменил пароль
+// после входа: при смене пароля в базе растёт session_version. Сессии,
+// выданные до появления версии, считаются выданными при первой.
+func (srv *server) checkSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, _ := srv.store.Get(r, "session-name")
+		userID, ok := sessionUserID(session)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := srv.users.UserByID(r.Context(), userID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			log.Println("Ошибка при загрузке пользователя:", err)
+			http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		version, ok := session.Values["session_version"].(int)
+		if !ok {
+			version = 1
+		}
+		if err != nil || user.SessionVersion != version {
+			endSession(session)
+			if err := session.Save(r, w); err != nil {
+				log.Println("Ошибка при сохранении сессии:", err)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminHome возвращает ссылку на админ-панель для пользователя сессии или
+// пустую строку, если ему там нечего открыть.
+func (srv *server) adminHome(ctx context.Context, session *sessions.Session) string {
+	role, ok := session.Values["role"].(string)
+	if !ok || role == roles.Customer {
+		return ""
+	}
+	permissions, err := srv.users.RolePermissions(ctx, role)
+	if err != nil {
+		log.Println("Ошибка при загрузке прав роли:", err)
+		return ""
+	}
+	return roles.Home(permissions)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golangify.com/snippetbox/roles"
+)
+
+func TestAdminRoutesRequirePermission(t *testing.T) {
+	app := newTestApp(t)
+	clothing := app.seedClothing(t, "Льняное платье", 2)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 1)
+
+	routes := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/admin"},
+		{http.MethodPost, "/admin/add-clothing"},
+		{http.MethodPost, "/admin/add-accessory"},
+		{http.MethodGet, "/admin/edit-clothing/" + clothing.ID},
+		{http.MethodPost, "/admin/edit-clothing/" + clothing.ID},
+		{http.MethodGet, "/admin/edit-accessory/" + accessory.ID},
+		{http.MethodPost, "/admin/edit-accessory/" + accessory.ID},
+		{http.MethodPost, "/admin/delete-clothing/" + clothing.ID},
+		{http.MethodPost, "/admin/delete-accessory/" + accessory.ID},
+		{http.MethodPost, "/admin/add-variant/" + clothing.ID},
+		{http.MethodPost, "/admin/update-variant/" + clothing.Variants[0].ID},
+		{http.MethodPost, "/admin/delete-variant/" + clothing.Variants[0].ID},
+		{http.MethodPost, "/admin/set-stock-accessory/" + accessory.ID},
+		{http.MethodPost, "/admin/add-clothing-image/" + clothing.ID},
+		{http.MethodPost, "/admin/add-accessory-image/" + accessory.ID},
+		{http.MethodPost, "/admin/update-image/" + clothing.Images[0].ID},
+		{http.MethodPost, "/admin/move-image/" + clothing.Images[0].ID},
+		{http.MethodPost, "/admin/delete-image/" + clothing.Images[0].ID},
+	}
+
+	clients := map[string]*testClient{"guest": app.client(t)}
+	for _, role := range []string{roles.Customer, roles.Support, roles.OrderManager} {
+		app.createUser(t, role, "secret", role)
+		clients[role] = app.client(t)
+		clients[role].login(role, "secret")
+	}
+	// До введения ролей администратором считался пользователь с именем
+	// admin; без роли администратора у него теперь нет прав.
+	app.createUser(t, "admin", "secret", roles.Customer)
+	clients["legacy admin username"] = app.client(t)
+	clients["legacy admin username"].login("admin", "secret")
+
+	for name, c := range clients {
+		for _, route := range routes {
+			t.Run(name+" "+route.method+" "+route.path, func(t *testing.T) {
+				var resp testResponse
+				if route.method == http.MethodGet {
+					resp = c.get(route.path)
+				} else {
+					resp = c.postForm(route.path, url.Values{"stock": {"0"}})
+				}
+				assertStatus(t, resp, http.StatusForbidden)
+			})
+		}
+	}
+
+	// Отклонённые запросы ничего не изменили.
+	app.reload(t)
+	if _, exists := app.srv.catalog.Clothing(clothing.ID); !exists {
+		t.Fatal("одежда удалена без прав")
+	}
+	if a, _ := app.srv.catalog.Accessory(accessory.ID); a.Stock != 1 {
+		t.Fatalf("остаток аксессуара изменён без прав: %d", a.Stock)
+	}
+}
+
+func TestAdminPagesForCatalogRoles(t *testing.T) {
+	app := newTestApp(t)
+	clothing := app.seedClothing(t, "Льняное платье", 2)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 1)
+
+	for _, role := range []string{roles.Admin, roles.CatalogManager} {
+		t.Run(role, func(t *testing.T) {
+			app.createUser(t, role, "secret", role)
+			c := app.client(t)
+			c.login(role, "secret")
+
+			for _, path := range []string{"/admin", "/admin/edit-clothing/" + clothing.ID, "/admin/edit-accessory/" + accessory.ID} {
+				assertStatus(t, c.get(path), http.StatusOK)
+			}
+			assertStatus(t, c.get("/admin/edit-clothing/999"), http.StatusNotFound)
+		})
+	}
+}
+
+func TestAdminLinkForStaff(t *testing.T) {
+	app := newTestApp(t)
+
+	tests := []struct {
+		role string
+		link string
+	}{
+		{roles.Customer, ""},
+		{roles.Admin, "/admin"},
+		{roles.CatalogManager, "/admin"},
+		{roles.OrderManager, "/admin/orders"},
+		{roles.Support, "/admin/orders"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			app.createUser(t, tt.role, "secret", tt.role)
+			c := app.client(t)
+			c.login(tt.role, "secret")
+
+			for _, page := range []string{"/", "/about"} {
+				body := c.get(page).body
+				if tt.link == "" {
+					if strings.Contains(body, "Админ-панель") {
+						t.Fatalf("на %s показана ссылка на админ-панель", page)
+					}
+					continue
+				}
+				assertContains(t, body, `<a href="`+tt.link+`">Админ-панель</a>`)
+			}
+			// Ссылка ведёт на страницу, которую роль может открыть.
+			if tt.link != "" {
+				assertStatus(t, c.get(tt.link), http.StatusOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/sessions"
+	"golangify.com/snippetbox/products"
+	"golangify.com/snippetbox/repository"
+)
+
+// noVariant — вариант позиции для аксессуаров, у которых нет размеров.
+const noVariant = "0"
+
+func init() {
+	// Корзина гостя хранится в сессии как map["категория:id:вариант"]количество.
+	gob.Register(map[string]int{})
+}
+
+// cartItem однозначно определяет позицию корзины: товар и, для одежды,
+// выбранный вариант (размер и цвет).
+type cartItem repository.CartItem
+
+func (i cartItem) key() string {
+	return i.Category + ":" + i.ProductID + ":" + i.VariantID
+}
+
+func parseCartKey(key string) (cartItem, bool) {
+	parts := strings.SplitN(key, ":", 3)
+	if len(parts) != 3 {
+		return cartItem{}, false
+	}
+	return cartItem{Category: parts[0], ProductID: parts[1], VariantID: parts[2]}, true
+}
+
+// parseCartItem читает позицию из формы. Для одежды вариант обязателен,
+// для аксессуаров игнорируется.
+func parseCartItem(r *http.Request, variantField string) (cartItem, error) {
+	item := cartItem{
+		Category:  r.FormValue("category"),
+		ProductID: r.FormValue("product_id"),
+		VariantID: noVariant,
+	}
+
+	if _, err := strconv.Atoi(item.ProductID); err != nil {
+		return cartItem{}, errors.New("некорректный товар")
+	}
+
+	switch item.Category {
+	case products.CategoryClothing:
+		item.VariantID = r.FormValue(variantField)
+		if _, err := strconv.Atoi(item.VariantID); err != nil {
+			return cartItem{}, errors.New("выберите размер")
+		}
+	case products.CategoryAccessory:
+	default:
+		return cartItem{}, errors.New("некорректная категория товара")
+	}
+	return item, nil
+}
+
+type cartLine struct {
+	cartItem
+	Name     string
+	ImageURL string
+	Size     string
+	Color    string
+	Price    float64
+	Quantity int
+	Stock    int
+	Variants []products.Variant
+}
+
+func (l cartLine) Available() bool {
+	return l.Quantity <= l.Stock
+}
+
+func (l cartLine) Subtotal() float64 {
+	return l.Price * float64(l.Quantity)
+}
+
+func (l cartLine) CategoryName() string {
+	if l.Category == products.CategoryClothing {
+		return "Одежда"
+	}
+	return "Аксессуары"
+}
+
+func sessionUserID(session *sessions.Session) (int, bool) {
+	userID, ok := session.Values["user_id"].(int)
+	return userID, ok
+}
+
+func sessionCart(session *sessions.Session) map[string]int {
+	cart, ok := session.Values["cart"].(map[string]int)
+	if !ok {
+		cart = map[string]int{}
+	}
+	return cart
+}
+
+func (srv *server) loadCart(ctx context.Context, session *sessions.Session) (map[string]int, error) {
+	userID, ok := sessionUserID(session)
+	if !ok {
+		return sessionCart(session), nil
+	}
+
+	items, err := srv.carts.Cart(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	cart := make(map[string]int, len(items))
+	for item, quantity := range items {
+		cart[cartItem(item).key()] = quantity
+	}
+	return cart, nil
+}
+
+func (srv *server) setCartQuantity(w http.ResponseWriter, r *http.Request, session *sessions.Session, item cartItem, quantity int) error {
+	if userID, ok := sessionUserID(session); ok {
+		return srv.carts.SetCartQuantity(r.Context(), userID, repository.CartItem(item), quantity)
+	}
+
+	cart := sessionCart(session)
+	if quantity <= 0 {
+		delete(cart, item.key())
+	} else {
+		cart[item.key()] = quantity
+	}
+	session.Values["cart"] = cart
+	return session.Save(r, w)
+}
+
+// mergeSessionCart переносит корзину гостя в базу после входа в аккаунт.
+func (srv *server) mergeSessionCart(ctx context.Context, session *sessions.Session, userID int) error {
+	for key, quantity := range sessionCart(session) {
+		item, ok := parseCartKey(key)
+		if !ok {
+			continue
+		}
+		if err := srv.carts.AddToCart(ctx, userID, repository.CartItem(item), quantity); err != nil {
+			return err
+		}
+	}
+	delete(session.Values, "cart")
+	return nil
+}
+
+// cartLines сопоставляет позиции корзины с каталогом. Товары и варианты,
+// которых больше нет в каталоге, пропускаются.
+func (srv *server) cartLines(cart map[string]int) ([]cartLine, float64) {
+	keys := make([]string, 0, len(cart))
+	for key := range cart {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	snapshot := srv.catalog.Snapshot()
+	var lines []cartLine
+	var total float64
+	for _, key := range keys {
+		item, ok := parseCartKey(key)
+		if !ok {
+			continue
+		}
+		line := cartLine{cartItem: item, Quantity: cart[key]}
+
+		switch item.Category {
+		case products.CategoryClothing:
+			clothing, exists := snapshot.Clothes[item.ProductID]
+			if !exists {
+				continue
+			}
+			variant, exists := clothing.Variant(item.VariantID)
+			if !exists {
+				continue
+			}
+			line.Name, line.ImageURL, line.Price = clothing.Name, clothing.ImageURL, clothing.VariantPrice(variant)
+			line.Size, line.Color, line.Stock = variant.Size, variant.Color, variant.Stock
+			line.Variants = clothing.Variants
+		case products.CategoryAccessory:
+			accessory, exists := snapshot.Accessories[item.ProductID]
+			if !exists {
+				continue
+			}
+			line.Name, line.ImageURL, line.Price = accessory.Name, accessory.ImageURL, accessory.Price
+			line.Color, line.Stock = accessory.Color, accessory.Stock
+		default:
+			continue
+		}
+
+		lines = append(lines, line)
+		total += line.Subtotal()
+	}
+	return lines, total
+}
+
+func (srv *server) cartCount(ctx context.Context, session *sessions.Session) int {
+	cart, err := srv.loadCart(ctx, session)
+	if err != nil {
+		log.Println("Ошибка при загрузке корзины:", err)
+		return 0
+	}
+
+	count := 0
+	for _, quantity := range cart {
+		count += quantity
+	}
+	return count
+}
+
+func (srv *server) productStock(item cartItem) (int, bool) {
+	switch item.Category {
+	case products.CategoryClothing:
+		clothing, exists := srv.catalog.Clothing(item.ProductID)
+		if !exists {
+			return 0, false
+		}
+		variant, exists := clothing.Variant(item.VariantID)
+		return variant.Stock, exists
+	case products.CategoryAccessory:
+		accessory, exists := srv.catalog.Accessory(item.ProductID)
+		return accessory.Stock, exists
+	}
+	return 0, false
+}
+
+// cartRedirect возвращает покупателя туда, откуда пришла форма: со страницы
+// оформления заказа — обратно к оформлению, иначе в корзину.
+func cartRedirect(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("next") == "/checkout" {
+		http.Redirect(w, r, "/checkout", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+func (srv *server) cartHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := srv.store.Get(r, "session-name")
+	cart, err := srv.loadCart(r.Context(), session)
+	if err != nil {
+		log.Println("Ошибка при загрузке корзины:", err)
+		http.Error(w, "Ошибка при загрузке корзины", http.StatusInternalServerError)
+		return
+	}
+
+	lines, total := srv.cartLines(cart)
+	data := map[string]interface{}{
+		"Lines":     lines,
+		"Total":     total,
+		"CSRFToken": srv.csrfToken(w, r),
+	}
+
+	if err := cartTpl.Execute(w, data); err != nil {
+		log.Println("Ошибка при рендеринге шаблона корзины:", err)
+		http.Error(w, "Ошибка при отображении страницы", http.StatusInternalServerError)
+	}
+}
+
+func (srv *server) addToCartHandler(w http.ResponseWriter, r *http.Request) {
+	item, err := parseCartItem(r, "variant_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stock, exists := srv.productStock(item)
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	quantity := 1
+	if quantityStr := r.FormValue("quantity"); quantityStr != "" {
+		quantity, err = strconv.Atoi(quantityStr)
+		if err != nil || quantity < 1 {
+			http.Error(w, "Некорректное количество", http.StatusBadRequest)
+			return
+		}
+	}
+
+	session, _ := srv.store.Get(r, "session-name")
+	cart, err := srv.loadCart(r.Context(), session)
+	if err != nil {
+		log.Println("Ошибка при загрузке корзины:", err)
+		http.Error(w, "Ошибка при загрузке корзины", http.StatusInternalServerError)
+		return
+	}
+
+	quantity += cart[item.key()]
+	if quantity > stock {
+		http.Error(w, "Недостаточно товара на складе", http.StatusConflict)
+		return
+	}
+
+	if err := srv.setCartQuantity(w, r, session, item, quantity); err != nil {
+		log.Println("Ошибка при сохранении корзины:", err)
+		http.Error(w, "Ошибка при сохранении корзины", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+func (srv *server) updateCartHandler(w http.ResponseWriter, r *http.Request) {
+	item, err := parseCartItem(r, "variant_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	quantity, err := strconv.Atoi(r.FormValue("quantity"))
+	if err != nil || quantity < 0 {
+		http.Error(w, "Некорректное количество", http.StatusBadRequest)
+		return
+	}
+
+	if quantity > 0 {
+		stock, exists := srv.productStock(item)
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		if quantity > stock {
+			http.Error(w, "Недостаточно товара на складе", http.StatusConflict)
+			return
+		}
+	}
+
+	session, _ := srv.store.Get(r, "session-name")
+	if err := srv.setCartQuantity(w, r, session, item, quantity); err != nil {
+		log.Println("Ошибка при сохранении корзины:", err)
+		http.Error(w, "Ошибка при сохранении корзины", http.StatusInternalServerError)
+		return
+	}
+
+	cartRedirect(w, r)
+}
+
+// changeVariantHandler переносит позицию одежды на другой размер или цвет
+// того же товара, сохраняя количество.
+func (srv *server) changeVariantHandler(w http.ResponseWriter, r *http.Request) {
+	item, err := parseCartItem(r, "variant_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	target, err := parseCartItem(r, "new_variant_id")
+	if err != nil || target.Category != products.CategoryClothing {
+		http.Error(w, "Выберите размер", http.StatusBadRequest)
+		return
+	}
+	if target == item {
+		cartRedirect(w, r)
+		return
+	}
+
+	session, _ := srv.store.Get(r, "session-name")
+	cart, err := srv.loadCart(r.Context(), session)
+	if err != nil {
+		log.Println("Ошибка при загрузке корзины:", err)
+		http.Error(w, "Ошибка при загрузке корзины", http.StatusInternalServerError)
+		return
+	}
+
+	quantity := cart[item.key()]
+	if quantity == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	stock, exists := srv.productStock(target)
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	quantity += cart[target.key()]
+	if quantity > stock {
+		http.Error(w, "Недостаточно товара на складе", http.StatusConflict)
+		return
+	}
+
+	if err := srv.setCartQuantity(w, r, session, item, 0); err != nil {
+		log.Println("Ошибка при сохранении корзины:", err)
+		http.Error(w, "Ошибка при сохранении корзины", http.StatusInternalServerError)
+		return
+	}
+	if err := srv.setCartQuantity(w, r, session, target, quantity); err != nil {
+		log.Println("Ошибка при сохранении корзины:", err)
+		http.Error(w, "Ошибка при сохранении корзины", http.StatusInternalServerError)
+		return
+	}
+
+	cartRedirect(w, r)
+}
+
+func (srv *server) removeFromCartHandler(w http.ResponseWriter, r *http.Request) {
+	item, err := parseCartItem(r, "variant_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	session, _ := srv.store.Get(r, "session-name")
+	if err := srv.setCartQuantity(w, r, session, item, 0); err != nil {
+		log.Println("Ошибка при сохранении корзины:", err)
+		http.Error(w, "Ошибка при сохранении корзины", http.StatusInternalServerError)
+		return
+	}
+
+	cartRedirect(w, r)
+}
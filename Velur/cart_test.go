@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"golangify.com/snippetbox/products"
+	"golangify.com/snippetbox/repository"
+)
+
+func clothingItem(clothing products.Clothing, variant int, quantity string) url.Values {
+	return url.Values{
+		"category":   {products.CategoryClothing},
+		"product_id": {clothing.ID},
+		"variant_id": {clothing.Variants[variant].ID},
+		"quantity":   {quantity},
+	}
+}
+
+func accessoryItem(accessory products.Accessory, quantity string) url.Values {
+	return url.Values{
+		"category":   {products.CategoryAccessory},
+		"product_id": {accessory.ID},
+		"quantity":   {quantity},
+	}
+}
+
+func TestAddToCart(t *testing.T) {
+	app := newTestApp(t)
+	clothing := app.seedClothing(t, "Льняное платье", 2)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 1)
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		status int
+	}{
+		{"clothing", clothingItem(clothing, 0, "2"), http.StatusSeeOther},
+		{"accessory", accessoryItem(accessory, ""), http.StatusSeeOther},
+		{"bad quantity", clothingItem(clothing, 0, "два"), http.StatusBadRequest},
+		{"zero quantity", clothingItem(clothing, 0, "0"), http.StatusBadRequest},
+		{"no variant", url.Values{"category": {"clothing"}, "product_id": {clothing.ID}}, http.StatusBadRequest},
+		{"bad category", url.Values{"category": {"shoes"}, "product_id": {clothing.ID}}, http.StatusBadRequest},
+		{"bad product", url.Values{"category": {"accessory"}, "product_id": {"сумка"}}, http.StatusBadRequest},
+		{"unknown product", url.Values{"category": {"accessory"}, "product_id": {"999"}}, http.StatusNotFound},
+		{"over stock", clothingItem(clothing, 1, "3"), http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := app.client(t)
+			assertStatus(t, c.postForm("/cart/add", tt.form), tt.status)
+		})
+	}
+}
+
+func TestCartKeepsQuantityWithinStock(t *testing.T) {
+	app := newTestApp(t)
+	clothing := app.seedClothing(t, "Льняное платье", 2)
+	c := app.client(t)
+
+	assertRedirect(t, c.postForm("/cart/add", clothingItem(clothing, 0, "1")), "/cart")
+	assertRedirect(t, c.postForm("/cart/add", clothingItem(clothing, 0, "1")), "/cart")
+	assertStatus(t, c.postForm("/cart/add", clothingItem(clothing, 0, "1")), http.StatusConflict)
+
+	resp := c.get("/cart")
+	assertStatus(t, resp, http.StatusOK)
+	assertContains(t, resp.body, "Льняное платье")
+	assertContains(t, resp.body, "2000")
+}
+
+func TestUpdateAndRemoveCartItems(t *testing.T) {
+	app := newTestApp(t)
+	clothing := app.seedClothing(t, "Льняное платье", 2)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 5)
+	c := app.client(t)
+
+	c.postForm("/cart/add", clothingItem(clothing, 0, "1"))
+	c.postForm("/cart/add", accessoryItem(accessory, "1"))
+
+	assertRedirect(t, c.postForm("/cart/update", accessoryItem(accessory, "4")), "/cart")
+	assertStatus(t, c.postForm("/cart/update", accessoryItem(accessory, "6")), http.StatusConflict)
+	assertStatus(t, c.postForm("/cart/update", accessoryItem(accessory, "-1")), http.StatusBadRequest)
+
+	form := clothingItem(clothing, 0, "")
+	form.Set("new_variant_id", clothing.Variants[1].ID)
+	form.Set("next", "/checkout")
+	assertRedirect(t, c.postForm("/cart/change-variant", form), "/checkout")
+
+	cart := cartOf(t, app, c)
+	want := map[string]int{
+		cartItem{products.CategoryClothing, clothing.ID, clothing.Variants[1].ID}.key(): 1,
+		cartItem{products.CategoryAccessory, accessory.ID, noVariant}.key():             4,
+	}
+	if len(cart) != len(want) {
+		t.Fatalf("корзина %v, ожидалась %v", cart, want)
+	}
+	for key, quantity := range want {
+		if cart[key] != quantity {
+			t.Fatalf("корзина %v, ожидалась %v", cart, want)
+		}
+	}
+
+	assertRedirect(t, c.postForm("/cart/remove", accessoryItem(accessory, "")), "/cart")
+	if cart := cartOf(t, app, c); len(cart) != 1 {
+		t.Fatalf("после удаления в корзине %v", cart)
+	}
+}
+
+// cartOf читает корзину посетителя через его cookie сессии.
+func cartOf(t *testing.T, app *testApp, c *testClient) map[string]int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, app.ts.URL, nil)
+	for _, cookie := range c.client.Jar.Cookies(req.URL) {
+		req.AddCookie(cookie)
+	}
+	session, err := app.srv.store.Get(req, "session-name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cart, err := app.srv.loadCart(context.Background(), session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cart
+}
+
+func TestGuestCartMergedOnLogin(t *testing.T) {
+	app := newTestApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 5)
+	app.createUser(t, "anna", "secret", "user")
+	c := app.client(t)
+
+	c.postForm("/cart/add", accessoryItem(accessory, "2"))
+	c.login("anna", "secret")
+
+	user, _ := app.store.UserByUsername(context.Background(), "anna")
+	cart, err := app.store.Cart(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := repository.CartItem{Category: products.CategoryAccessory, ProductID: accessory.ID, VariantID: noVariant}
+	if cart[item] != 2 {
+		t.Fatalf("корзина пользователя %v", cart)
+	}
+}
+
+func orderForm() url.Values {
+	return url.Values{
+		"first_name": {"Анна"},
+		"last_name":  {"Иванова"},
+		"phone":      {"+79990000000"},
+		"region":     {"Московская область"},
+		"city":       {"Москва"},
+		"street":     {"Тверская"},
+		"house":      {"1"},
+	}
+}
+
+func TestCheckout(t *testing.T) {
+	app := newTestApp(t)
+	clothing := app.seedClothing(t, "Льняное платье", 2)
+	c := app.client(t)
+
+	assertRedirect(t, c.get("/checkout"), "/cart")
+
+	c.postForm("/cart/add", clothingItem(clothing, 0, "1"))
+	resp := c.get("/checkout")
+	assertStatus(t, resp, http.StatusOK)
+	assertContains(t, resp.body, "Льняное платье")
+}
+
+func TestSubmitOrder(t *testing.T) {
+	app := newTestApp(t)
+	clothing := app.seedClothing(t, "Льняное платье", 2)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 3)
+	app.createUser(t, "anna", "secret", "user")
+	c := app.client(t)
+	c.login("anna", "secret")
+
+	c.postForm("/cart/add", clothingItem(clothing, 1, "2"))
+	c.postForm("/cart/add", accessoryItem(accessory, "1"))
+
+	resp := c.postForm("/order", orderForm())
+	assertStatus(t, resp, http.StatusOK)
+	assertContains(t, resp.body, "Заказ оформлен")
+
+	orders := app.store.Orders()
+	if len(orders) != 1 {
+		t.Fatalf("оформлено %d заказов", len(orders))
+	}
+	if order := orders[0]; order.Total != 2*1000+300 || len(order.Items) != 2 || order.Customer.City != "Москва" {
+		t.Fatalf("заказ сохранён неверно: %+v", order)
+	}
+
+	app.reload(t)
+	if variant, _ := app.srv.catalog.Snapshot().Clothes[clothing.ID].Variant(clothing.Variants[1].ID); variant.Stock != 0 {
+		t.Fatalf("остаток варианта %d, ожидался 0", variant.Stock)
+	}
+	if cart := cartOf(t, app, c); len(cart) != 0 {
+		t.Fatalf("корзина не очищена: %v", cart)
+	}
+
+	assertStatus(t, c.postForm("/order", orderForm()), http.StatusBadRequest)
+}
+
+func TestSubmitOrderConflicts(t *testing.T) {
+	t.Run("out of stock", func(t *testing.T) {
+		app := newTestApp(t)
+		accessory := app.seedAccessory(t, "Кожаная сумка", 2)
+		c := app.client(t)
+		c.postForm("/cart/add", accessoryItem(accessory, "2"))
+
+		// Пока покупатель оформлял заказ, часть товара продали.
+		app.store.SetAccessoryStock(context.Background(), accessory.ID, 1)
+
+		resp := c.postForm("/order", orderForm())
+		assertStatus(t, resp, http.StatusConflict)
+		assertContains(t, resp.body, "осталось только 1")
+		if n := len(app.store.Orders()); n != 0 {
+			t.Fatalf("оформлено %d заказов", n)
+		}
+	})
+
+	t.Run("product removed", func(t *testing.T) {
+		app := newTestApp(t)
+		accessory := app.seedAccessory(t, "Кожаная сумка", 2)
+		c := app.client(t)
+		c.postForm("/cart/add", accessoryItem(accessory, "1"))
+
+		// Каталог в памяти ещё не знает об удалении, а хранилище — уже да.
+		app.store.DeleteAccessory(context.Background(), accessory.ID)
+
+		assertStatus(t, c.postForm("/order", orderForm()), http.StatusConflict)
+	})
+}
@@ -0,0 +1,127 @@
+// Package catalog держит каталог товаров в памяти.
+//
+// Обработчики читают неизменяемый снимок каталога через атомарный указатель
+// и не обращаются к базе на каждый запрос. Снимок заменяется целиком: при
+// периодической перезагрузке из базы или при правке из админки, которая
+// копирует текущий снимок, меняет копию и публикует её (copy-on-write).
+// Поэтому уже полученный снимок никогда не меняется под читателем.
+package catalog
+
+import (
+	"context"
+	"log"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golangify.com/snippetbox/products"
+)
+
+// Snapshot — состояние каталога на один момент времени. Снимок, полученный
+// из Catalog, нельзя изменять: менять каталог можно только через Update.
+type Snapshot struct {
+	Clothes     map[string]products.Clothing
+	Accessories map[string]products.Accessory
+}
+
+func (s *Snapshot) clone() *Snapshot {
+	return &Snapshot{
+		Clothes:     maps.Clone(s.Clothes),
+		Accessories: maps.Clone(s.Accessories),
+	}
+}
+
+// Loader читает полный каталог из постоянного хранилища.
+type Loader func(ctx context.Context) (*Snapshot, error)
+
+type Catalog struct {
+	load    Loader
+	current atomic.Pointer[Snapshot]
+	changed chan struct{}
+
+	// mu упорядочивает запись: перезагрузка и Update не должны затирать
+	// результаты друг друга. Читатели mu не берут.
+	mu sync.Mutex
+}
+
+// New создаёт пустой каталог. Чтобы заполнить его, вызовите Reload.
+func New(load Loader) *Catalog {
+	c := &Catalog{load: load, changed: make(chan struct{}, 1)}
+	c.current.Store(&Snapshot{
+		Clothes:     map[string]products.Clothing{},
+		Accessories: map[string]products.Accessory{},
+	})
+	return c
+}
+
+// Snapshot возвращает текущий снимок каталога.
+func (c *Catalog) Snapshot() *Snapshot {
+	return c.current.Load()
+}
+
+func (c *Catalog) Clothing(id string) (products.Clothing, bool) {
+	clothing, ok := c.Snapshot().Clothes[id]
+	return clothing, ok
+}
+
+func (c *Catalog) Accessory(id string) (products.Accessory, bool) {
+	accessory, ok := c.Snapshot().Accessories[id]
+	return accessory, ok
+}
+
+// Reload загружает каталог заново. При ошибке остаётся прежний снимок.
+func (c *Catalog) Reload(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	snapshot, err := c.load(ctx)
+	if err != nil {
+		return err
+	}
+	c.current.Store(snapshot)
+	return nil
+}
+
+// Update применяет fn к копии текущего снимка и публикует копию. Вызывается
+// после того, как изменение уже сохранено в базе. Значения в картах fn
+// должна заменять, а не менять на месте: срезы вариантов общие с прежним
+// снимком.
+func (c *Catalog) Update(fn func(s *Snapshot)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	next := c.current.Load().clone()
+	fn(next)
+	c.current.Store(next)
+}
+
+// Invalidate просит Run перезагрузить каталог, не дожидаясь таймера.
+// Используется, когда данные в базе изменились не через Update, например
+// остатки после оформления заказа.
+func (c *Catalog) Invalidate() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run перезагружает каталог каждые interval и после Invalidate, пока не
+// будет отменён ctx.
+func (c *Catalog) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-c.changed:
+		}
+
+		if err := c.Reload(ctx); err != nil {
+			log.Println("Ошибка при обновлении каталога:", err)
+		}
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golangify.com/snippetbox/config"
+	"golangify.com/snippetbox/images"
+	"golangify.com/snippetbox/migrations"
+	"golangify.com/snippetbox/products"
+	"golangify.com/snippetbox/repository"
+	"golangify.com/snippetbox/roles"
+	"golangify.com/snippetbox/storage"
+)
+
+var usage = `Использование:
+  velur [настройки]                        запустить веб-сервер
+  velur [настройки] migrate up             применить все новые миграции
+  velur [настройки] migrate down           откатить последнюю миграцию
+  velur [настройки] migrate status         показать состояние миграций
+  velur [настройки] user create [-role роль] [-email адрес] <имя>
+                                           создать пользователя (по умолчанию покупателя)
+  velur [настройки] user promote <имя> [роль]
+                                           назначить роль (по умолчанию admin)
+  velur [настройки] user demote <имя>      сделать пользователя покупателем
+  velur [настройки] user reset-password <имя>
+                                           задать новый пароль
+  velur [настройки] user list              показать всех пользователей
+  velur [настройки] images rebuild         заново построить уменьшенные копии изображений
+  velur [настройки] images gc [-grace 24h] [-dry-run]
+                                           удалить файлы изображений, на которые ничего
+                                           не ссылается и которые старше -grace
+
+Пароль для create и reset-password читается из первой строки стандартного ввода.
+Роли: admin, catalog_manager, order_manager, support, user.
+
+` + config.Usage()
+
+// runCommand выполняет служебную команду и возвращает код завершения.
+func runCommand(cfg config.Config, args []string) int {
+	switch args[0] {
+	case "migrate":
+		return migrateCommand(cfg, args[1:])
+	case "user":
+		return userCommand(cfg, args[1:])
+	case "images":
+		return imagesCommand(cfg, args[1:])
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "Неизвестная команда %q\n\n%s\n", args[0], usage)
+	return 2
+}
+
+func migrateCommand(cfg config.Config, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		db := openDB(cfg.DatabaseURL)
+		applied, err := migrations.Up(ctx, db)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Ошибка при применении миграций:", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("Новых миграций нет")
+		}
+		for _, m := range applied {
+			fmt.Printf("Применена миграция %04d_%s\n", m.Version, m.Name)
+		}
+
+	case "down":
+		db := openDB(cfg.DatabaseURL)
+		m, err := migrations.Down(ctx, db)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Ошибка при откате миграции:", err)
+			return 1
+		}
+		if m.Version == 0 {
+			fmt.Println("Нет применённых миграций")
+		} else {
+			fmt.Printf("Откачена миграция %04d_%s\n", m.Version, m.Name)
+		}
+
+	case "status":
+		db := openDB(cfg.DatabaseURL)
+		statuses, err := migrations.List(ctx, db)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Ошибка при чтении состояния миграций:", err)
+			return 1
+		}
+		for _, s := range statuses {
+			state := "не применена"
+			if s.Applied {
+				state = "применена " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, state)
+		}
+
+	default:
+		fmt.Fprintf(os.Stderr, "Неизвестная подкоманда migrate %q\n\n%s\n", args[0], usage)
+		return 2
+	}
+	return 0
+}
+
+// minPasswordLength — минимальная длина пароля, который задаёт оператор
+// или пользователь по ссылке восстановления.
+const minPasswordLength = 8
+
+// errUsage означает, что команда вызвана с неверными аргументами.
+var errUsage = errors.New("неверные аргументы команды")
+
+func userCommand(cfg config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	db := openDB(cfg.DatabaseURL)
+	defer db.Close()
+
+	err := manageUsers(context.Background(), repository.NewPostgres(db), args, os.Stdin, os.Stdout, os.Stderr)
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "Неверный вызов команды user\n\n%s\n", usage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Ошибка:", err)
+		return 1
+	}
+	return 0
+}
+
+// manageUsers выполняет подкоманду user. Приглашение ввести пароль
+// печатается в stderr, чтобы не смешиваться с выводом команды.
+func manageUsers(ctx context.Context, users repository.UserRepository, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		role := fs.String("role", roles.Customer, "")
+		email := fs.String("email", "", "")
+		if err := fs.Parse(args[1:]); err != nil || fs.NArg() != 1 {
+			return errUsage
+		}
+		if !roles.Known(*role) {
+			return fmt.Errorf("неизвестная роль %q", *role)
+		}
+
+		hash, err := readPassword(stdin, stderr)
+		if err != nil {
+			return err
+		}
+		user, err := users.CreateUser(ctx, repository.User{
+			Username:     fs.Arg(0),
+			Email:        *email,
+			PasswordHash: hash,
+			Role:         *role,
+		})
+		if errors.Is(err, repository.ErrConflict) {
+			return fmt.Errorf("пользователь %s уже существует", fs.Arg(0))
+		}
+		if err != nil {
+			return fmt.Errorf("создание пользователя: %w", err)
+		}
+		fmt.Fprintf(stdout, "Создан пользователь %s (id %d, роль %s)\n", user.Username, user.ID, user.Role)
+
+	case "promote", "demote":
+		if len(args) < 2 || len(args) > 3 || args[0] == "demote" && len(args) != 2 {
+			return errUsage
+		}
+		role := roles.Customer
+		if args[0] == "promote" {
+			role = roles.Admin
+			if len(args) == 3 {
+				role = args[2]
+			}
+		}
+		if !roles.Known(role) {
+			return fmt.Errorf("неизвестная роль %q", role)
+		}
+
+		if err := users.SetRole(ctx, args[1], role); err != nil {
+			return userError(args[1], "смена роли", err)
+		}
+		fmt.Fprintf(stdout, "Пользователь %s теперь %s\n", args[1], role)
+
+	case "reset-password":
+		if len(args) != 2 {
+			return errUsage
+		}
+		hash, err := readPassword(stdin, stderr)
+		if err != nil {
+			return err
+		}
+		if err := users.SetPassword(ctx, args[1], hash); err != nil {
+			return userError(args[1], "смена пароля", err)
+		}
+		fmt.Fprintf(stdout, "Пароль пользователя %s изменён\n", args[1])
+
+	case "list":
+		if len(args) != 1 {
+			return errUsage
+		}
+		list, err := users.Users(ctx)
+		if err != nil {
+			return fmt.Errorf("чтение пользователей: %w", err)
+		}
+		for _, u := range list {
+			fmt.Fprintf(stdout, "%-6d %-20s %-16s %s\n", u.ID, u.Username, u.Role, u.Email)
+		}
+
+	default:
+		return errUsage
+	}
+	return nil
+}
+
+func userError(username, action string, err error) error {
+	if errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("пользователь %s не найден", username)
+	}
+	return fmt.Errorf("%s: %w", action, err)
+}
+
+// readPassword читает пароль из первой строки stdin и возвращает его хеш.
+func readPassword(stdin io.Reader, prompt io.Writer) ([]byte, error) {
+	fmt.Fprint(prompt, "Пароль: ")
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("чтение пароля: %w", err)
+	}
+
+	password := strings.TrimRight(line, "\r\n")
+	if len([]rune(password)) < minPasswordLength {
+		return nil, fmt.Errorf("пароль должен быть не короче %d символов", minPasswordLength)
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// defaultGCGrace — сколько ждёт файл без ссылок, прежде чем его удалит
+// images gc. Файл может появиться в хранилище раньше ссылки на него:
+// rebuild записывает копии до обновления базы.
+const defaultGCGrace = 24 * time.Hour
+
+func imagesCommand(cfg config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	var run func(ctx context.Context, store repository.ProductRepository) error
+	switch args[0] {
+	case "rebuild":
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, usage)
+			return 2
+		}
+		run = func(ctx context.Context, store repository.ProductRepository) error {
+			return rebuildImages(ctx, store, cfg, os.Stdout)
+		}
+	case "gc":
+		fs := flag.NewFlagSet("images gc", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		grace := fs.Duration("grace", defaultGCGrace, "")
+		dryRun := fs.Bool("dry-run", false, "")
+		if err := fs.Parse(args[1:]); err != nil || fs.NArg() != 0 || *grace < 0 {
+			fmt.Fprintf(os.Stderr, "Неверный вызов команды images gc\n\n%s\n", usage)
+			return 2
+		}
+		run = func(ctx context.Context, store repository.ProductRepository) error {
+			return collectImages(ctx, store, imageStorage(cfg), *grace, *dryRun, time.Now(), os.Stdout)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	db := openDB(cfg.DatabaseURL)
+	defer db.Close()
+
+	if err := run(context.Background(), repository.NewPostgres(db)); err != nil {
+		fmt.Fprintln(os.Stderr, "Ошибка:", err)
+		return 1
+	}
+	return 0
+}
+
+// collectImages удаляет из хранилища файлы, на которые не ссылаются ни
+// товары, ни их галереи, ни заказы, если они не менялись дольше grace.
+// С dryRun только печатает, что было бы удалено.
+func collectImages(ctx context.Context, store repository.ProductRepository, st storage.Storage, grace time.Duration, dryRun bool, now time.Time, out io.Writer) error {
+	urls, err := store.ImageURLs(ctx)
+	if err != nil {
+		return fmt.Errorf("чтение ссылок на изображения: %w", err)
+	}
+	referenced := map[string]bool{}
+	for url := range urls {
+		if name, ok := st.Name(url); ok {
+			referenced[name] = true
+		}
+	}
+
+	files, err := st.List(ctx)
+	if err != nil {
+		return fmt.Errorf("чтение списка файлов: %w", err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	removed, failed := 0, 0
+	for _, f := range files {
+		if referenced[f.Name] || now.Sub(f.Modified) < grace {
+			continue
+		}
+		if dryRun {
+			fmt.Fprintf(out, "%s: будет удалён\n", f.Name)
+			removed++
+			continue
+		}
+		if err := st.Delete(ctx, f.Name); err != nil {
+			fmt.Fprintf(out, "%s: %v\n", f.Name, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(out, "%s: удалён\n", f.Name)
+		removed++
+	}
+
+	if dryRun {
+		fmt.Fprintf(out, "Будет удалено файлов: %d\n", removed)
+	} else {
+		fmt.Fprintf(out, "Удалено файлов: %d\n", removed)
+	}
+	if failed > 0 {
+		return fmt.Errorf("не удалось удалить файлов: %d", failed)
+	}
+	return nil
+}
+
+// rebuildImages заново строит уменьшенные копии всех фотографий товаров
+// каталога. Ошибка с одной фотографией не останавливает остальные.
+func rebuildImages(ctx context.Context, store repository.ProductRepository, cfg config.Config, out io.Writer) error {
+	snapshot, err := store.LoadCatalog(ctx)
+	if err != nil {
+		return fmt.Errorf("загрузка каталога: %w", err)
+	}
+
+	type product struct {
+		category, id, name string
+		images             []products.Image
+	}
+	var list []product
+	for id, c := range snapshot.Clothes {
+		list = append(list, product{products.CategoryClothing, id, c.Name, c.Images})
+	}
+	for id, a := range snapshot.Accessories {
+		list = append(list, product{products.CategoryAccessory, id, a.Name, a.Images})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].category != list[j].category {
+			return list[i].category > list[j].category
+		}
+		return list[i].id < list[j].id
+	})
+
+	st := imageStorage(cfg)
+	failed := 0
+	for _, p := range list {
+		for i, image := range p.images {
+			label := p.name
+			if i > 0 {
+				label = fmt.Sprintf("%s (фото %d)", p.name, i+1)
+			}
+			if err := rebuildImage(ctx, store, cfg, st, image); err != nil {
+				fmt.Fprintf(out, "%s: %v\n", label, err)
+				failed++
+				continue
+			}
+			fmt.Fprintf(out, "%s: копии построены\n", label)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("не удалось обработать изображений: %d", failed)
+	}
+	return nil
+}
+
+func rebuildImage(ctx context.Context, store repository.ProductRepository, cfg config.Config, st storage.Storage, image products.Image) error {
+	data, err := readImage(ctx, cfg, st, image.URL)
+	if err != nil {
+		return err
+	}
+
+	staged, err := images.StageSizes(st, data)
+	if err != nil {
+		return err
+	}
+
+	// Сначала файлы, потом ссылки на них: база не должна указывать на
+	// несуществующие копии.
+	if err := staged.Commit(ctx); err != nil {
+		return err
+	}
+	_, _, err = store.SetImageSizes(ctx, image.ID, staged.Sizes)
+	return err
+}
+
+// readImage читает исходник фотографии: загруженные администратором лежат в
+// хранилище изображений, исходные фото магазина — среди ресурсов.
+func readImage(ctx context.Context, cfg config.Config, st storage.Storage, imageURL string) ([]byte, error) {
+	if name, ok := st.Name(imageURL); ok {
+		return st.Get(ctx, name)
+	}
+	if name, ok := strings.CutPrefix(imageURL, "/assets/"); ok {
+		return os.ReadFile(filepath.Join(cfg.AssetsDir, filepath.FromSlash(name)))
+	}
+	return nil, fmt.Errorf("изображение %q не найдено ни в хранилище, ни среди ресурсов", imageURL)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golangify.com/snippetbox/config"
+	"golangify.com/snippetbox/products"
+	"golangify.com/snippetbox/repository"
+	"golangify.com/snippetbox/roles"
+	"golangify.com/snippetbox/storage"
+)
+
+func runUsers(t *testing.T, store *repository.Memory, stdin string, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := manageUsers(context.Background(), store, args, strings.NewReader(stdin), &out, io.Discard)
+	return out.String(), err
+}
+
+func TestUserCommand(t *testing.T) {
+	store := repository.NewMemory()
+	ctx := context.Background()
+
+	if _, err := runUsers(t, store, "password1\n", "create", "-role", roles.Admin, "-email", "boss@example.com", "boss"); err != nil {
+		t.Fatal(err)
+	}
+	user, err := store.UserByUsername(ctx, "boss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != roles.Admin || user.Email != "boss@example.com" ||
+		bcrypt.CompareHashAndPassword(user.PasswordHash, []byte("password1")) != nil {
+		t.Fatalf("пользователь создан неверно: %+v", user)
+	}
+	if _, err := runUsers(t, store, "password1\n", "create", "boss"); err == nil {
+		t.Fatal("повторное создание пользователя не вернуло ошибку")
+	}
+
+	runUsers(t, store, "password2\n", "create", "anna")
+	if _, err := runUsers(t, store, "", "promote", "anna", roles.Support); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := store.UserByUsername(ctx, "anna"); user.Role != roles.Support {
+		t.Fatalf("роль %q, ожидалась %q", user.Role, roles.Support)
+	}
+	if _, err := runUsers(t, store, "", "demote", "anna"); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := store.UserByUsername(ctx, "anna"); user.Role != roles.Customer {
+		t.Fatalf("роль %q, ожидалась %q", user.Role, roles.Customer)
+	}
+
+	if _, err := runUsers(t, store, "newsecret\n", "reset-password", "anna"); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := store.UserByUsername(ctx, "anna"); bcrypt.CompareHashAndPassword(user.PasswordHash, []byte("newsecret")) != nil {
+		t.Fatal("пароль не изменён")
+	}
+
+	out, err := runUsers(t, store, "", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 2 ||
+		!strings.Contains(lines[0], "boss") || !strings.Contains(lines[1], "anna") {
+		t.Fatalf("список пользователей:\n%s", out)
+	}
+}
+
+func TestUserCommandErrors(t *testing.T) {
+	store := repository.NewMemory()
+	runUsers(t, store, "password1\n", "create", "anna")
+
+	tests := []struct {
+		name  string
+		stdin string
+		args  []string
+		usage bool
+	}{
+		{"unknown subcommand", "", []string{"delete", "anna"}, true},
+		{"create without name", "password1\n", []string{"create"}, true},
+		{"unknown role", "password1\n", []string{"create", "-role", "root", "olga"}, false},
+		{"short password", "short\n", []string{"create", "olga"}, false},
+		{"promote unknown user", "", []string{"promote", "nobody"}, false},
+		{"promote to unknown role", "", []string{"promote", "anna", "root"}, false},
+		{"demote with role", "", []string{"demote", "anna", roles.Admin}, true},
+		{"reset unknown user", "password1\n", []string{"reset-password", "nobody"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runUsers(t, store, tt.stdin, tt.args...)
+			if err == nil {
+				t.Fatal("ожидалась ошибка")
+			}
+			if errors.Is(err, errUsage) != tt.usage {
+				t.Fatalf("ошибка %v, ошибка использования: %v", err, tt.usage)
+			}
+		})
+	}
+
+	if _, err := store.UserByUsername(context.Background(), "olga"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatal("пользователь создан несмотря на ошибку")
+	}
+}
+
+func TestRebuildImages(t *testing.T) {
+	cfg := config.Config{AssetsDir: t.TempDir(), UploadDir: t.TempDir()}
+	if err := os.MkdirAll(filepath.Join(cfg.AssetsDir, "product"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cfg.AssetsDir, "product", "dress.png"), testPNG(t, 600, 400), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	store := repository.NewMemory()
+	clothing, _ := store.CreateClothing(ctx, products.Clothing{Name: "Платье", ImageURL: "/assets/product/dress.png"})
+	store.CreateAccessory(ctx, products.Accessory{Name: "Сумка", ImageURL: "/uploads/missing.png"})
+
+	var out bytes.Buffer
+	if err := rebuildImages(ctx, store, cfg, &out); err == nil {
+		t.Fatal("отсутствующий файл не привёл к ошибке")
+	}
+	assertContains(t, out.String(), "Сумка:")
+
+	snapshot, _ := store.LoadCatalog(ctx)
+	sizes := snapshot.Clothes[clothing.ID].ImageSizes
+	if sizes.Thumb == "" || sizes.Card == "" || sizes.Zoom == "" {
+		t.Fatalf("копии не сохранены: %+v", sizes)
+	}
+	for _, url := range []string{sizes.Thumb, sizes.Card, sizes.Zoom} {
+		if _, err := os.Stat(filepath.Join(cfg.UploadDir, strings.TrimPrefix(url, "/uploads/"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCollectImages(t *testing.T) {
+	ctx := context.Background()
+	st := &storage.Local{Dir: t.TempDir(), BaseURL: "/uploads/"}
+	store := repository.NewMemory()
+
+	// На bag.jpg после удаления товара ссылается только заказ.
+	store.CreateClothing(ctx, products.Clothing{Name: "Платье", ImageURL: "/uploads/dress.jpg"})
+	bag, _ := store.CreateAccessory(ctx, products.Accessory{Name: "Сумка", ImageURL: "/uploads/bag.jpg", Stock: 1})
+	_, err := store.PlaceOrder(ctx, repository.NewOrder{Lines: []repository.OrderLine{
+		{Item: repository.CartItem{Category: products.CategoryAccessory, ProductID: bag.ID}, Quantity: 1},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.DeleteAccessory(ctx, bag.ID)
+
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	for name, modified := range map[string]time.Time{
+		"dress.jpg":     old,
+		"bag.jpg":       old,
+		"orphan.jpg":    old,
+		".upload-12345": old,
+		"fresh.jpg":     now,
+	} {
+		st.Put(ctx, name, []byte(name), "image/jpeg")
+		os.Chtimes(filepath.Join(st.Dir, name), modified, modified)
+	}
+
+	var out bytes.Buffer
+	if err := collectImages(ctx, store, st, 24*time.Hour, true, now, &out); err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, out.String(), "Будет удалено файлов: 2")
+	if files, _ := st.List(ctx); len(files) != 5 {
+		t.Fatalf("пробный запуск удалил файлы: %v", files)
+	}
+
+	out.Reset()
+	if err := collectImages(ctx, store, st, 24*time.Hour, false, now, &out); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	files, _ := st.List(ctx)
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "bag.jpg,dress.jpg,fresh.jpg" {
+		t.Fatalf("после сборки остались %v\n%s", names, out.String())
+	}
+}
+
+// Загрузки прежних версий лежат в assets/product_images, и в базе на них
+// ссылаются адреса /assets/product_images/…: сборка их не удаляет.
+func TestCollectImagesKeepsLegacyUploads(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Config{AssetsDir: t.TempDir()}
+	cfg.UploadDir = filepath.Join(cfg.AssetsDir, "product_images")
+	st := imageStorage(cfg)
+	store := repository.NewMemory()
+	store.CreateClothing(ctx, products.Clothing{Name: "Платье", ImageURL: "/assets/product_images/x.jpg"})
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{"x.jpg", "orphan.jpg"} {
+		st.Put(ctx, name, []byte(name), "image/jpeg")
+		os.Chtimes(filepath.Join(cfg.UploadDir, name), old, old)
+	}
+
+	var out bytes.Buffer
+	if err := collectImages(ctx, store, st, 24*time.Hour, false, time.Now(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.UploadDir, "x.jpg")); err != nil {
+		t.Fatalf("удалена старая загрузка: %v\n%s", err, out.String())
+	}
+	if _, err := os.Stat(filepath.Join(cfg.UploadDir, "orphan.jpg")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("файл без ссылок не удалён: %v", err)
+	}
+}
@@ -0,0 +1,376 @@
+// Package config собирает настройки приложения из значений по умолчанию,
+// JSON-файла конфигурации, переменных окружения и флагов командной строки.
+// Каждый следующий источник переопределяет предыдущий.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Хранилища данных магазина.
+const (
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+)
+
+// Хранилища изображений товаров.
+const (
+	ImageStorageLocal = "local"
+	ImageStorageS3    = "s3"
+)
+
+// Платёжные шлюзы. Без шлюза заказы оплачиваются при получении.
+const (
+	PaymentNone = ""
+	PaymentFake = "fake"
+)
+
+// Отправители писем.
+const (
+	MailLog  = "log"
+	MailFile = "file"
+	MailSMTP = "smtp"
+)
+
+type Config struct {
+	Storage              string `json:"storage"`
+	DatabaseURL          string `json:"database_url"`
+	Addr                 string `json:"addr"`
+	SessionAuthKey       string `json:"session_auth_key"`
+	SessionEncryptionKey string `json:"session_encryption_key"`
+	AssetsDir            string `json:"assets_dir"`
+	UploadDir            string `json:"upload_dir"`
+	ImageStorage         string `json:"image_storage"`
+	S3Endpoint           string `json:"s3_endpoint"`
+	S3Region             string `json:"s3_region"`
+	S3Bucket             string `json:"s3_bucket"`
+	S3AccessKey          string `json:"s3_access_key"`
+	S3SecretKey          string `json:"s3_secret_key"`
+	S3PublicURL          string `json:"s3_public_url"`
+	BaseURL              string `json:"base_url"`
+	PaymentProvider      string `json:"payment_provider"`
+	PaymentSecret        string `json:"payment_secret"`
+	MailSender           string `json:"mail_sender"`
+	MailDir              string `json:"mail_dir"`
+	MailFrom             string `json:"mail_from"`
+	SMTPHost             string `json:"smtp_host"`
+	SMTPPort             int    `json:"smtp_port"`
+	SMTPUsername         string `json:"smtp_username"`
+	SMTPPassword         string `json:"smtp_password"`
+	StaffEmail           string `json:"staff_email"`
+	CookieSecure         bool   `json:"cookie_secure"`
+	CookieSameSite       string `json:"cookie_same_site"`
+}
+
+func defaults() Config {
+	return Config{
+		Storage:        StoragePostgres,
+		DatabaseURL:    "host=localhost port=5432 user=postgres dbname=velur sslmode=disable",
+		Addr:           ":7070",
+		AssetsDir:      "assets",
+		UploadDir:      "assets/product_images",
+		ImageStorage:   ImageStorageLocal,
+		S3Region:       "us-east-1",
+		BaseURL:        "http://localhost:7070",
+		MailSender:     MailLog,
+		MailDir:        "mail",
+		MailFrom:       "Velur <noreply@localhost>",
+		SMTPPort:       25,
+		CookieSecure:   true,
+		CookieSameSite: "lax",
+	}
+}
+
+// setting описывает одну настройку: её флаг, переменную окружения и поле
+// структуры, в которое записывается значение.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+func stringSetting(flagName, env, usage string, field func(*Config) *string) setting {
+	return setting{flagName, env, usage, func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}}
+}
+
+var settings = []setting{
+	stringSetting("storage", "VELUR_STORAGE", "хранилище данных: postgres или memory (без базы, данные теряются при перезапуске)",
+		func(c *Config) *string { return &c.Storage }),
+	stringSetting("db", "VELUR_DATABASE_URL", "строка подключения к PostgreSQL",
+		func(c *Config) *string { return &c.DatabaseURL }),
+	stringSetting("addr", "VELUR_ADDR", "адрес веб-сервера",
+		func(c *Config) *string { return &c.Addr }),
+	stringSetting("session-auth-key", "VELUR_SESSION_AUTH_KEY", "ключ подписи cookie сессии (не короче 32 байт)",
+		func(c *Config) *string { return &c.SessionAuthKey }),
+	stringSetting("session-encryption-key", "VELUR_SESSION_ENCRYPTION_KEY", "ключ шифрования cookie сессии (16, 24 или 32 байта)",
+		func(c *Config) *string { return &c.SessionEncryptionKey }),
+	stringSetting("assets-dir", "VELUR_ASSETS_DIR", "каталог статических файлов",
+		func(c *Config) *string { return &c.AssetsDir }),
+	stringSetting("upload-dir", "VELUR_UPLOAD_DIR", "каталог загруженных изображений товаров",
+		func(c *Config) *string { return &c.UploadDir }),
+	stringSetting("image-storage", "VELUR_IMAGE_STORAGE", "хранилище изображений товаров: local (каталог загрузок) или s3",
+		func(c *Config) *string { return &c.ImageStorage }),
+	stringSetting("s3-endpoint", "VELUR_S3_ENDPOINT", "адрес S3-совместимого хранилища, например https://storage.yandexcloud.net",
+		func(c *Config) *string { return &c.S3Endpoint }),
+	stringSetting("s3-region", "VELUR_S3_REGION", "регион S3",
+		func(c *Config) *string { return &c.S3Region }),
+	stringSetting("s3-bucket", "VELUR_S3_BUCKET", "бакет S3 для изображений",
+		func(c *Config) *string { return &c.S3Bucket }),
+	stringSetting("s3-access-key", "VELUR_S3_ACCESS_KEY", "идентификатор ключа доступа S3",
+		func(c *Config) *string { return &c.S3AccessKey }),
+	stringSetting("s3-secret-key", "VELUR_S3_SECRET_KEY", "секретный ключ S3",
+		func(c *Config) *string { return &c.S3SecretKey }),
+	stringSetting("s3-public-url", "VELUR_S3_PUBLIC_URL", "публичный адрес изображений в S3, например CDN (по умолчанию адрес бакета)",
+		func(c *Config) *string { return &c.S3PublicURL }),
+	stringSetting("base-url", "VELUR_BASE_URL", "публичный адрес магазина для ссылок во внешних сервисах, например https://velur.ru",
+		func(c *Config) *string { return &c.BaseURL }),
+	stringSetting("payment-provider", "VELUR_PAYMENT_PROVIDER", "платёжный шлюз: fake (учебный, для разработки) или пусто — оплата при получении",
+		func(c *Config) *string { return &c.PaymentProvider }),
+	stringSetting("payment-secret", "VELUR_PAYMENT_SECRET", "ключ подписи уведомлений платёжного шлюза",
+		func(c *Config) *string { return &c.PaymentSecret }),
+	stringSetting("mail-sender", "VELUR_MAIL_SENDER", "отправка писем: log (в журнал), file (файлы .eml в каталог писем) или smtp",
+		func(c *Config) *string { return &c.MailSender }),
+	stringSetting("mail-dir", "VELUR_MAIL_DIR", "каталог писем для отправителя file",
+		func(c *Config) *string { return &c.MailDir }),
+	stringSetting("mail-from", "VELUR_MAIL_FROM", "адрес отправителя писем, например Velur <noreply@velur.ru>",
+		func(c *Config) *string { return &c.MailFrom }),
+	stringSetting("smtp-host", "VELUR_SMTP_HOST", "адрес SMTP-сервера",
+		func(c *Config) *string { return &c.SMTPHost }),
+	{"smtp-port", "VELUR_SMTP_PORT", "порт SMTP-сервера", func(c *Config, value string) error {
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("ожидается номер порта, получено %q", value)
+		}
+		c.SMTPPort = port
+		return nil
+	}},
+	stringSetting("smtp-username", "VELUR_SMTP_USERNAME", "имя пользователя SMTP; пусто — без входа",
+		func(c *Config) *string { return &c.SMTPUsername }),
+	stringSetting("smtp-password", "VELUR_SMTP_PASSWORD", "пароль SMTP",
+		func(c *Config) *string { return &c.SMTPPassword }),
+	stringSetting("staff-email", "VELUR_STAFF_EMAIL", "адрес, на который приходят уведомления о новых заказах; пусто — не отправлять",
+		func(c *Config) *string { return &c.StaffEmail }),
+	{"cookie-secure", "VELUR_COOKIE_SECURE", "отправлять cookie сессии только по HTTPS", func(c *Config, value string) error {
+		secure, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("ожидается true или false, получено %q", value)
+		}
+		c.CookieSecure = secure
+		return nil
+	}},
+	stringSetting("cookie-same-site", "VELUR_COOKIE_SAME_SITE", "атрибут SameSite cookie сессии: lax, strict или none",
+		func(c *Config) *string { return &c.CookieSameSite }),
+}
+
+// Load читает настройки и возвращает оставшиеся после флагов аргументы
+// командной строки. getenv обычно os.Getenv. Ключи сессии здесь не
+// проверяются: они нужны только веб-серверу, см. ValidateSession.
+func Load(args []string, getenv func(string) string) (Config, []string, error) {
+	fs := flag.NewFlagSet("velur", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	configPath := fs.String("config", getenv("VELUR_CONFIG"), "путь к JSON-файлу конфигурации")
+	values := make([]string, len(settings))
+	for i, s := range settings {
+		fs.StringVar(&values[i], s.flag, "", s.usage+" (переменная "+s.env+")")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, nil, fmt.Errorf("%w\n\n%s", err, Usage())
+	}
+
+	cfg := defaults()
+	if *configPath != "" {
+		if err := readFile(*configPath, &cfg); err != nil {
+			return Config{}, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if value := getenv(s.env); value != "" {
+			if err := s.set(&cfg, value); err != nil {
+				return Config{}, nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for i, s := range settings {
+		if explicit[s.flag] {
+			if err := s.set(&cfg, values[i]); err != nil {
+				return Config{}, nil, fmt.Errorf("-%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+func readFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("не удалось прочитать файл конфигурации: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("ошибка в файле конфигурации %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate проверяет общие для сервера и команд настройки и перечисляет все
+// найденные проблемы сразу.
+func (c Config) Validate() error {
+	var problems []error
+
+	switch c.Storage {
+	case StoragePostgres:
+	case StorageMemory:
+	default:
+		problems = append(problems, fmt.Errorf("неизвестное хранилище %q: ожидается postgres или memory", c.Storage))
+	}
+	if c.Storage == StoragePostgres && c.DatabaseURL == "" {
+		problems = append(problems, errors.New("не задана строка подключения к базе (-db, VELUR_DATABASE_URL)"))
+	}
+	if c.Addr == "" {
+		problems = append(problems, errors.New("не задан адрес веб-сервера (-addr, VELUR_ADDR)"))
+	}
+	if c.AssetsDir == "" {
+		problems = append(problems, errors.New("не задан каталог статических файлов (-assets-dir, VELUR_ASSETS_DIR)"))
+	}
+	if c.UploadDir == "" {
+		problems = append(problems, errors.New("не задан каталог загрузок (-upload-dir, VELUR_UPLOAD_DIR)"))
+	}
+	switch c.ImageStorage {
+	case ImageStorageLocal:
+	case ImageStorageS3:
+		if c.S3Endpoint == "" || c.S3Bucket == "" || c.S3Region == "" {
+			problems = append(problems, errors.New("для хранилища s3 нужны адрес, регион и бакет (-s3-endpoint, -s3-region, -s3-bucket)"))
+		}
+		if c.S3AccessKey == "" || c.S3SecretKey == "" {
+			problems = append(problems, errors.New("для хранилища s3 нужны ключи доступа (VELUR_S3_ACCESS_KEY, VELUR_S3_SECRET_KEY)"))
+		}
+	default:
+		problems = append(problems, fmt.Errorf("неизвестное хранилище изображений %q: ожидается local или s3", c.ImageStorage))
+	}
+	switch c.PaymentProvider {
+	case PaymentNone:
+	case PaymentFake:
+		if c.PaymentSecret == "" {
+			problems = append(problems, errors.New("для платёжного шлюза нужен ключ подписи уведомлений (VELUR_PAYMENT_SECRET)"))
+		}
+	default:
+		problems = append(problems, fmt.Errorf("неизвестный платёжный шлюз %q: ожидается fake или пусто", c.PaymentProvider))
+	}
+	switch c.MailSender {
+	case MailLog:
+	case MailFile:
+		if c.MailDir == "" {
+			problems = append(problems, errors.New("не задан каталог писем (-mail-dir, VELUR_MAIL_DIR)"))
+		}
+	case MailSMTP:
+		if c.SMTPHost == "" {
+			problems = append(problems, errors.New("не задан адрес SMTP-сервера (-smtp-host, VELUR_SMTP_HOST)"))
+		}
+		if c.SMTPPort < 1 || c.SMTPPort > 65535 {
+			problems = append(problems, fmt.Errorf("некорректный порт SMTP-сервера %d", c.SMTPPort))
+		}
+	default:
+		problems = append(problems, fmt.Errorf("неизвестный отправитель писем %q: ожидается log, file или smtp", c.MailSender))
+	}
+	if _, err := mail.ParseAddress(c.MailFrom); err != nil {
+		problems = append(problems, fmt.Errorf("некорректный адрес отправителя писем %q (-mail-from, VELUR_MAIL_FROM)", c.MailFrom))
+	}
+	if c.StaffEmail != "" {
+		if _, err := mail.ParseAddress(c.StaffEmail); err != nil {
+			problems = append(problems, fmt.Errorf("некорректный адрес для уведомлений о заказах %q (-staff-email, VELUR_STAFF_EMAIL)", c.StaffEmail))
+		}
+	}
+	if c.BaseURL == "" {
+		problems = append(problems, errors.New("не задан публичный адрес магазина (-base-url, VELUR_BASE_URL)"))
+	}
+	if _, err := parseSameSite(c.CookieSameSite); err != nil {
+		problems = append(problems, err)
+	}
+	if c.CookieSameSite == "none" && !c.CookieSecure {
+		problems = append(problems, errors.New("SameSite=none требует cookie_secure=true"))
+	}
+	return joinProblems(problems)
+}
+
+// ValidateSession проверяет ключи cookie сессии. Значений по умолчанию у них
+// нет, чтобы разные окружения не делили один секрет.
+func (c Config) ValidateSession() error {
+	var problems []error
+
+	if len(c.SessionAuthKey) < 32 {
+		problems = append(problems, errors.New("ключ подписи сессии должен быть не короче 32 байт (-session-auth-key, VELUR_SESSION_AUTH_KEY)"))
+	}
+	switch len(c.SessionEncryptionKey) {
+	case 0, 16, 24, 32:
+	default:
+		problems = append(problems, errors.New("ключ шифрования сессии должен быть длиной 16, 24 или 32 байта (-session-encryption-key, VELUR_SESSION_ENCRYPTION_KEY)"))
+	}
+	return joinProblems(problems)
+}
+
+func joinProblems(problems []error) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("некорректная конфигурация:\n%w", errors.Join(problems...))
+}
+
+// URL возвращает абсолютный адрес страницы магазина по пути path.
+func (c Config) URL(path string) string {
+	return strings.TrimSuffix(c.BaseURL, "/") + path
+}
+
+// SameSite возвращает атрибут SameSite для cookie сессии.
+func (c Config) SameSite() http.SameSite {
+	mode, _ := parseSameSite(c.CookieSameSite)
+	return mode
+}
+
+func parseSameSite(value string) (http.SameSite, error) {
+	switch value {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("неизвестное значение SameSite %q: ожидается lax, strict или none", value)
+}
+
+// Usage описывает флаги и переменные окружения.
+func Usage() string {
+	var b strings.Builder
+	b.WriteString("Настройки (флаг / переменная окружения):\n")
+	b.WriteString("  -config                  VELUR_CONFIG  путь к JSON-файлу конфигурации\n")
+	for _, s := range settings {
+		fmt.Fprintf(&b, "  -%-23s %s  %s\n", s.flag, s.env, s.usage)
+	}
+	return b.String()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFRejectsForgedForms(t *testing.T) {
+	app := newTestApp(t)
+	clothing := app.seedClothing(t, "Льняное платье", 2)
+	admin := app.adminClient(t)
+	other := app.client(t)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"no token", ""},
+		{"wrong token", "forged"},
+		{"token of another session", other.token()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := admin.postForm("/admin/delete-clothing/"+clothing.ID, url.Values{csrfField: {tt.token}})
+			assertStatus(t, resp, http.StatusForbidden)
+			assertContains(t, resp.body, "Форма устарела")
+		})
+	}
+	if _, exists := app.srv.catalog.Clothing(clothing.ID); !exists {
+		t.Fatal("товар удалён поддельной формой")
+	}
+
+	assertRedirect(t, admin.postForm("/admin/delete-clothing/"+clothing.ID, url.Values{}), "/admin")
+}
+
+func TestCSRFHeader(t *testing.T) {
+	app := newTestApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 5)
+	c := app.client(t)
+
+	form := accessoryItem(accessory, "1")
+	req, err := http.NewRequest(http.MethodPost, app.ts.URL+"/cart/add", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-CSRF-Token", c.token())
+	assertRedirect(t, c.do(req), "/cart")
+}
+
+func TestCSRFTokenRotatedOnLogin(t *testing.T) {
+	app := newTestApp(t)
+	app.createUser(t, "anna", "secret", "user")
+	accessory := app.seedAccessory(t, "Кожаная сумка", 5)
+	c := app.client(t)
+
+	guestToken := c.token()
+	c.login("anna", "secret")
+
+	form := accessoryItem(accessory, "1")
+	form.Set(csrfField, guestToken)
+	assertStatus(t, c.postForm("/cart/add", form), http.StatusForbidden)
+	assertRedirect(t, c.postForm("/cart/add", accessoryItem(accessory, "1")), "/cart")
+}
+
+func TestFormsCarryCSRFToken(t *testing.T) {
+	app := newTestApp(t)
+	clothing := app.seedClothing(t, "Льняное платье", 2)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 5)
+	admin := app.adminClient(t)
+	admin.postForm("/cart/add", clothingItem(clothing, 0, "1"))
+
+	token := admin.token()
+	for _, path := range []string{
+		"/", "/about", "/login", "/registration", "/clothing/" + clothing.ID, "/accessory/" + accessory.ID,
+		"/cart", "/checkout", "/admin", "/admin/edit-clothing/" + clothing.ID, "/admin/edit-accessory/" + accessory.ID,
+		"/admin/orders", "/admin/returns", "/admin/jobs",
+	} {
+		t.Run(path, func(t *testing.T) {
+			resp := admin.get(path)
+			assertStatus(t, resp, http.StatusOK)
+			// Формы фильтров отправляются GET-запросом и токена не требуют.
+			forms := strings.Count(resp.body, "<form") - strings.Count(resp.body, `method="get"`)
+			if got := strings.Count(resp.body, `name="csrf_token" value="`+token+`"`); got != forms {
+				t.Fatalf("токен есть в %d формах из %d", got, forms)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"golangify.com/snippetbox/catalog"
+	"golangify.com/snippetbox/images"
+	"golangify.com/snippetbox/products"
+	"golangify.com/snippetbox/repository"
+)
+
+var (
+	clothingTypes    = []string{"Платье", "Блузка", "Юбка", "Брюки", "Куртка", "Пальто", "Топ", "Кардиган"}
+	clothingSeasons  = []string{"Лето", "Зима", "Демисезон", "Всесезон"}
+	accessoryTypes   = []string{"Сумка", "Ремень", "Шарф", "Шляпа", "Украшения", "Бижутерия", "Платок", "Зонт"}
+	accessoryTargets = []string{"Для волос", "Для шеи", "Для рук", "Для ног", "Для тела", "Аксессуар"}
+)
+
+// parsePrice разбирает цену из формы. Допустимо только конечное
+// положительное число: от цены считаются суммы заказов и возвратов.
+func parsePrice(s string) (float64, error) {
+	price, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, err
+	}
+	if price <= 0 || math.IsNaN(price) || math.IsInf(price, 0) {
+		return 0, fmt.Errorf("недопустимая цена %q", s)
+	}
+	return price, nil
+}
+
+// withCurrent добавляет в список вариантов выбора текущее значение товара,
+// если его нет среди стандартных, чтобы форма не потеряла его при сохранении.
+func withCurrent(options []string, current string) []string {
+	if current == "" {
+		return options
+	}
+	for _, option := range options {
+		if option == current {
+			return options
+		}
+	}
+	return append([]string{current}, options...)
+}
+
+// replacementImage принимает новое изображение, если оно было загружено.
+// nil означает, что изображение товара не меняется.
+func (srv *server) replacementImage(r *http.Request) (*images.Staged, error) {
+	file, _, err := r.FormFile("image")
+	if err == http.ErrMissingFile {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return srv.stageImage(file)
+}
+
+func (srv *server) editClothingForm(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clothing, exists := srv.catalog.Clothing(vars["id"])
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := editProductTpl.Execute(w, map[string]interface{}{
+		"Category":  products.CategoryClothing,
+		"Product":   clothing,
+		"Types":     withCurrent(clothingTypes, clothing.Type),
+		"Seasons":   withCurrent(clothingSeasons, clothing.Season),
+		"CSRFToken": srv.csrfToken(w, r),
+	})
+	if err != nil {
+		log.Println("Ошибка при рендеринге формы редактирования одежды:", err)
+		http.Error(w, "Ошибка при отображении страницы", http.StatusInternalServerError)
+	}
+}
+
+func (srv *server) updateClothing(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID := vars["id"]
+
+	name := r.FormValue("name")
+	description := r.FormValue("description")
+	material := r.FormValue("material")
+	clothingType := r.FormValue("type")
+	season := r.FormValue("season")
+
+	price, err := parsePrice(r.FormValue("price"))
+	if err != nil {
+		log.Println("Ошибка при преобразовании цены:", err)
+		http.Error(w, "Некорректная цена товара", http.StatusBadRequest)
+		return
+	}
+
+	staged, err := srv.replacementImage(r)
+	if err != nil {
+		imageError(w, err)
+		return
+	}
+	var imagePath string
+	var imageSizes products.ImageSizes
+	if staged != nil {
+		imagePath, imageSizes = staged.URL, staged.Sizes
+	}
+
+	clothing, err := srv.products.UpdateClothing(r.Context(), products.Clothing{
+		ID:          productID,
+		Name:        name,
+		Description: description,
+		Price:       price,
+		ImageURL:    imagePath,
+		ImageSizes:  imageSizes,
+		Material:    material,
+		Type:        clothingType,
+		Season:      season,
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при обновлении одежды в базе данных:", err)
+		http.Error(w, "Ошибка при обновлении товара", http.StatusInternalServerError)
+		return
+	}
+
+	srv.catalog.Update(func(s *catalog.Snapshot) {
+		clothing.Variants = s.Clothes[productID].Variants
+		s.Clothes[productID] = clothing
+	})
+	if staged != nil && !commitImage(w, r, staged) {
+		return
+	}
+
+	log.Println("Одежда успешно обновлена:", name)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func (srv *server) editAccessoryForm(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	accessory, exists := srv.catalog.Accessory(vars["id"])
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := editProductTpl.Execute(w, map[string]interface{}{
+		"Category":  products.CategoryAccessory,
+		"Product":   accessory,
+		"Types":     withCurrent(accessoryTypes, accessory.Type),
+		"Targets":   withCurrent(accessoryTargets, accessory.Target),
+		"CSRFToken": srv.csrfToken(w, r),
+	})
+	if err != nil {
+		log.Println("Ошибка при рендеринге формы редактирования аксессуара:", err)
+		http.Error(w, "Ошибка при отображении страницы", http.StatusInternalServerError)
+	}
+}
+
+func (srv *server) updateAccessory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID := vars["id"]
+
+	name := r.FormValue("name")
+	description := r.FormValue("description")
+	accessoryType := r.FormValue("type")
+	color := r.FormValue("color")
+	material := r.FormValue("material")
+	target := r.FormValue("target")
+
+	price, err := parsePrice(r.FormValue("price"))
+	if err != nil {
+		log.Println("Ошибка при преобразовании цены:", err)
+		http.Error(w, "Некорректная цена товара", http.StatusBadRequest)
+		return
+	}
+
+	stock, err := strconv.Atoi(r.FormValue("stock"))
+	if err != nil || stock < 0 {
+		http.Error(w, "Некорректный остаток на складе", http.StatusBadRequest)
+		return
+	}
+
+	staged, err := srv.replacementImage(r)
+	if err != nil {
+		imageError(w, err)
+		return
+	}
+	var imagePath string
+	var imageSizes products.ImageSizes
+	if staged != nil {
+		imagePath, imageSizes = staged.URL, staged.Sizes
+	}
+
+	accessory, err := srv.products.UpdateAccessory(r.Context(), products.Accessory{
+		ID:          productID,
+		Name:        name,
+		Description: description,
+		Price:       price,
+		ImageURL:    imagePath,
+		ImageSizes:  imageSizes,
+		Type:        accessoryType,
+		Color:       color,
+		Material:    material,
+		Target:      target,
+		Stock:       stock,
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при обновлении аксессуара в базе данных:", err)
+		http.Error(w, "Ошибка при обновлении товара", http.StatusInternalServerError)
+		return
+	}
+	srv.catalog.Update(func(s *catalog.Snapshot) { s.Accessories[productID] = accessory })
+	if staged != nil && !commitImage(w, r, staged) {
+		return
+	}
+
+	log.Println("Аксессуар успешно обновлён:", name)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"golangify.com/snippetbox/catalog"
+	"golangify.com/snippetbox/products"
+	"golangify.com/snippetbox/repository"
+)
+
+// setCatalogImages подставляет в каталог галерею товара после её изменения.
+func (srv *server) setCatalogImages(owner repository.ImageOwner, images []products.Image) {
+	srv.catalog.Update(func(s *catalog.Snapshot) {
+		if owner.Category == products.CategoryAccessory {
+			if accessory, exists := s.Accessories[owner.ProductID]; exists {
+				accessory.SetImages(images)
+				s.Accessories[owner.ProductID] = accessory
+			}
+			return
+		}
+		if clothing, exists := s.Clothes[owner.ProductID]; exists {
+			clothing.SetImages(images)
+			s.Clothes[owner.ProductID] = clothing
+		}
+	})
+}
+
+// redirectToEdit возвращает администратора к форме товара.
+func redirectToEdit(w http.ResponseWriter, r *http.Request, owner repository.ImageOwner) {
+	http.Redirect(w, r, "/admin/edit-"+owner.Category+"/"+owner.ProductID+"#images", http.StatusSeeOther)
+}
+
+func (srv *server) addClothingImage(w http.ResponseWriter, r *http.Request) {
+	srv.addImage(w, r, repository.ImageOwner{Category: products.CategoryClothing, ProductID: mux.Vars(r)["id"]})
+}
+
+func (srv *server) addAccessoryImage(w http.ResponseWriter, r *http.Request) {
+	srv.addImage(w, r, repository.ImageOwner{Category: products.CategoryAccessory, ProductID: mux.Vars(r)["id"]})
+}
+
+func (srv *server) addImage(w http.ResponseWriter, r *http.Request, owner repository.ImageOwner) {
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		http.Error(w, "Выберите файл изображения", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	staged, err := srv.stageImage(file)
+	if err != nil {
+		imageError(w, err)
+		return
+	}
+
+	images, err := srv.products.AddImage(r.Context(), owner, products.Image{
+		URL:   staged.URL,
+		Sizes: staged.Sizes,
+		Alt:   r.FormValue("alt"),
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при добавлении фотографии в базу данных:", err)
+		http.Error(w, "Ошибка при добавлении фотографии", http.StatusInternalServerError)
+		return
+	}
+
+	srv.setCatalogImages(owner, images)
+	if !commitImage(w, r, staged) {
+		return
+	}
+	redirectToEdit(w, r, owner)
+}
+
+// changeImage применяет изменение галереи и возвращает к форме товара.
+func (srv *server) changeImage(w http.ResponseWriter, r *http.Request, owner repository.ImageOwner, images []products.Image, err error) {
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при изменении фотографии в базе данных:", err)
+		http.Error(w, "Ошибка при изменении фотографии", http.StatusInternalServerError)
+		return
+	}
+
+	srv.setCatalogImages(owner, images)
+	redirectToEdit(w, r, owner)
+}
+
+func (srv *server) updateImage(w http.ResponseWriter, r *http.Request) {
+	owner, images, err := srv.products.UpdateImageAlt(r.Context(), mux.Vars(r)["id"], r.FormValue("alt"))
+	srv.changeImage(w, r, owner, images, err)
+}
+
+func (srv *server) moveImage(w http.ResponseWriter, r *http.Request) {
+	var delta int
+	switch r.FormValue("direction") {
+	case "up":
+		delta = -1
+	case "down":
+		delta = 1
+	default:
+		http.Error(w, "Неизвестное направление", http.StatusBadRequest)
+		return
+	}
+
+	owner, images, err := srv.products.MoveImage(r.Context(), mux.Vars(r)["id"], delta)
+	srv.changeImage(w, r, owner, images, err)
+}
+
+func (srv *server) deleteImage(w http.ResponseWriter, r *http.Request) {
+	owner, images, err := srv.products.DeleteImage(r.Context(), mux.Vars(r)["id"])
+	srv.changeImage(w, r, owner, images, err)
+}
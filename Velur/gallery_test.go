@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"golangify.com/snippetbox/products"
+)
+
+func imageURLs(list []products.Image) []string {
+	var urls []string
+	for _, image := range list {
+		urls = append(urls, image.URL)
+	}
+	return urls
+}
+
+func TestGalleryManagement(t *testing.T) {
+	app := newTestApp(t)
+	admin := app.adminClient(t)
+	clothing := app.seedClothing(t, "Льняное платье", 2)
+	edit := "/admin/edit-clothing/" + clothing.ID + "#images"
+
+	second := testPNG(t, 50, 30)
+	resp := admin.postMultipart("/admin/add-clothing-image/"+clothing.ID, url.Values{"alt": {"Вид сзади"}}, "back.png", second)
+	assertRedirect(t, resp, edit)
+
+	clothing, _ = app.srv.catalog.Clothing(clothing.ID)
+	want := []string{"/uploads/dress.jpg", "/uploads/" + imageName(second)}
+	if got := imageURLs(clothing.Images); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("галерея %v, ожидалась %v", got, want)
+	}
+	if clothing.Images[1].Alt != "Вид сзади" || clothing.Images[1].Sizes.Thumb == "" {
+		t.Fatalf("фотография сохранена не полностью: %+v", clothing.Images[1])
+	}
+	page := admin.get("/clothing/" + clothing.ID).body
+	assertContains(t, page, `class="product-thumbs"`)
+	assertContains(t, page, `alt="Вид сзади"`)
+
+	// Вторая фотография поднимается наверх и становится обложкой.
+	moved := clothing.Images[1]
+	assertRedirect(t, admin.postForm("/admin/move-image/"+moved.ID, url.Values{"direction": {"up"}}), edit)
+	clothing, _ = app.srv.catalog.Clothing(clothing.ID)
+	if clothing.Images[0].ID != moved.ID || clothing.ImageURL != moved.URL || clothing.ImageSizes != moved.Sizes {
+		t.Fatalf("обложка не сменилась: %q", clothing.ImageURL)
+	}
+	assertContains(t, admin.get("/").body, `srcset="`+moved.Sizes.SrcSet()+`"`)
+
+	assertRedirect(t, admin.postForm("/admin/update-image/"+moved.ID, url.Values{"alt": {"Спина"}}), edit)
+	assertRedirect(t, admin.postForm("/admin/delete-image/"+clothing.Images[1].ID, nil), edit)
+
+	// Каталог и хранилище согласованы.
+	app.reload(t)
+	clothing, _ = app.srv.catalog.Clothing(clothing.ID)
+	if len(clothing.Images) != 1 || clothing.Images[0].Alt != "Спина" || clothing.ImageURL != moved.URL {
+		t.Fatalf("после изменений галерея %+v", clothing.Images)
+	}
+
+	assertRedirect(t, admin.postForm("/admin/delete-image/"+moved.ID, nil), edit)
+	clothing, _ = app.srv.catalog.Clothing(clothing.ID)
+	if len(clothing.Images) != 0 || clothing.ImageURL != "" {
+		t.Fatalf("после удаления всех фотографий осталась обложка %q", clothing.ImageURL)
+	}
+}
+
+func TestGalleryErrors(t *testing.T) {
+	app := newTestApp(t)
+	admin := app.adminClient(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 1)
+
+	assertStatus(t, admin.postMultipart("/admin/add-accessory-image/999", nil, "bag.png", testPNG(t, 20, 20)), http.StatusNotFound)
+	assertStatus(t, admin.postMultipart("/admin/add-accessory-image/"+accessory.ID, nil, "", nil), http.StatusBadRequest)
+	assertStatus(t, admin.postForm("/admin/delete-image/999", nil), http.StatusNotFound)
+	assertStatus(t, admin.postForm("/admin/move-image/"+accessory.Images[0].ID, url.Values{"direction": {"left"}}), http.StatusBadRequest)
+	if files := uploadedFiles(t, app); len(files) != 0 {
+		t.Fatalf("после ошибок остались файлы %v", files)
+	}
+
+	// Сдвиг за край галереи ничего не меняет.
+	assertRedirect(t, admin.postForm("/admin/move-image/"+accessory.Images[0].ID, url.Values{"direction": {"up"}}),
+		"/admin/edit-accessory/"+accessory.ID+"#images")
+}
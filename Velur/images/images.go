@@ -0,0 +1,183 @@
+// Package images проверяет загружаемые изображения товаров и готовит их к
+// записи в хранилище под именами, вычисленными по содержимому файла.
+package images
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"golangify.com/snippetbox/products"
+	"golangify.com/snippetbox/storage"
+)
+
+const (
+	// MaxSize — наибольший размер загружаемого файла.
+	MaxSize = 10 << 20
+	// maxPixels защищает от файлов, которые малы на диске, но при
+	// декодировании занимают гигабайты памяти.
+	maxPixels = 50_000_000
+)
+
+var (
+	ErrTooLarge    = errors.New("изображение больше 10 МБ или слишком большого разрешения")
+	ErrUnsupported = errors.New("поддерживаются только изображения JPEG, PNG и WebP")
+	ErrCorrupt     = errors.New("файл повреждён или не является изображением")
+)
+
+// contentTypes — MIME-типы файлов по расширению.
+var contentTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".png":  "image/png",
+	".webp": "image/webp",
+}
+
+// formats сопоставляет тип, определённый по содержимому, с именем декодера
+// и расширением файла.
+var formats = map[string]struct{ decoder, ext string }{
+	"image/jpeg": {"jpeg", ".jpg"},
+	"image/png":  {"png", ".png"},
+	"image/webp": {"webp", ".webp"},
+}
+
+// Upload — проверенное изображение.
+type Upload struct {
+	Data []byte
+	// Ext — расширение по содержимому, а не по имени файла от клиента.
+	Ext string
+}
+
+// Read читает изображение и проверяет, что это действительно JPEG, PNG или
+// WebP, который декодируется целиком.
+func Read(r io.Reader) (Upload, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxSize+1))
+	if err != nil {
+		return Upload{}, err
+	}
+	if len(data) > MaxSize {
+		return Upload{}, ErrTooLarge
+	}
+
+	format, ok := formats[http.DetectContentType(data)]
+	if !ok {
+		return Upload{}, ErrUnsupported
+	}
+
+	cfg, decoder, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || decoder != format.decoder {
+		return Upload{}, ErrCorrupt
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return Upload{}, ErrTooLarge
+	}
+	if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+		return Upload{}, ErrCorrupt
+	}
+
+	return Upload{Data: data, Ext: format.ext}, nil
+}
+
+// Name — имя файла по SHA-256 содержимого: одинаковые файлы не дублируются,
+// а разные не перезаписывают друг друга.
+func (u Upload) Name() string {
+	sum := sha256.Sum256(u.Data)
+	return hex.EncodeToString(sum[:]) + u.Ext
+}
+
+// Staged — изображение и его уменьшенные копии, подготовленные к записи в
+// хранилище. URL известны заранее, а файлы появляются только после Commit,
+// поэтому ошибка при сохранении товара не оставляет в хранилище ничейных
+// файлов.
+type Staged struct {
+	// URL исходника; пуст, если подготовлены только копии.
+	URL   string
+	Sizes products.ImageSizes
+
+	storage storage.Storage
+	files   []Upload
+}
+
+// Stage готовит исходное изображение и его уменьшенные копии.
+func Stage(st storage.Storage, u Upload) (*Staged, error) {
+	staged, err := StageSizes(st, u.Data)
+	if err != nil {
+		return nil, err
+	}
+	staged.URL = staged.add(u)
+	return staged, nil
+}
+
+// StageSizes строит уменьшенные копии уже сохранённого изображения.
+func StageSizes(st storage.Storage, data []byte) (*Staged, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+
+	staged := &Staged{storage: st}
+	for _, size := range []struct {
+		width int
+		url   *string
+	}{
+		{products.ThumbWidth, &staged.Sizes.Thumb},
+		{products.CardWidth, &staged.Sizes.Card},
+		{products.ZoomWidth, &staged.Sizes.Zoom},
+	} {
+		resized, err := encodeJPEG(resize(src, size.width))
+		if err != nil {
+			return nil, err
+		}
+		*size.url = staged.add(resized)
+	}
+	return staged, nil
+}
+
+// add запоминает файл и возвращает его будущий URL.
+func (s *Staged) add(u Upload) string {
+	s.files = append(s.files, u)
+	return s.storage.URL(u.Name())
+}
+
+// Commit записывает файлы в хранилище. Имена файлов уникальны для
+// содержимого, поэтому повторная запись после сбоя безопасна.
+func (s *Staged) Commit(ctx context.Context) error {
+	for _, u := range s.files {
+		if err := s.storage.Put(ctx, u.Name(), u.Data, contentTypes[u.Ext]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resize уменьшает изображение до ширины width с сохранением пропорций.
+// Изображения уже меньше width не увеличиваются. Прозрачные области
+// заливаются белым, потому что копии сохраняются в JPEG.
+func resize(src image.Image, width int) image.Image {
+	b := src.Bounds()
+	if b.Dx() < width {
+		width = b.Dx()
+	}
+	height := max(1, b.Dy()*width/b.Dx())
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
+
+func encodeJPEG(img image.Image) (Upload, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		return Upload{}, err
+	}
+	return Upload{Data: buf.Bytes(), Ext: ".jpg"}, nil
+}
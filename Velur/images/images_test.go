@@ -0,0 +1,90 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golangify.com/snippetbox/storage"
+)
+
+func encode(t *testing.T, enc func(*bytes.Buffer, image.Image) error) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := enc(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	jpg := encode(t, func(b *bytes.Buffer, m image.Image) error { return jpeg.Encode(b, m, nil) })
+	pngData := encode(t, func(b *bytes.Buffer, m image.Image) error { return png.Encode(b, m) })
+	webps, _ := filepath.Glob("../assets/product/*.webp")
+	if len(webps) == 0 {
+		t.Fatal("нет образцов WebP в assets/product")
+	}
+	webp, err := os.ReadFile(webps[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		ext  string
+		err  error
+	}{
+		{"jpeg", jpg, ".jpg", nil},
+		{"png", pngData, ".png", nil},
+		{"webp", webp, ".webp", nil},
+		{"html", []byte("<html><body>hi</body></html>"), "", ErrUnsupported},
+		{"broken png", pngData[:20], "", ErrCorrupt},
+		{"too large", make([]byte, MaxSize+1), "", ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := Read(bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.err)
+			}
+			if u.Ext != tt.ext {
+				t.Fatalf("расширение %q, ожидалось %q", u.Ext, tt.ext)
+			}
+		})
+	}
+}
+
+func TestStage(t *testing.T) {
+	st := &storage.Local{Dir: t.TempDir(), BaseURL: "/uploads/"}
+	u := Upload{Data: encode(t, func(b *bytes.Buffer, m image.Image) error { return png.Encode(b, m) }), Ext: ".png"}
+
+	staged, err := Stage(st, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(st.Dir); len(entries) != 0 {
+		t.Fatalf("файлы записаны до Commit: %v", entries)
+	}
+	if err := staged.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Исходник 8×8 меньше всех копий, поэтому три копии совпадают.
+	entries, _ := os.ReadDir(st.Dir)
+	if len(entries) != 2 || staged.URL != "/uploads/"+u.Name() || staged.Sizes.Thumb != staged.Sizes.Zoom {
+		t.Fatalf("в каталоге %v, изображение %+v", entries, staged)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".upload-") {
+			t.Fatalf("остался временный файл %s", e.Name())
+		}
+	}
+}
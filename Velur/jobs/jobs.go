@@ -0,0 +1,103 @@
+// Package jobs описывает фоновые задания: их статусы, повторы и
+// расписание. Очередь хранится в таблице jobs; задание ставится в неё в той
+// же транзакции, что и изменение, из-за которого оно появилось, и
+// выполняется позже одним из экземпляров магазина.
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Status string
+
+const (
+	Pending Status = "pending"
+	// Running — задание выдано исполнителю. Если он не отчитался до конца
+	// аренды, задание снова выдаётся.
+	Running Status = "running"
+	Done    Status = "done"
+	// Dead — попытки исчерпаны; задание ждёт разбора сотрудником.
+	Dead Status = "dead"
+)
+
+var Statuses = []Status{Pending, Running, Done, Dead}
+
+var labels = map[Status]string{
+	Pending: "Ожидает",
+	Running: "Выполняется",
+	Done:    "Выполнено",
+	Dead:    "Не выполнено",
+}
+
+func Known(s Status) bool {
+	_, ok := labels[s]
+	return ok
+}
+
+func (s Status) Label() string {
+	if label, ok := labels[s]; ok {
+		return label
+	}
+	return string(s)
+}
+
+// ErrPermanent помечает ошибку, которую повтор не исправит, например
+// испорченные данные задания. Такое задание сразу становится Dead.
+var ErrPermanent = errors.New("повтор не поможет")
+
+// Permanent оборачивает err в ErrPermanent.
+func Permanent(err error) error {
+	return fmt.Errorf("%w: %w", ErrPermanent, err)
+}
+
+// DefaultMaxAttempts — сколько раз выполняется задание, если при
+// постановке не указано другое.
+const DefaultMaxAttempts = 8
+
+// New — задание перед постановкой в очередь.
+type New struct {
+	Kind    string
+	Payload json.RawMessage
+	// Delay откладывает первое выполнение.
+	Delay time.Duration
+	// Key, если задан, не даёт поставить второе невыполненное задание того
+	// же вида с тем же ключом — так периодические задания не множатся.
+	Key         string
+	MaxAttempts int
+}
+
+// Make собирает задание вида kind с данными payload в JSON.
+func Make(kind string, payload any) (New, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return New{}, err
+	}
+	return New{Kind: kind, Payload: data}, nil
+}
+
+// Backoff — пауза перед повтором после attempts неудачных попыток: минута,
+// затем вдвое больше, но не дольше часа.
+func Backoff(attempts int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	return min(delay, time.Hour)
+}
+
+// Schedule — периодическое задание: после выполнения следующее ставится
+// через Every.
+type Schedule struct {
+	Kind  string
+	Every time.Duration
+}
+
+// Next — задание расписания, которое ставится в очередь. Ключ по виду
+// задания оставляет в очереди не больше одного такого задания, сколько бы
+// экземпляров магазина его ни ставили.
+func (s Schedule) Next() New {
+	return New{Kind: s.Kind, Payload: json.RawMessage("{}"), Delay: s.Every, Key: s.Kind}
+}
@@ -1,43 +1,61 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/gorilla/sessions"
 	_ "github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
+	"golangify.com/snippetbox/catalog"
+	"golangify.com/snippetbox/config"
+	"golangify.com/snippetbox/images"
+	"golangify.com/snippetbox/migrations"
+	"golangify.com/snippetbox/notify"
 	"golangify.com/snippetbox/products"
+	"golangify.com/snippetbox/repository"
+	"golangify.com/snippetbox/roles"
 )
 
 var (
-	marketTpl       = template.Must(template.ParseFiles("index/market.html"))
-	aboutTpl        = template.Must(template.ParseFiles("index/about.html"))
-	clothingTpl     = template.Must(template.ParseFiles("index/product.html"))
-	accessoryTpl    = template.Must(template.ParseFiles("index/accessory.html"))
-	registrationTpl = template.Must(template.ParseFiles("index/registration.html"))
-	loginTpl        = template.Must(template.ParseFiles("index/login.html"))
-	adminTpl        = template.Must(template.ParseFiles("index/add_product.html"))
-	orderTpl        = template.Must(template.ParseFiles("index/order.html"))
-	orderSuccessTpl = template.Must(template.ParseFiles("index/order_success.html"))
+	marketTpl        = template.Must(template.ParseFiles("index/market.html"))
+	aboutTpl         = template.Must(template.ParseFiles("index/about.html"))
+	clothingTpl      = template.Must(template.ParseFiles("index/product.html"))
+	accessoryTpl     = template.Must(template.ParseFiles("index/accessory.html"))
+	registrationTpl  = template.Must(template.ParseFiles("index/registration.html"))
+	loginTpl         = template.Must(template.ParseFiles("index/login.html"))
+	adminTpl         = template.Must(template.ParseFiles("index/add_product.html"))
+	orderTpl         = template.Must(template.ParseFiles("index/order.html"))
+	orderSuccessTpl  = template.Must(template.ParseFiles("index/order_success.html"))
+	cartTpl          = template.Must(template.ParseFiles("index/cart.html"))
+	editProductTpl   = template.Must(template.ParseFiles("index/edit_product.html"))
+	csrfTpl          = template.Must(template.ParseFiles("index/csrf.html"))
+	adminOrdersTpl   = template.Must(template.ParseFiles("index/admin_orders.html"))
+	adminOrderTpl    = template.Must(template.ParseFiles("index/admin_order.html"))
+	accountOrdersTpl = template.Must(template.ParseFiles("index/account_orders.html"))
+	orderTrackingTpl = template.Must(template.ParseFiles("index/order_tracking.html"))
+	adminReturnsTpl  = template.Must(template.ParseFiles("index/admin_returns.html"))
+	adminJobsTpl     = template.Must(template.ParseFiles("index/admin_jobs.html"))
+	forgotTpl        = template.Must(template.ParseFiles("index/password_forgot.html"))
+	resetTpl         = template.Must(template.ParseFiles("index/password_reset.html"))
 )
 
-var db *sql.DB
-var store = sessions.NewCookieStore([]byte("секретный_ключ_магазина_одежды"))
+// catalogRefreshInterval — как часто каталог перечитывается из хранилища,
+// чтобы подхватить изменения, сделанные в обход приложения.
+const catalogRefreshInterval = time.Minute
 
-func initDB() {
-	var err error
-	connStr := "host=localhost port=5432 user=postgres password=postgress dbname=velur sslmode=disable"
-	db, err = sql.Open("postgres", connStr)
+func openDB(dsn string) *sql.DB {
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		log.Fatal("Ошибка подключения к БД:", err)
 	}
@@ -48,326 +66,175 @@ func initDB() {
 	}
 
 	log.Println("Успешное подключение к базе данных")
-
-	createClothesTable()
-	createAccessoriesTable()
-	createUsersTable()
-	createOrdersTable()
+	return db
 }
 
-func createClothesTable() {
-	query := `
-	CREATE TABLE IF NOT EXISTS clothes (
-		id SERIAL PRIMARY KEY,
-		name VARCHAR(255) NOT NULL,
-		description TEXT,
-		image_url VARCHAR(500),
-		price DECIMAL(10, 2) NOT NULL,
-		size VARCHAR(10),
-		color VARCHAR(50),
-		material VARCHAR(100),
-		type VARCHAR(100),
-		season VARCHAR(50)
-	)`
-
-	_, err := db.Exec(query)
-	if err != nil {
-		log.Fatal("Ошибка при создании таблицы clothes:", err)
-	}
-	log.Println("Таблица clothes создана/проверена")
-}
+func initDB(dsn string) *sql.DB {
+	db := openDB(dsn)
 
-func createAccessoriesTable() {
-	query := `
-	CREATE TABLE IF NOT EXISTS accessories (
-		id SERIAL PRIMARY KEY,
-		name VARCHAR(255) NOT NULL,
-		description TEXT,
-		image_url VARCHAR(500),
-		price DECIMAL(10, 2) NOT NULL,
-		type VARCHAR(100),
-		color VARCHAR(50),
-		material VARCHAR(100),
-		target VARCHAR(100)
-	)`
-
-	_, err := db.Exec(query)
+	applied, err := migrations.Up(context.Background(), db)
 	if err != nil {
-		log.Fatal("Ошибка при создании таблицы accessories:", err)
+		log.Fatal("Ошибка при применении миграций:", err)
 	}
-	log.Println("Таблица accessories создана/проверена")
-}
-
-func createUsersTable() {
-	query := `
-	CREATE TABLE IF NOT EXISTS users (
-		id SERIAL PRIMARY KEY,
-		username VARCHAR(100) UNIQUE NOT NULL,
-		password VARCHAR(255) NOT NULL,
-		email VARCHAR(255) NOT NULL,
-		role VARCHAR(50) DEFAULT 'user'
-	)`
-
-	_, err := db.Exec(query)
-	if err != nil {
-		log.Fatal("Ошибка при создании таблицы users:", err)
+	for _, m := range applied {
+		log.Printf("Применена миграция %04d_%s", m.Version, m.Name)
 	}
-	log.Println("Таблица users создана/проверена")
+	log.Println("Схема базы данных актуальна")
+	return db
 }
 
-func createOrdersTable() {
-	query := `
-	CREATE TABLE IF NOT EXISTS orders (
-		id SERIAL PRIMARY KEY,
-		product_name VARCHAR(255) NOT NULL,
-		product_category VARCHAR(50) NOT NULL,
-		first_name VARCHAR(100) NOT NULL,
-		last_name VARCHAR(100) NOT NULL,
-		middle_name VARCHAR(100),
-		phone VARCHAR(20) NOT NULL,
-		quantity INTEGER NOT NULL,
-		region VARCHAR(100) NOT NULL,
-		city VARCHAR(100) NOT NULL,
-		street VARCHAR(100) NOT NULL,
-		house VARCHAR(20) NOT NULL,
-		apartment VARCHAR(20),
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	)`
-
-	_, err := db.Exec(query)
-	if err != nil {
-		log.Fatal("Ошибка при создании таблицы orders:", err)
-	}
-	log.Println("Таблица orders создана/проверена")
-}
-
-func loadProducts() {
-	rows, err := db.Query("SELECT id, name, description, image_url, price, size, color, material, type, season FROM clothes")
-	if err != nil {
-		log.Println("Ошибка при загрузке одежды:", err)
-		return
-	}
-	defer rows.Close()
-
-	products.Clothes = make(map[string]products.Clothing)
-
-	for rows.Next() {
-		var clothing products.Clothing
-		if err := rows.Scan(&clothing.ID, &clothing.Name, &clothing.Description, &clothing.ImageURL, &clothing.Price,
-			&clothing.Size, &clothing.Color, &clothing.Material, &clothing.Type, &clothing.Season); err != nil {
-			log.Println("Ошибка при сканировании строки одежды:", err)
-			continue
-		}
-
-		clothing.ImageURL = strings.Replace(clothing.ImageURL, "\\", "/", -1)
-		products.Clothes[clothing.ID] = clothing
-	}
-
-	rowsAccessories, err := db.Query("SELECT id, name, description, image_url, price, type, color, material, target FROM accessories")
-	if err != nil {
-		log.Println("Ошибка при загрузке аксессуаров:", err)
-		return
-	}
-	defer rowsAccessories.Close()
-
-	products.Accessories = make(map[string]products.Accessory)
-
-	for rowsAccessories.Next() {
-		var accessory products.Accessory
-		if err := rowsAccessories.Scan(&accessory.ID, &accessory.Name, &accessory.Description, &accessory.ImageURL, &accessory.Price,
-			&accessory.Type, &accessory.Color, &accessory.Material, &accessory.Target); err != nil {
-			log.Println("Ошибка при сканировании строки аксессуара:", err)
-			continue
-		}
-
-		accessory.ImageURL = strings.Replace(accessory.ImageURL, "\\", "/", -1)
-		products.Accessories[accessory.ID] = accessory
-	}
-
-	log.Printf("Загружено %d товаров одежды и %d аксессуаров", len(products.Clothes), len(products.Accessories))
-}
-
-func clothingHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *server) clothingHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	productID := vars["id"]
 
-	clothing, exists := products.Clothes[productID]
+	clothing, exists := srv.catalog.Clothing(productID)
 	if !exists {
 		http.NotFound(w, r)
 		return
 	}
 
-	err := clothingTpl.Execute(w, clothing)
+	err := clothingTpl.Execute(w, struct {
+		products.Clothing
+		CSRFToken string
+	}{clothing, srv.csrfToken(w, r)})
 	if err != nil {
 		log.Println("Ошибка при рендеринге шаблона одежды:", err)
 		http.Error(w, "Ошибка при отображении страницы", http.StatusInternalServerError)
 	}
 }
 
-func accessoryHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *server) accessoryHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	productID := vars["id"]
 
-	accessory, exists := products.Accessories[productID]
+	accessory, exists := srv.catalog.Accessory(productID)
 	if !exists {
 		http.NotFound(w, r)
 		return
 	}
 
-	err := accessoryTpl.Execute(w, accessory)
+	err := accessoryTpl.Execute(w, struct {
+		products.Accessory
+		CSRFToken string
+	}{accessory, srv.csrfToken(w, r)})
 	if err != nil {
 		log.Println("Ошибка при рендеринге шаблона аксессуара:", err)
 		http.Error(w, "Ошибка при отображении страницы", http.StatusInternalServerError)
 	}
 }
 
-func orderHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	productID := vars["id"]
-
-	clothing, exists := products.Clothes[productID]
-	if exists {
-		err := orderTpl.Execute(w, map[string]interface{}{
-			"ProductName": clothing.Name,
-			"Category":    "Одежда",
-			"ProductID":   productID,
-		})
-		if err != nil {
-			log.Println("Ошибка при рендеринге шаблона заказа (одежда):", err)
-			http.Error(w, "Ошибка при отображении страницы", http.StatusInternalServerError)
-		}
-		return
+// stageImage проверяет загруженное изображение и готовит его копии. В
+// хранилище файлы попадают только после staged.Commit.
+func (srv *server) stageImage(file io.Reader) (*images.Staged, error) {
+	upload, err := images.Read(file)
+	if err != nil {
+		return nil, err
 	}
+	return images.Stage(srv.images, upload)
+}
 
-	accessory, existsAccessory := products.Accessories[productID]
-	if existsAccessory {
-		err := orderTpl.Execute(w, map[string]interface{}{
-			"ProductName": accessory.Name,
-			"Category":    "Аксессуары",
-			"ProductID":   productID,
-		})
-		if err != nil {
-			log.Println("Ошибка при рендеринге шаблона заказа (аксессуар):", err)
-			http.Error(w, "Ошибка при отображении страницы", http.StatusInternalServerError)
-		}
-		return
+// imageError отвечает на ошибку загрузки изображения: проблемы с самим
+// файлом — ошибка клиента, остальное — сервера.
+func imageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, images.ErrTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, images.ErrUnsupported), errors.Is(err, images.ErrCorrupt):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Println("Ошибка при сохранении файла:", err)
+		http.Error(w, "Ошибка при сохранении файла", http.StatusInternalServerError)
 	}
+}
 
-	http.NotFound(w, r)
+// commitImage записывает изображение в хранилище после записи товара в базу.
+func commitImage(w http.ResponseWriter, r *http.Request, staged *images.Staged) bool {
+	if err := staged.Commit(r.Context()); err != nil {
+		log.Println("Ошибка при сохранении файла:", err)
+		http.Error(w, "Товар сохранён, но изображение не записано", http.StatusInternalServerError)
+		return false
+	}
+	return true
 }
 
-func submitOrderHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodPost {
-		productName := r.FormValue("product_name")
-		productCategory := r.FormValue("product_category")
-		firstName := r.FormValue("first_name")
-		lastName := r.FormValue("last_name")
-		middleName := r.FormValue("middle_name")
-		phone := r.FormValue("phone")
-		quantityStr := r.FormValue("quantity")
-		region := r.FormValue("region")
-		city := r.FormValue("city")
-		street := r.FormValue("street")
-		house := r.FormValue("house")
-		apartment := r.FormValue("apartment")
-
-		// Преобразуем количество
-		quantity, err := strconv.Atoi(quantityStr)
-		if err != nil {
-			log.Println("Ошибка при преобразовании количества:", err)
-			http.Error(w, "Ошибка при преобразовании количества", http.StatusBadRequest)
-			return
+// deleteImages удаляет из хранилища файлы удалённого товара. Товар к этому
+// моменту уже удалён, поэтому ошибка только пишется в лог: оставшиеся файлы
+// подберёт velur images gc. Исходные фото среди ресурсов не удаляются.
+func (srv *server) deleteImages(ctx context.Context, urls []string) {
+	for _, url := range urls {
+		name, ok := srv.images.Name(url)
+		if !ok {
+			continue
 		}
-		_, err = db.Exec(`
-			INSERT INTO orders (product_name, product_category, first_name, last_name, middle_name, phone, quantity, region, city, street, house, apartment) 
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
-			productName, productCategory, firstName, lastName, middleName, phone, quantity, region, city, street, house, apartment)
-
-		if err != nil {
-			log.Println("Ошибка при сохранении заказа в базу данных:", err)
-			http.Error(w, "Ошибка при сохранении заказа", http.StatusInternalServerError)
-			return
+		if err := srv.images.Delete(ctx, name); err != nil {
+			log.Println("Ошибка при удалении изображения:", err)
 		}
-
-		log.Printf("Заказ успешно оформлен: %s (%s)", productName, productCategory)
-		orderSuccessTpl.Execute(w, nil)
 	}
 }
 
-func addClothing(w http.ResponseWriter, r *http.Request) {
+func (srv *server) addClothing(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		name := r.FormValue("name")
 		description := r.FormValue("description")
 		priceStr := r.FormValue("price")
-		size := r.FormValue("size")
-		color := r.FormValue("color")
 		material := r.FormValue("material")
 		clothingType := r.FormValue("type")
 		season := r.FormValue("season")
+		colors := parseColors(r.FormValue("colors"))
+		sizes := r.Form["sizes"]
 
-		price, err := strconv.ParseFloat(priceStr, 64)
+		price, err := parsePrice(priceStr)
 		if err != nil {
 			log.Println("Ошибка при преобразовании цены:", err)
-			http.Error(w, "Ошибка при преобразовании цены", http.StatusBadRequest)
+			http.Error(w, "Некорректная цена товара", http.StatusBadRequest)
 			return
 		}
 
-		file, header, err := r.FormFile("image")
-		if err != nil {
-			log.Println("Ошибка при получении файла:", err)
-			http.Error(w, "Ошибка при получении файла", http.StatusBadRequest)
+		stock, err := strconv.Atoi(r.FormValue("stock"))
+		if err != nil || stock < 0 {
+			http.Error(w, "Некорректный остаток на складе", http.StatusBadRequest)
 			return
 		}
-		defer file.Close()
 
-		imageDir := filepath.Join("assets", "product_images")
-		if err := os.MkdirAll(imageDir, os.ModePerm); err != nil {
-			log.Println("Ошибка при создании директории:", err)
-			http.Error(w, "Ошибка при создании директории", http.StatusInternalServerError)
+		if len(sizes) == 0 || len(colors) == 0 {
+			http.Error(w, "Укажите хотя бы один размер и один цвет", http.StatusBadRequest)
 			return
 		}
 
-		imagePath := filepath.Join(imageDir, header.Filename)
-		out, err := os.Create(imagePath)
+		file, _, err := r.FormFile("image")
 		if err != nil {
-			log.Println("Ошибка при сохранении файла:", err)
-			http.Error(w, "Ошибка при сохранении файла", http.StatusInternalServerError)
-			return
-		}
-		defer out.Close()
-
-		if _, err := io.Copy(out, file); err != nil {
-			log.Println("Ошибка при записи файла:", err)
-			http.Error(w, "Ошибка при записи файла", http.StatusInternalServerError)
+			log.Println("Ошибка при получении файла:", err)
+			http.Error(w, "Ошибка при получении файла", http.StatusBadRequest)
 			return
 		}
+		defer file.Close()
 
-		imagePath = strings.Replace(imagePath, "\\", "/", -1)
-
-		var id int
-		err = db.QueryRow(`
-			INSERT INTO clothes (name, description, price, image_url, size, color, material, type, season) 
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) 
-			RETURNING id`,
-			name, description, price, imagePath, size, color, material, clothingType, season).Scan(&id)
-
+		staged, err := srv.stageImage(file)
 		if err != nil {
-			log.Println("Ошибка при добавлении одежды в базу данных:", err)
-			http.Error(w, "Ошибка при добавлении товара", http.StatusInternalServerError)
+			imageError(w, err)
 			return
 		}
 
-		products.Clothes[fmt.Sprint(id)] = products.Clothing{
-			ID:          fmt.Sprint(id),
+		clothing, err := srv.products.CreateClothing(r.Context(), products.Clothing{
 			Name:        name,
 			Description: description,
 			Price:       price,
-			ImageURL:    imagePath,
-			Size:        size,
-			Color:       color,
+			ImageURL:    staged.URL,
+			ImageSizes:  staged.Sizes,
 			Material:    material,
 			Type:        clothingType,
 			Season:      season,
+			Variants:    buildVariants(sizes, colors, stock),
+		})
+		if err != nil {
+			log.Println("Ошибка при добавлении одежды в базу данных:", err)
+			http.Error(w, "Ошибка при добавлении товара", http.StatusInternalServerError)
+			return
+		}
+
+		srv.catalog.Update(func(s *catalog.Snapshot) { s.Clothes[clothing.ID] = clothing })
+		if !commitImage(w, r, staged) {
+			return
 		}
 
 		log.Println("Одежда успешно добавлена:", name)
@@ -375,7 +242,7 @@ func addClothing(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func addAccessory(w http.ResponseWriter, r *http.Request) {
+func (srv *server) addAccessory(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		name := r.FormValue("name")
 		description := r.FormValue("description")
@@ -385,68 +252,54 @@ func addAccessory(w http.ResponseWriter, r *http.Request) {
 		material := r.FormValue("material")
 		target := r.FormValue("target")
 
-		price, err := strconv.ParseFloat(priceStr, 64)
+		price, err := parsePrice(priceStr)
 		if err != nil {
 			log.Println("Ошибка при преобразовании цены:", err)
-			http.Error(w, "Ошибка при преобразовании цены", http.StatusBadRequest)
+			http.Error(w, "Некорректная цена товара", http.StatusBadRequest)
 			return
 		}
 
-		file, header, err := r.FormFile("image")
-		if err != nil {
-			log.Println("Ошибка при получении файла:", err)
-			http.Error(w, "Ошибка при получении файла", http.StatusBadRequest)
+		stock, err := strconv.Atoi(r.FormValue("stock"))
+		if err != nil || stock < 0 {
+			http.Error(w, "Некорректный остаток на складе", http.StatusBadRequest)
 			return
 		}
-		defer file.Close()
 
-		imageDir := filepath.Join("assets", "product_images")
-		if err := os.MkdirAll(imageDir, os.ModePerm); err != nil {
-			log.Println("Ошибка при создании директории:", err)
-			http.Error(w, "Ошибка при создании директории", http.StatusInternalServerError)
-			return
-		}
-
-		imagePath := filepath.Join(imageDir, header.Filename)
-		out, err := os.Create(imagePath)
+		file, _, err := r.FormFile("image")
 		if err != nil {
-			log.Println("Ошибка при сохранении файла:", err)
-			http.Error(w, "Ошибка при сохранении файла", http.StatusInternalServerError)
-			return
-		}
-		defer out.Close()
-
-		if _, err := io.Copy(out, file); err != nil {
-			log.Println("Ошибка при записи файла:", err)
-			http.Error(w, "Ошибка при записи файла", http.StatusInternalServerError)
+			log.Println("Ошибка при получении файла:", err)
+			http.Error(w, "Ошибка при получении файла", http.StatusBadRequest)
 			return
 		}
+		defer file.Close()
 
-		imagePath = strings.Replace(imagePath, "\\", "/", -1)
-
-		var id int
-		err = db.QueryRow(`
-			INSERT INTO accessories (name, description, price, image_url, type, color, material, target) 
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) 
-			RETURNING id`,
-			name, description, price, imagePath, accessoryType, color, material, target).Scan(&id)
-
+		staged, err := srv.stageImage(file)
 		if err != nil {
-			log.Println("Ошибка при добавлении аксессуара в базу данных:", err)
-			http.Error(w, "Ошибка при добавлении аксессуара", http.StatusInternalServerError)
+			imageError(w, err)
 			return
 		}
 
-		products.Accessories[fmt.Sprint(id)] = products.Accessory{
-			ID:          fmt.Sprint(id),
+		accessory, err := srv.products.CreateAccessory(r.Context(), products.Accessory{
 			Name:        name,
 			Description: description,
 			Price:       price,
-			ImageURL:    imagePath,
+			ImageURL:    staged.URL,
+			ImageSizes:  staged.Sizes,
 			Type:        accessoryType,
 			Color:       color,
 			Material:    material,
 			Target:      target,
+			Stock:       stock,
+		})
+		if err != nil {
+			log.Println("Ошибка при добавлении аксессуара в базу данных:", err)
+			http.Error(w, "Ошибка при добавлении аксессуара", http.StatusInternalServerError)
+			return
+		}
+
+		srv.catalog.Update(func(s *catalog.Snapshot) { s.Accessories[accessory.ID] = accessory })
+		if !commitImage(w, r, staged) {
+			return
 		}
 
 		log.Println("Аксессуар успешно добавлен:", name)
@@ -454,58 +307,88 @@ func addAccessory(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func deleteClothing(w http.ResponseWriter, r *http.Request) {
+func (srv *server) deleteClothing(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	productID := vars["id"]
 
-	_, err := db.Exec("DELETE FROM clothes WHERE id = $1", productID)
+	unused, err := srv.products.DeleteClothing(r.Context(), productID)
 	if err != nil {
 		log.Println("Ошибка при удалении одежды из базы данных:", err)
 		http.Error(w, "Ошибка при удалении товара", http.StatusInternalServerError)
 		return
 	}
 
-	delete(products.Clothes, productID)
+	srv.catalog.Update(func(s *catalog.Snapshot) { delete(s.Clothes, productID) })
+	srv.deleteImages(r.Context(), unused)
 	log.Println("Одежда успешно удалена:", productID)
 	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
-func deleteAccessory(w http.ResponseWriter, r *http.Request) {
+func (srv *server) deleteAccessory(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	productID := vars["id"]
 
-	_, err := db.Exec("DELETE FROM accessories WHERE id = $1", productID)
+	unused, err := srv.products.DeleteAccessory(r.Context(), productID)
 	if err != nil {
 		log.Println("Ошибка при удалении аксессуара из базы данных:", err)
 		http.Error(w, "Ошибка при удалении товара", http.StatusInternalServerError)
 		return
 	}
 
-	delete(products.Accessories, productID)
+	srv.catalog.Update(func(s *catalog.Snapshot) { delete(s.Accessories, productID) })
+	srv.deleteImages(r.Context(), unused)
 	log.Println("Аксессуар успешно удален:", productID)
 	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
-func marketHandler(w http.ResponseWriter, r *http.Request) {
-	loadProducts()
+func (srv *server) setAccessoryStock(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID := vars["id"]
+
+	stock, err := strconv.Atoi(r.FormValue("stock"))
+	if err != nil || stock < 0 {
+		http.Error(w, "Некорректный остаток на складе", http.StatusBadRequest)
+		return
+	}
+
+	err = srv.products.SetAccessoryStock(r.Context(), productID, stock)
+	if err != nil {
+		log.Println("Ошибка при обновлении остатка аксессуара:", err)
+		http.Error(w, "Ошибка при обновлении остатка", http.StatusInternalServerError)
+		return
+	}
+
+	srv.catalog.Update(func(s *catalog.Snapshot) {
+		if accessory, exists := s.Accessories[productID]; exists {
+			accessory.Stock = stock
+			s.Accessories[productID] = accessory
+		}
+	})
+	log.Printf("Остаток аксессуара %s изменён: %d", productID, stock)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
 
+func (srv *server) marketHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot := srv.catalog.Snapshot()
 	data := struct {
 		Clothes     map[string]products.Clothing
 		Accessories map[string]products.Accessory
 		Username    string
-		Role        string
+		AdminURL    string
+		CartCount   int
+		CSRFToken   string
 	}{
-		Clothes:     products.Clothes,
-		Accessories: products.Accessories,
+		Clothes:     snapshot.Clothes,
+		Accessories: snapshot.Accessories,
+		CSRFToken:   srv.csrfToken(w, r),
 	}
 
-	session, _ := store.Get(r, "session-name")
+	session, _ := srv.store.Get(r, "session-name")
+	data.CartCount = srv.cartCount(r.Context(), session)
 	if username, ok := session.Values["username"].(string); ok {
 		data.Username = username
 	}
-	if role, ok := session.Values["role"].(string); ok {
-		data.Role = role
-	}
+	data.AdminURL = srv.adminHome(r.Context(), session)
 
 	if err := marketTpl.Execute(w, data); err != nil {
 		log.Println("Ошибка при рендеринге главной страницы:", err)
@@ -513,28 +396,35 @@ func marketHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func aboutHandler(w http.ResponseWriter, r *http.Request) {
-	session, _ := store.Get(r, "session-name")
+func (srv *server) aboutHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := srv.store.Get(r, "session-name")
 	data := struct {
-		Username string
-		Role     string
-	}{}
+		Username  string
+		AdminURL  string
+		CartCount int
+		CSRFToken string
+	}{
+		CartCount: srv.cartCount(r.Context(), session),
+		CSRFToken: srv.csrfToken(w, r),
+	}
 
 	if username, ok := session.Values["username"].(string); ok {
 		data.Username = username
 	}
-	if role, ok := session.Values["role"].(string); ok {
-		data.Role = role
-	}
+	data.AdminURL = srv.adminHome(r.Context(), session)
 
 	aboutTpl.Execute(w, data)
 }
 
-func registrationHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *server) registrationHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		username := r.FormValue("username")
 		password := r.FormValue("password")
-		email := r.FormValue("email")
+		email := strings.TrimSpace(r.FormValue("email"))
+		if !validEmail(email) {
+			http.Error(w, "Некорректный адрес электронной почты", http.StatusBadRequest)
+			return
+		}
 
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 		if err != nil {
@@ -542,114 +432,139 @@ func registrationHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		role := "user"
-		if username == "admin" {
-			role = "admin"
+		user, err := srv.users.CreateUser(r.Context(), repository.User{
+			Username:     username,
+			Email:        email,
+			PasswordHash: hashedPassword,
+			Role:         roles.Customer,
+		})
+		if errors.Is(err, repository.ErrConflict) {
+			http.Error(w, "Пользователь с таким именем уже существует", http.StatusConflict)
+			return
 		}
-
-		_, err = db.Exec("INSERT INTO users (username, password, email, role) VALUES ($1, $2, $3, $4)",
-			username, hashedPassword, email, role)
-
 		if err != nil {
 			log.Printf("Ошибка при сохранении пользователя: %v", err)
 			http.Error(w, "Ошибка при сохранении пользователя", http.StatusInternalServerError)
 			return
 		}
+		srv.enqueueMail(r.Context(), notify.Welcome, user.Email, map[string]interface{}{
+			"Username": user.Username,
+			"URL":      srv.cfg.URL("/"),
+		})
 
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-	registrationTpl.Execute(w, nil)
+	registrationTpl.Execute(w, map[string]interface{}{"CSRFToken": srv.csrfToken(w, r)})
 }
 
-func loginHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *server) loginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		username := r.FormValue("username")
 		password := r.FormValue("password")
 
-		var hashedPassword string
-		var role string
-		err := db.QueryRow("SELECT password, role FROM users WHERE username = $1", username).Scan(&hashedPassword, &role)
+		user, err := srv.users.UserByUsername(r.Context(), username)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			log.Println("Ошибка при поиске пользователя:", err)
+		}
 
-		if err != nil || bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) != nil {
+		if err != nil || bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)) != nil {
 			data := struct {
 				ErrorMessage string
+				Notice       string
+				CSRFToken    string
 			}{
 				ErrorMessage: "Неверное имя пользователя или пароль",
+				CSRFToken:    srv.csrfToken(w, r),
 			}
 			loginTpl.Execute(w, data)
 			return
 		}
 
-		session, _ := store.Get(r, "session-name")
-		session.Values["username"] = username
-		session.Values["role"] = role
+		session, _ := srv.store.Get(r, "session-name")
+		if err := srv.mergeSessionCart(r.Context(), session, user.ID); err != nil {
+			log.Println("Ошибка при переносе корзины:", err)
+		}
+		session.Values["user_id"] = user.ID
+		session.Values["username"] = user.Username
+		session.Values["role"] = user.Role
+		session.Values["session_version"] = user.SessionVersion
+		// Новый токен после входа: токен, выданный гостю, не должен
+		// действовать от имени пользователя.
+		delete(session.Values, "csrf_token")
 		session.Save(r, w)
 
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-	loginTpl.Execute(w, nil)
+	data := map[string]interface{}{"CSRFToken": srv.csrfToken(w, r)}
+	if r.URL.Query().Get("reset") == "done" {
+		data["Notice"] = "Пароль изменён. Войдите с новым паролем."
+	}
+	loginTpl.Execute(w, data)
 }
 
-func logoutHandler(w http.ResponseWriter, r *http.Request) {
-	session, _ := store.Get(r, "session-name")
-	delete(session.Values, "username")
-	delete(session.Values, "role")
+func (srv *server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := srv.store.Get(r, "session-name")
+	endSession(session)
 	session.Save(r, w)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func adminHandler(w http.ResponseWriter, r *http.Request) {
-	session, _ := store.Get(r, "session-name")
-	if session.Values["role"] != "admin" {
-		http.Error(w, "Доступ запрещен", http.StatusForbidden)
-		return
-	}
-
-	loadProducts()
+func (srv *server) adminHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot := srv.catalog.Snapshot()
 	data := struct {
 		Clothes     map[string]products.Clothing
 		Accessories map[string]products.Accessory
+		CSRFToken   string
+		Orders      bool
+		Jobs        bool
 	}{
-		Clothes:     products.Clothes,
-		Accessories: products.Accessories,
+		Clothes:     snapshot.Clothes,
+		Accessories: snapshot.Accessories,
+		CSRFToken:   srv.csrfToken(w, r),
+		Orders:      accessFrom(r.Context()).can(roles.OrdersView),
+		Jobs:        accessFrom(r.Context()).can(roles.JobsManage),
 	}
 
 	adminTpl.Execute(w, data)
 }
 
-func addProductHandler(w http.ResponseWriter, r *http.Request) {
-	adminTpl.Execute(w, nil)
-}
-
 func main() {
-	initDB()
-	loadProducts()
-
-	r := mux.NewRouter()
-
-	fs := http.FileServer(http.Dir("assets"))
-	r.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", fs))
-
-	r.HandleFunc("/", marketHandler).Methods("GET")
-	r.HandleFunc("/about", aboutHandler).Methods("GET")
-	r.HandleFunc("/registration", registrationHandler).Methods("GET", "POST")
-	r.HandleFunc("/login", loginHandler).Methods("GET", "POST")
-	r.HandleFunc("/logout", logoutHandler).Methods("GET")
-	r.HandleFunc("/admin", adminHandler).Methods("GET")
-	r.HandleFunc("/admin/add-clothing", addClothing).Methods("POST")
-	r.HandleFunc("/admin/add-accessory", addAccessory).Methods("POST")
-	r.HandleFunc("/admin/delete-clothing/{id:[0-9]+}", deleteClothing).Methods("POST")
-	r.HandleFunc("/admin/delete-accessory/{id:[0-9]+}", deleteAccessory).Methods("POST")
-	r.HandleFunc("/clothing/{id:[0-9]+}", clothingHandler).Methods("GET")
-	r.HandleFunc("/accessory/{id:[0-9]+}", accessoryHandler).Methods("GET")
-	r.HandleFunc("/order/clothing/{id:[0-9]+}", orderHandler).Methods("GET")
-	r.HandleFunc("/order/accessory/{id:[0-9]+}", orderHandler).Methods("GET")
-	r.HandleFunc("/order", submitOrderHandler).Methods("POST")
-
-	log.Println("Запуск веб-сервера магазина Velur на http://localhost:7070")
-	err := http.ListenAndServe(":7070", r)
+	cfg, args, err := config.Load(os.Args[1:], os.Getenv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if len(args) > 0 {
+		os.Exit(runCommand(cfg, args))
+	}
+
+	if err := cfg.ValidateSession(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	var store repository.Store
+	if cfg.Storage == config.StorageMemory {
+		log.Println("Данные хранятся в памяти и будут потеряны при перезапуске")
+		store = repository.NewMemory()
+	} else {
+		store = repository.NewPostgres(initDB(cfg.DatabaseURL))
+	}
+
+	srv := newServer(cfg, store)
+	if err := srv.catalog.Reload(context.Background()); err != nil {
+		log.Fatal("Ошибка при загрузке каталога:", err)
+	}
+	snapshot := srv.catalog.Snapshot()
+	log.Printf("Загружено %d товаров одежды и %d аксессуаров", len(snapshot.Clothes), len(snapshot.Accessories))
+	go srv.catalog.Run(context.Background(), catalogRefreshInterval)
+	go srv.runWorker(context.Background(), jobInterval)
+
+	log.Println("Запуск веб-сервера магазина Velur на", cfg.Addr)
+	err = http.ListenAndServe(cfg.Addr, srv.routes())
 	if err != nil {
 		log.Fatal("Ошибка при запуске сервера:", err)
 	}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golangify.com/snippetbox/roles"
+)
+
+func TestMarketHandler(t *testing.T) {
+	app := newTestApp(t)
+	app.seedClothing(t, "Льняное платье", 2)
+	app.seedAccessory(t, "Кожаная сумка", 0)
+
+	resp := app.client(t).get("/")
+	assertStatus(t, resp, http.StatusOK)
+	assertContains(t, resp.body, "Льняное платье")
+	assertContains(t, resp.body, "Кожаная сумка")
+	assertContains(t, resp.body, "Нет в наличии")
+}
+
+func TestStaticPages(t *testing.T) {
+	app := newTestApp(t)
+	c := app.client(t)
+
+	for _, path := range []string{"/about", "/registration", "/login", "/assets/market.css"} {
+		t.Run(path, func(t *testing.T) {
+			assertStatus(t, c.get(path), http.StatusOK)
+		})
+	}
+}
+
+func TestProductPages(t *testing.T) {
+	app := newTestApp(t)
+	clothing := app.seedClothing(t, "Льняное платье", 2)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 1)
+	c := app.client(t)
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/clothing/" + clothing.ID, http.StatusOK, "Льняное платье"},
+		{"/accessory/" + accessory.ID, http.StatusOK, "Кожаная сумка"},
+		{"/clothing/" + accessory.ID, http.StatusNotFound, ""},
+		{"/accessory/999", http.StatusNotFound, ""},
+		{"/clothing/abc", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp := c.get(tt.path)
+			assertStatus(t, resp, tt.status)
+			assertContains(t, resp.body, tt.body)
+		})
+	}
+}
+
+func TestRegistrationAndLogin(t *testing.T) {
+	app := newTestApp(t)
+	c := app.client(t)
+
+	form := url.Values{"username": {"anna"}, "password": {"secret"}, "email": {"anna@example.com"}}
+	assertRedirect(t, c.postForm("/registration", form), "/")
+	assertStatus(t, c.postForm("/registration", form), http.StatusConflict)
+
+	user, err := app.store.UserByUsername(context.Background(), "anna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != "user" || string(user.PasswordHash) == "secret" {
+		t.Fatalf("пользователь сохранён неверно: %+v", user)
+	}
+
+	// Имя admin больше не даёт прав: администраторов создаёт команда user.
+	admin := url.Values{"username": {"admin"}, "password": {"secret"}, "email": {"admin@example.com"}}
+	assertRedirect(t, c.postForm("/registration", admin), "/")
+	if user, _ := app.store.UserByUsername(context.Background(), "admin"); user.Role != roles.Customer {
+		t.Fatalf("роль admin после регистрации: %q", user.Role)
+	}
+
+	resp := c.postForm("/login", url.Values{"username": {"anna"}, "password": {"wrong"}})
+	assertStatus(t, resp, http.StatusOK)
+	assertContains(t, resp.body, "Неверное имя пользователя или пароль")
+
+	resp = c.postForm("/login", url.Values{"username": {"nobody"}, "password": {"secret"}})
+	assertContains(t, resp.body, "Неверное имя пользователя или пароль")
+
+	c.login("anna", "secret")
+	assertContains(t, c.get("/").body, "Добро пожаловать, anna!")
+
+	// Выйти можно только формой с токеном: чужая страница не разлогинит.
+	assertStatus(t, c.get("/logout"), http.StatusMethodNotAllowed)
+	assertStatus(t, c.postForm("/logout", url.Values{csrfField: {""}}), http.StatusForbidden)
+	assertContains(t, c.get("/").body, "Добро пожаловать, anna!")
+	assertRedirect(t, c.postForm("/logout", url.Values{}), "/")
+	resp = c.get("/")
+	if resp.status != http.StatusOK || strings.Contains(resp.body, "Добро пожаловать, anna") {
+		t.Fatal("после выхода пользователь всё ещё вошедший")
+	}
+}
+
+func clothingForm() url.Values {
+	return url.Values{
+		"name":        {"Шёлковая блузка"},
+		"description": {"Свободный крой"},
+		"price":       {"2500"},
+		"material":    {"Шёлк"},
+		"type":        {"Блузка"},
+		"season":      {"Лето"},
+		"sizes":       {"S", "M"},
+		"colors":      {"Белый, Чёрный"},
+		"stock":       {"3"},
+	}
+}
+
+func TestAddClothing(t *testing.T) {
+	app := newTestApp(t)
+	admin := app.adminClient(t)
+
+	resp := admin.postMultipart("/admin/add-clothing", clothingForm(), "blouse.png", testPNG(t, 40, 30))
+	assertRedirect(t, resp, "/admin")
+
+	snapshot := app.srv.catalog.Snapshot()
+	if len(snapshot.Clothes) != 1 {
+		t.Fatalf("в каталоге %d товаров, ожидался 1", len(snapshot.Clothes))
+	}
+	for _, clothing := range snapshot.Clothes {
+		if clothing.Name != "Шёлковая блузка" || clothing.Price != 2500 || len(clothing.Variants) != 4 {
+			t.Fatalf("товар сохранён неверно: %+v", clothing)
+		}
+		if _, err := os.Stat(filepath.Join(app.upload, filepath.Base(clothing.ImageURL))); err != nil {
+			t.Fatalf("изображение не сохранено: %v", err)
+		}
+	}
+}
+
+func TestAddClothingValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		field    string
+		value    []string
+		filename string
+	}{
+		{"bad price", "price", []string{"дорого"}, "blouse.png"},
+		{"negative price", "price", []string{"-5"}, "blouse.png"},
+		{"nan price", "price", []string{"NaN"}, "blouse.png"},
+		{"infinite price", "price", []string{"Inf"}, "blouse.png"},
+		{"bad stock", "stock", []string{"-1"}, "blouse.png"},
+		{"no sizes", "sizes", nil, "blouse.png"},
+		{"no colors", "colors", []string{" , "}, "blouse.png"},
+		{"missing image", "", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			admin := app.adminClient(t)
+
+			form := clothingForm()
+			if tt.field != "" {
+				form[tt.field] = tt.value
+			}
+			resp := admin.postMultipart("/admin/add-clothing", form, tt.filename, testPNG(t, 40, 30))
+			assertStatus(t, resp, http.StatusBadRequest)
+			if n := len(app.srv.catalog.Snapshot().Clothes); n != 0 {
+				t.Fatalf("после ошибки в каталоге %d товаров", n)
+			}
+		})
+	}
+}
+
+func TestAddAccessory(t *testing.T) {
+	form := url.Values{
+		"name":  {"Шарф"},
+		"price": {"900"},
+		"type":  {"Шарф"},
+		"color": {"Серый"},
+		"stock": {"5"},
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		app := newTestApp(t)
+		admin := app.adminClient(t)
+
+		assertRedirect(t, admin.postMultipart("/admin/add-accessory", form, "scarf.png", testPNG(t, 40, 30)), "/admin")
+		for _, accessory := range app.srv.catalog.Snapshot().Accessories {
+			if accessory.Name != "Шарф" || accessory.Stock != 5 {
+				t.Fatalf("аксессуар сохранён неверно: %+v", accessory)
+			}
+		}
+	})
+
+	t.Run("bad price", func(t *testing.T) {
+		app := newTestApp(t)
+		admin := app.adminClient(t)
+
+		for _, price := range []string{"", "-5", "0"} {
+			bad := url.Values{"name": {"Шарф"}, "price": {price}, "stock": {"5"}}
+			assertStatus(t, admin.postMultipart("/admin/add-accessory", bad, "scarf.png", testPNG(t, 40, 30)), http.StatusBadRequest)
+		}
+		if n := len(app.srv.catalog.Snapshot().Accessories); n != 0 {
+			t.Fatalf("после ошибки в каталоге %d аксессуаров", n)
+		}
+	})
+
+	t.Run("missing image", func(t *testing.T) {
+		app := newTestApp(t)
+		admin := app.adminClient(t)
+
+		assertStatus(t, admin.postMultipart("/admin/add-accessory", form, "", nil), http.StatusBadRequest)
+	})
+}
+
+func TestEditProducts(t *testing.T) {
+	app := newTestApp(t)
+	clothing := app.seedClothing(t, "Льняное платье", 2)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 1)
+	admin := app.adminClient(t)
+
+	form := clothingForm()
+	form.Set("name", "Платье-рубашка")
+	assertRedirect(t, admin.postMultipart("/admin/edit-clothing/"+clothing.ID, form, "", nil), "/admin")
+
+	updated, _ := app.srv.catalog.Clothing(clothing.ID)
+	if updated.Name != "Платье-рубашка" || updated.ImageURL != clothing.ImageURL || len(updated.Variants) != 2 {
+		t.Fatalf("товар обновлён неверно: %+v", updated)
+	}
+
+	accessoryForm := url.Values{"name": {"Сумка-шопер"}, "price": {"350"}, "stock": {"4"}}
+	image := testPNG(t, 40, 30)
+	assertRedirect(t, admin.postMultipart("/admin/edit-accessory/"+accessory.ID, accessoryForm, "new.png", image), "/admin")
+
+	updatedAccessory, _ := app.srv.catalog.Accessory(accessory.ID)
+	if updatedAccessory.Name != "Сумка-шопер" || updatedAccessory.Stock != 4 || updatedAccessory.ImageURL != "/uploads/"+imageName(image) {
+		t.Fatalf("аксессуар обновлён неверно: %+v", updatedAccessory)
+	}
+
+	assertStatus(t, admin.postMultipart("/admin/edit-clothing/999", form, "", nil), http.StatusNotFound)
+	for _, price := range []string{"бесплатно", "-5", "NaN"} {
+		form.Set("price", price)
+		assertStatus(t, admin.postMultipart("/admin/edit-clothing/"+clothing.ID, form, "", nil), http.StatusBadRequest)
+	}
+	accessoryForm.Set("price", "-350")
+	assertStatus(t, admin.postMultipart("/admin/edit-accessory/"+accessory.ID, accessoryForm, "", nil), http.StatusBadRequest)
+	if updated, _ := app.srv.catalog.Clothing(clothing.ID); updated.Price <= 0 {
+		t.Fatalf("сохранена недопустимая цена: %v", updated.Price)
+	}
+}
+
+func TestDeleteProducts(t *testing.T) {
+	app := newTestApp(t)
+	clothing := app.seedClothing(t, "Льняное платье", 2)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 1)
+	admin := app.adminClient(t)
+
+	assertRedirect(t, admin.postForm("/admin/delete-clothing/"+clothing.ID, nil), "/admin")
+	assertRedirect(t, admin.postForm("/admin/delete-accessory/"+accessory.ID, nil), "/admin")
+
+	if _, ok := app.srv.catalog.Clothing(clothing.ID); ok {
+		t.Fatal("одежда осталась в каталоге")
+	}
+	if _, ok := app.srv.catalog.Accessory(accessory.ID); ok {
+		t.Fatal("аксессуар остался в каталоге")
+	}
+
+	app.reload(t)
+	if n := len(app.srv.catalog.Snapshot().Clothes) + len(app.srv.catalog.Snapshot().Accessories); n != 0 {
+		t.Fatalf("в хранилище осталось %d товаров", n)
+	}
+	assertStatus(t, admin.get("/clothing/"+clothing.ID), http.StatusNotFound)
+}
+
+func TestVariants(t *testing.T) {
+	app := newTestApp(t)
+	clothing := app.seedClothing(t, "Льняное платье", 2)
+	admin := app.adminClient(t)
+
+	resp := admin.postForm("/admin/add-variant/"+clothing.ID, url.Values{"size": {"L"}, "color": {"Белый"}, "stock": {"1"}, "price": {"1200"}})
+	assertRedirect(t, resp, "/admin")
+
+	resp = admin.postForm("/admin/add-variant/"+clothing.ID, url.Values{"size": {"L"}, "color": {"Белый"}, "stock": {"1"}})
+	assertStatus(t, resp, http.StatusConflict)
+	assertStatus(t, admin.postForm("/admin/add-variant/"+clothing.ID, url.Values{"size": {"XL"}, "stock": {"1"}}), http.StatusBadRequest)
+
+	updated, _ := app.srv.catalog.Clothing(clothing.ID)
+	if len(updated.Variants) != 3 {
+		t.Fatalf("вариантов %d, ожидалось 3", len(updated.Variants))
+	}
+	added := updated.Variants[2]
+	if added.SKU != "VL-"+clothing.ID+"-L-Белый" || updated.VariantPrice(added) != 1200 {
+		t.Fatalf("вариант добавлен неверно: %+v", added)
+	}
+
+	first := clothing.Variants[0]
+	resp = admin.postForm("/admin/update-variant/"+first.ID, url.Values{"sku": {"DRESS-S"}, "stock": {"7"}})
+	assertRedirect(t, resp, "/admin")
+	assertStatus(t, admin.postForm("/admin/update-variant/"+first.ID, url.Values{"sku": {"DRESS-S"}, "stock": {"-7"}}), http.StatusBadRequest)
+	assertStatus(t, admin.postForm("/admin/update-variant/"+first.ID, url.Values{"sku": {added.SKU}, "stock": {"1"}}), http.StatusConflict)
+	assertStatus(t, admin.postForm("/admin/update-variant/999", url.Values{"sku": {"X"}, "stock": {"1"}}), http.StatusNotFound)
+
+	assertRedirect(t, admin.postForm("/admin/delete-variant/"+added.ID, nil), "/admin")
+	assertStatus(t, admin.postForm("/admin/delete-variant/"+added.ID, nil), http.StatusNotFound)
+
+	updated, _ = app.srv.catalog.Clothing(clothing.ID)
+	if v, _ := updated.Variant(first.ID); len(updated.Variants) != 2 || v.SKU != "DRESS-S" || v.Stock != 7 {
+		t.Fatalf("варианты после правок: %+v", updated.Variants)
+	}
+}
+
+func TestSetAccessoryStock(t *testing.T) {
+	app := newTestApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 1)
+	admin := app.adminClient(t)
+
+	assertRedirect(t, admin.postForm("/admin/set-stock-accessory/"+accessory.ID, url.Values{"stock": {"9"}}), "/admin")
+	assertStatus(t, admin.postForm("/admin/set-stock-accessory/"+accessory.ID, url.Values{"stock": {"много"}}), http.StatusBadRequest)
+
+	app.reload(t)
+	if updated, _ := app.srv.catalog.Accessory(accessory.ID); updated.Stock != 9 {
+		t.Fatalf("остаток %d, ожидался 9", updated.Stock)
+	}
+}
@@ -0,0 +1,304 @@
+// Package migrations применяет версионированные изменения схемы базы данных.
+//
+// Скрипты лежат в каталоге sql и встраиваются в бинарник. Каждая миграция —
+// пара файлов NNNN_название.up.sql и NNNN_название.down.sql. Применённые
+// версии и контрольные суммы их up-скриптов хранятся в таблице
+// schema_migrations; если уже применённый скрипт был изменён, миграции
+// останавливаются с ошибкой.
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID — ключ advisory-блокировки, чтобы два экземпляра приложения не
+// применяли миграции одновременно.
+const lockID = 7070_0001
+
+// baseline — версия базовой схемы. Она перенимает таблицы, созданные
+// прежними версиями магазина, поэтому её откат уничтожил бы все данные.
+const baseline = 1
+
+// ErrIrreversible — откат базовой схемы запрещён.
+var ErrIrreversible = errors.New("базовую схему откатить нельзя")
+
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Load читает встроенные миграции, отсортированные по версии.
+func Load() ([]Migration, error) {
+	return load(files)
+}
+
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("неверное имя файла миграции: %s", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, "sql/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("у миграции %d разные названия: %s и %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("у миграции %04d_%s нет up- или down-скрипта", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+type applied struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+func ensureTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		checksum CHAR(64) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	return err
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]applied, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := map[int]applied{}
+	for rows.Next() {
+		var version int
+		var a applied
+		if err := rows.Scan(&version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		versions[version] = a
+	}
+	return versions, rows.Err()
+}
+
+// verify проверяет, что все применённые миграции есть в бинарнике и не
+// изменились с момента применения.
+func verify(migrations []Migration, versions map[int]applied) error {
+	known := map[int]Migration{}
+	for _, m := range migrations {
+		known[m.Version] = m
+	}
+
+	for version, a := range versions {
+		m, ok := known[version]
+		if !ok {
+			return fmt.Errorf("миграция %d применена к базе, но отсутствует в приложении", version)
+		}
+		if m.Checksum != a.checksum {
+			return fmt.Errorf("миграция %04d_%s изменена после применения (контрольная сумма не совпадает)", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// withLock выполняет fn на отдельном соединении под advisory-блокировкой.
+func withLock(ctx context.Context, db *sql.DB, fn func(*sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockID)
+
+	if err := ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func run(ctx context.Context, conn *sql.Conn, script string, record func(*sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Up применяет все ещё не применённые миграции по порядку, каждую в своей
+// транзакции, и возвращает список применённых.
+func Up(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	err = withLock(ctx, db, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := verify(migrations, versions); err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if _, ok := versions[m.Version]; ok {
+				continue
+			}
+
+			err := run(ctx, conn, m.Up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx,
+					"INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)",
+					m.Version, m.Name, m.Checksum)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("миграция %04d_%s: %w", m.Version, m.Name, err)
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down откатывает последнюю применённую миграцию. Если применённых миграций
+// нет, возвращается нулевая Migration и nil; базовая схема не откатывается
+// (ErrIrreversible).
+func Down(ctx context.Context, db *sql.DB) (Migration, error) {
+	migrations, err := Load()
+	if err != nil {
+		return Migration{}, err
+	}
+
+	var rolledBack Migration
+	err = withLock(ctx, db, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := verify(migrations, versions); err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if _, ok := versions[m.Version]; !ok {
+				continue
+			}
+			if m.Version == baseline {
+				return fmt.Errorf("%w: %04d_%s", ErrIrreversible, m.Version, m.Name)
+			}
+
+			err := run(ctx, conn, m.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("откат миграции %04d_%s: %w", m.Version, m.Name, err)
+			}
+			rolledBack = m
+			return nil
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// List возвращает все известные миграции с отметкой о применении.
+func List(ctx context.Context, db *sql.DB) ([]Status, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	err = withLock(ctx, db, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := verify(migrations, versions); err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			a, ok := versions[m.Version]
+			statuses = append(statuses, Status{Migration: m, Applied: ok, AppliedAt: a.appliedAt})
+		}
+		return nil
+	})
+	return statuses, err
+}
@@ -0,0 +1,4 @@
+-- Базовая схема не откатывается: она перенимает таблицы магазина, созданные
+-- до появления миграций, и откат удалил бы все данные. migrations.Down
+-- останавливается на этой версии и сюда не доходит.
+SELECT 1;
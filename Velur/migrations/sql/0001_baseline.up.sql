@@ -0,0 +1,129 @@
+-- Базовая схема магазина. Скрипт повторяет то, что раньше делали функции
+-- createXTable в main.go, поэтому безопасно применяется и к пустой базе,
+-- и к базе, созданной предыдущими версиями приложения.
+
+CREATE TABLE IF NOT EXISTS clothes (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	description TEXT,
+	image_url VARCHAR(500),
+	price DECIMAL(10, 2) NOT NULL,
+	material VARCHAR(100),
+	type VARCHAR(100),
+	season VARCHAR(50)
+);
+
+CREATE TABLE IF NOT EXISTS product_variants (
+	id SERIAL PRIMARY KEY,
+	clothing_id INTEGER NOT NULL REFERENCES clothes(id) ON DELETE CASCADE,
+	size VARCHAR(10) NOT NULL,
+	color VARCHAR(50) NOT NULL,
+	sku VARCHAR(64) UNIQUE NOT NULL,
+	stock INTEGER NOT NULL DEFAULT 0 CHECK (stock >= 0),
+	price DECIMAL(10, 2),
+	UNIQUE (clothing_id, size, color)
+);
+
+-- Раньше размер, цвет и остаток хранились в самой строке clothes.
+DO $$ BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'clothes' AND column_name = 'size') THEN
+		ALTER TABLE clothes ADD COLUMN IF NOT EXISTS stock INTEGER NOT NULL DEFAULT 0;
+		INSERT INTO product_variants (clothing_id, size, color, sku, stock)
+		SELECT id, COALESCE(size, ''), COALESCE(color, ''), 'VL-' || id, stock FROM clothes;
+		ALTER TABLE clothes DROP COLUMN size, DROP COLUMN color, DROP COLUMN stock;
+	END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS accessories (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	description TEXT,
+	image_url VARCHAR(500),
+	price DECIMAL(10, 2) NOT NULL,
+	type VARCHAR(100),
+	color VARCHAR(50),
+	material VARCHAR(100),
+	target VARCHAR(100),
+	stock INTEGER NOT NULL DEFAULT 0 CHECK (stock >= 0)
+);
+
+ALTER TABLE accessories ADD COLUMN IF NOT EXISTS stock INTEGER NOT NULL DEFAULT 0 CHECK (stock >= 0);
+
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	username VARCHAR(100) UNIQUE NOT NULL,
+	password VARCHAR(255) NOT NULL,
+	email VARCHAR(255) NOT NULL,
+	role VARCHAR(50) DEFAULT 'user'
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+	id SERIAL PRIMARY KEY,
+	first_name VARCHAR(100) NOT NULL,
+	last_name VARCHAR(100) NOT NULL,
+	middle_name VARCHAR(100),
+	phone VARCHAR(20) NOT NULL,
+	region VARCHAR(100) NOT NULL,
+	city VARCHAR(100) NOT NULL,
+	street VARCHAR(100) NOT NULL,
+	house VARCHAR(20) NOT NULL,
+	apartment VARCHAR(20),
+	total DECIMAL(10, 2) NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- В старых базах товар хранился прямо в заказе; теперь он в order_items.
+DO $$ BEGIN
+	ALTER TABLE orders ALTER COLUMN product_name DROP NOT NULL;
+	ALTER TABLE orders ALTER COLUMN product_category DROP NOT NULL;
+	ALTER TABLE orders ALTER COLUMN quantity DROP NOT NULL;
+EXCEPTION WHEN undefined_column THEN NULL;
+END $$;
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS total DECIMAL(10, 2) NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS order_items (
+	id SERIAL PRIMARY KEY,
+	order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+	category VARCHAR(20) NOT NULL,
+	product_id INTEGER NOT NULL,
+	variant_id INTEGER,
+	product_name VARCHAR(255) NOT NULL,
+	image_url VARCHAR(500),
+	size VARCHAR(10),
+	color VARCHAR(50),
+	sku VARCHAR(64),
+	unit_price DECIMAL(10, 2) NOT NULL,
+	quantity INTEGER NOT NULL CHECK (quantity > 0)
+);
+
+ALTER TABLE order_items
+	ADD COLUMN IF NOT EXISTS product_name VARCHAR(255) NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS image_url VARCHAR(500),
+	ADD COLUMN IF NOT EXISTS unit_price DECIMAL(10, 2) NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS variant_id INTEGER,
+	ADD COLUMN IF NOT EXISTS size VARCHAR(10),
+	ADD COLUMN IF NOT EXISTS color VARCHAR(50),
+	ADD COLUMN IF NOT EXISTS sku VARCHAR(64);
+
+CREATE TABLE IF NOT EXISTS cart_items (
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	category VARCHAR(20) NOT NULL,
+	product_id INTEGER NOT NULL,
+	variant_id INTEGER NOT NULL DEFAULT 0,
+	quantity INTEGER NOT NULL CHECK (quantity > 0),
+	PRIMARY KEY (user_id, category, product_id, variant_id)
+);
+
+-- Одежда в корзине ссылается на вариант; старые позиции привязываем
+-- к единственному варианту, созданному при переносе размеров.
+DO $$ BEGIN
+	IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'cart_items' AND column_name = 'variant_id') THEN
+		ALTER TABLE cart_items ADD COLUMN variant_id INTEGER NOT NULL DEFAULT 0;
+		UPDATE cart_items c SET variant_id = v.id
+		FROM product_variants v
+		WHERE c.category = 'clothing' AND v.clothing_id = c.product_id;
+		ALTER TABLE cart_items DROP CONSTRAINT cart_items_pkey;
+		ALTER TABLE cart_items ADD PRIMARY KEY (user_id, category, product_id, variant_id);
+	END IF;
+END $$;
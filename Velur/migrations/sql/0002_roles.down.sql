@@ -0,0 +1,7 @@
+ALTER TABLE users
+	DROP CONSTRAINT users_role_fkey,
+	ALTER COLUMN role DROP NOT NULL;
+
+DROP TABLE role_permissions;
+DROP TABLE permissions;
+DROP TABLE roles;
@@ -0,0 +1,45 @@
+-- Роли сотрудников и их права. Раньше администратором считался любой
+-- пользователь с ролью admin, а остальные проверки отсутствовали.
+
+CREATE TABLE roles (
+	name VARCHAR(50) PRIMARY KEY,
+	description VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE permissions (
+	name VARCHAR(50) PRIMARY KEY,
+	description VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE role_permissions (
+	role VARCHAR(50) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+	permission VARCHAR(50) NOT NULL REFERENCES permissions(name) ON DELETE CASCADE,
+	PRIMARY KEY (role, permission)
+);
+
+INSERT INTO roles (name, description) VALUES
+	('user', 'Покупатель'),
+	('admin', 'Администратор'),
+	('catalog_manager', 'Менеджер каталога'),
+	('order_manager', 'Менеджер заказов'),
+	('support', 'Служба поддержки');
+
+INSERT INTO permissions (name, description) VALUES
+	('catalog.manage', 'Добавление, правка и удаление товаров, вариантов и остатков'),
+	('orders.view', 'Просмотр заказов'),
+	('orders.manage', 'Изменение заказов');
+
+INSERT INTO role_permissions (role, permission) VALUES
+	('admin', 'catalog.manage'),
+	('admin', 'orders.view'),
+	('admin', 'orders.manage'),
+	('catalog_manager', 'catalog.manage'),
+	('order_manager', 'orders.view'),
+	('order_manager', 'orders.manage'),
+	('support', 'orders.view');
+
+UPDATE users SET role = 'user' WHERE role IS NULL OR role NOT IN (SELECT name FROM roles);
+
+ALTER TABLE users
+	ALTER COLUMN role SET NOT NULL,
+	ADD CONSTRAINT users_role_fkey FOREIGN KEY (role) REFERENCES roles(name);
@@ -0,0 +1,9 @@
+ALTER TABLE clothes
+	DROP COLUMN image_thumb_url,
+	DROP COLUMN image_card_url,
+	DROP COLUMN image_zoom_url;
+
+ALTER TABLE accessories
+	DROP COLUMN image_thumb_url,
+	DROP COLUMN image_card_url,
+	DROP COLUMN image_zoom_url;
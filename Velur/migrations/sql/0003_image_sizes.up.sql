@@ -0,0 +1,12 @@
+-- Уменьшенные копии изображения товара для srcset. Пустая строка — копии
+-- ещё не построены; их строит команда velur images rebuild.
+
+ALTER TABLE clothes
+	ADD COLUMN image_thumb_url VARCHAR(500) NOT NULL DEFAULT '',
+	ADD COLUMN image_card_url VARCHAR(500) NOT NULL DEFAULT '',
+	ADD COLUMN image_zoom_url VARCHAR(500) NOT NULL DEFAULT '';
+
+ALTER TABLE accessories
+	ADD COLUMN image_thumb_url VARCHAR(500) NOT NULL DEFAULT '',
+	ADD COLUMN image_card_url VARCHAR(500) NOT NULL DEFAULT '',
+	ADD COLUMN image_zoom_url VARCHAR(500) NOT NULL DEFAULT '';
@@ -0,0 +1,29 @@
+-- Несколько фотографий у товара. Колонки image_* в clothes и accessories
+-- остаются обложкой карточки: приложение переписывает их по первой
+-- фотографии при каждом изменении галереи.
+
+CREATE TABLE product_images (
+	id SERIAL PRIMARY KEY,
+	clothing_id INTEGER REFERENCES clothes(id) ON DELETE CASCADE,
+	accessory_id INTEGER REFERENCES accessories(id) ON DELETE CASCADE,
+	url VARCHAR(500) NOT NULL,
+	thumb_url VARCHAR(500) NOT NULL DEFAULT '',
+	card_url VARCHAR(500) NOT NULL DEFAULT '',
+	zoom_url VARCHAR(500) NOT NULL DEFAULT '',
+	alt VARCHAR(255) NOT NULL DEFAULT '',
+	sort_order INTEGER NOT NULL DEFAULT 0,
+	CHECK ((clothing_id IS NULL) <> (accessory_id IS NULL))
+);
+
+CREATE INDEX product_images_clothing_idx ON product_images (clothing_id, sort_order);
+CREATE INDEX product_images_accessory_idx ON product_images (accessory_id, sort_order);
+
+INSERT INTO product_images (clothing_id, url, thumb_url, card_url, zoom_url, alt)
+SELECT id, image_url, image_thumb_url, image_card_url, image_zoom_url, name
+FROM clothes
+WHERE image_url IS NOT NULL AND image_url <> '';
+
+INSERT INTO product_images (accessory_id, url, thumb_url, card_url, zoom_url, alt)
+SELECT id, image_url, image_thumb_url, image_card_url, image_zoom_url, name
+FROM accessories
+WHERE image_url IS NOT NULL AND image_url <> '';
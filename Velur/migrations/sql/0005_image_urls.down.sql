@@ -0,0 +1,26 @@
+CREATE FUNCTION pg_temp.relative_url(url TEXT) RETURNS TEXT AS $$
+	SELECT CASE
+		WHEN url LIKE '/uploads/%' OR url LIKE '/assets/%' THEN substr(url, 2)
+		ELSE url
+	END
+$$ LANGUAGE SQL IMMUTABLE;
+
+UPDATE clothes SET
+	image_url = pg_temp.relative_url(image_url),
+	image_thumb_url = pg_temp.relative_url(image_thumb_url),
+	image_card_url = pg_temp.relative_url(image_card_url),
+	image_zoom_url = pg_temp.relative_url(image_zoom_url);
+
+UPDATE accessories SET
+	image_url = pg_temp.relative_url(image_url),
+	image_thumb_url = pg_temp.relative_url(image_thumb_url),
+	image_card_url = pg_temp.relative_url(image_card_url),
+	image_zoom_url = pg_temp.relative_url(image_zoom_url);
+
+UPDATE product_images SET
+	url = pg_temp.relative_url(url),
+	thumb_url = pg_temp.relative_url(thumb_url),
+	card_url = pg_temp.relative_url(card_url),
+	zoom_url = pg_temp.relative_url(zoom_url);
+
+UPDATE order_items SET image_url = pg_temp.relative_url(image_url);
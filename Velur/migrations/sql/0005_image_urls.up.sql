@@ -0,0 +1,31 @@
+-- Ссылки на изображения теперь строит хранилище, и в базе лежат готовые
+-- URL: "/uploads/…" для локального диска или полный адрес объекта в S3.
+-- Старые относительные пути ("uploads/…", "assets/product/…") получают
+-- ведущий слеш.
+
+CREATE FUNCTION pg_temp.rooted_url(url TEXT) RETURNS TEXT AS $$
+	SELECT CASE
+		WHEN url = '' OR url LIKE '/%' OR url ~ '^[a-z]+://' THEN url
+		ELSE '/' || replace(url, '\', '/')
+	END
+$$ LANGUAGE SQL IMMUTABLE;
+
+UPDATE clothes SET
+	image_url = pg_temp.rooted_url(image_url),
+	image_thumb_url = pg_temp.rooted_url(image_thumb_url),
+	image_card_url = pg_temp.rooted_url(image_card_url),
+	image_zoom_url = pg_temp.rooted_url(image_zoom_url);
+
+UPDATE accessories SET
+	image_url = pg_temp.rooted_url(image_url),
+	image_thumb_url = pg_temp.rooted_url(image_thumb_url),
+	image_card_url = pg_temp.rooted_url(image_card_url),
+	image_zoom_url = pg_temp.rooted_url(image_zoom_url);
+
+UPDATE product_images SET
+	url = pg_temp.rooted_url(url),
+	thumb_url = pg_temp.rooted_url(thumb_url),
+	card_url = pg_temp.rooted_url(card_url),
+	zoom_url = pg_temp.rooted_url(zoom_url);
+
+UPDATE order_items SET image_url = pg_temp.rooted_url(image_url);
@@ -0,0 +1,5 @@
+DROP TABLE order_status_history;
+
+ALTER TABLE orders
+	DROP COLUMN status,
+	DROP COLUMN status_changed_at;
@@ -0,0 +1,26 @@
+-- Статус заказа и история его изменений. Время каждого перехода хранится в
+-- истории, в самом заказе — только время последнего.
+
+ALTER TABLE orders
+	ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'new'
+		CHECK (status IN ('new', 'confirmed', 'packed', 'shipped', 'delivered', 'cancelled', 'returned')),
+	ADD COLUMN status_changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+UPDATE orders SET status_changed_at = created_at WHERE created_at IS NOT NULL;
+
+CREATE INDEX orders_status_created_idx ON orders (status, created_at);
+
+CREATE TABLE order_status_history (
+	id SERIAL PRIMARY KEY,
+	order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+	from_status VARCHAR(20),
+	to_status VARCHAR(20) NOT NULL,
+	changed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	comment TEXT NOT NULL DEFAULT '',
+	changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX order_status_history_order_idx ON order_status_history (order_id, changed_at);
+
+INSERT INTO order_status_history (order_id, to_status, changed_at)
+SELECT id, 'new', status_changed_at FROM orders;
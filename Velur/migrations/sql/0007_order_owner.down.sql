@@ -0,0 +1,3 @@
+ALTER TABLE orders
+	DROP COLUMN user_id,
+	DROP COLUMN public_id;
@@ -0,0 +1,15 @@
+-- Заказ привязывается к покупателю, если тот вошёл в аккаунт, и получает
+-- неугадываемый номер для страницы отслеживания. Старым заказам номер
+-- выдаётся здесь же (gen_random_uuid есть в PostgreSQL с 13-й версии);
+-- владельца у них восстановить нельзя.
+
+ALTER TABLE orders
+	ADD COLUMN user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	ADD COLUMN public_id VARCHAR(32);
+
+UPDATE orders SET public_id = replace(gen_random_uuid()::text, '-', '');
+
+ALTER TABLE orders ALTER COLUMN public_id SET NOT NULL;
+
+CREATE UNIQUE INDEX orders_public_id_idx ON orders (public_id);
+CREATE INDEX orders_user_created_idx ON orders (user_id, created_at) WHERE user_id IS NOT NULL;
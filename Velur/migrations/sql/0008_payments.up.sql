@@ -0,0 +1,27 @@
+-- Онлайн-оплата заказов. У заказа может быть несколько платежей, текущий —
+-- последний. Уведомления шлюзов запоминаются по их ID, чтобы повторная
+-- доставка того же уведомления ничего не меняла.
+
+CREATE TABLE payments (
+	id SERIAL PRIMARY KEY,
+	order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+	provider VARCHAR(30) NOT NULL,
+	external_id VARCHAR(100) NOT NULL,
+	amount DECIMAL(10, 2) NOT NULL,
+	state VARCHAR(20) NOT NULL DEFAULT 'pending'
+		CHECK (state IN ('pending', 'paid', 'failed', 'refunded')),
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (provider, external_id)
+);
+
+CREATE INDEX payments_order_idx ON payments (order_id);
+
+CREATE TABLE payment_events (
+	provider VARCHAR(30) NOT NULL,
+	event_id VARCHAR(100) NOT NULL,
+	payment_id INTEGER NOT NULL REFERENCES payments(id) ON DELETE CASCADE,
+	state VARCHAR(20) NOT NULL,
+	received_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (provider, event_id)
+);
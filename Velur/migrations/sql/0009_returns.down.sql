@@ -0,0 +1,4 @@
+DROP TABLE return_items;
+DROP TABLE returns;
+
+ALTER TABLE payments DROP COLUMN refunded;
@@ -0,0 +1,30 @@
+-- Возвраты товаров. Заявка ссылается на позиции заказа, поэтому название и
+-- цена возвращаемого товара берутся из order_items. Платёж запоминает,
+-- сколько из него уже возвращено: возвраты бывают частичными.
+
+ALTER TABLE payments ADD COLUMN refunded DECIMAL(10, 2) NOT NULL DEFAULT 0;
+
+UPDATE payments SET refunded = amount WHERE state = 'refunded';
+
+CREATE TABLE returns (
+	id SERIAL PRIMARY KEY,
+	order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+	status VARCHAR(20) NOT NULL DEFAULT 'requested'
+		CHECK (status IN ('requested', 'approved', 'rejected', 'completed')),
+	reason VARCHAR(30) NOT NULL,
+	comment TEXT NOT NULL DEFAULT '',
+	refund DECIMAL(10, 2) NOT NULL DEFAULT 0,
+	restocked BOOLEAN NOT NULL DEFAULT false,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX returns_order_idx ON returns (order_id);
+CREATE INDEX returns_status_created_idx ON returns (status, created_at);
+
+CREATE TABLE return_items (
+	return_id INTEGER NOT NULL REFERENCES returns(id) ON DELETE CASCADE,
+	order_item_id INTEGER NOT NULL REFERENCES order_items(id) ON DELETE CASCADE,
+	quantity INTEGER NOT NULL CHECK (quantity > 0),
+	PRIMARY KEY (return_id, order_item_id)
+);
@@ -0,0 +1,3 @@
+DROP TABLE email_outbox;
+
+ALTER TABLE orders DROP COLUMN email;
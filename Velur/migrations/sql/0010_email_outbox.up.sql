@@ -0,0 +1,24 @@
+-- Письма покупателям и сотрудникам. Письмо сохраняется в очередь уже
+-- отрисованным и отправляется фоновым отправителем; неудачные попытки
+-- повторяются с растущей паузой. Адрес покупателя для писем о заказе
+-- хранится в заказе: гость указывает его при оформлении.
+
+ALTER TABLE orders ADD COLUMN email VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE TABLE email_outbox (
+	id SERIAL PRIMARY KEY,
+	kind VARCHAR(30) NOT NULL,
+	recipient VARCHAR(255) NOT NULL,
+	subject TEXT NOT NULL,
+	text_body TEXT NOT NULL,
+	html_body TEXT NOT NULL DEFAULT '',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	sent_at TIMESTAMP,
+	failed_at TIMESTAMP
+);
+
+CREATE INDEX email_outbox_due_idx ON email_outbox (next_attempt_at)
+	WHERE sent_at IS NULL AND failed_at IS NULL;
@@ -0,0 +1,30 @@
+DELETE FROM permissions WHERE name = 'jobs.manage';
+
+CREATE TABLE email_outbox (
+	id SERIAL PRIMARY KEY,
+	kind VARCHAR(30) NOT NULL,
+	recipient VARCHAR(255) NOT NULL,
+	subject TEXT NOT NULL,
+	text_body TEXT NOT NULL,
+	html_body TEXT NOT NULL DEFAULT '',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	sent_at TIMESTAMP,
+	failed_at TIMESTAMP
+);
+
+CREATE INDEX email_outbox_due_idx ON email_outbox (next_attempt_at)
+	WHERE sent_at IS NULL AND failed_at IS NULL;
+
+-- Неотправленные письма возвращаются в очередь 0010, иначе откат их потеряет.
+INSERT INTO email_outbox (kind, recipient, subject, text_body, html_body, attempts, last_error,
+	created_at, next_attempt_at, failed_at)
+SELECT COALESCE(payload->>'kind', ''), payload->>'to', COALESCE(payload->>'subject', ''),
+	COALESCE(payload->>'text', ''), COALESCE(payload->>'html', ''), attempts, last_error,
+	created_at, run_at, CASE WHEN status = 'dead' THEN updated_at END
+FROM jobs
+WHERE kind = 'send_mail' AND status <> 'done' AND payload->>'to' IS NOT NULL;
+
+DROP TABLE jobs;
@@ -0,0 +1,41 @@
+-- Очередь фоновых заданий. Задание ставится в той же транзакции, что и
+-- изменение, которое его вызвало, а выполняют его исполнители всех
+-- экземпляров магазина: SELECT ... FOR UPDATE SKIP LOCKED выдаёт каждое
+-- задание одному из них. Очередь писем из 0010 становится заданиями
+-- send_mail.
+
+CREATE TABLE jobs (
+	id SERIAL PRIMARY KEY,
+	kind VARCHAR(50) NOT NULL,
+	payload JSONB NOT NULL DEFAULT '{}',
+	status VARCHAR(20) NOT NULL DEFAULT 'pending'
+		CHECK (status IN ('pending', 'running', 'done', 'dead')),
+	unique_key VARCHAR(100),
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL DEFAULT 8,
+	last_error TEXT NOT NULL DEFAULT '',
+	run_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX jobs_due_idx ON jobs (run_at) WHERE status IN ('pending', 'running');
+CREATE INDEX jobs_status_idx ON jobs (status, updated_at);
+CREATE UNIQUE INDEX jobs_unique_key_idx ON jobs (kind, unique_key)
+	WHERE unique_key IS NOT NULL AND status IN ('pending', 'running');
+
+INSERT INTO jobs (kind, payload, status, attempts, last_error, run_at, created_at, updated_at)
+SELECT 'send_mail',
+	json_build_object('kind', kind, 'to', recipient, 'subject', subject, 'text', text_body, 'html', html_body),
+	CASE WHEN failed_at IS NULL THEN 'pending' ELSE 'dead' END,
+	attempts, last_error, next_attempt_at, created_at, COALESCE(failed_at, created_at)
+FROM email_outbox
+WHERE sent_at IS NULL;
+
+DROP TABLE email_outbox;
+
+INSERT INTO permissions (name, description) VALUES
+	('jobs.manage', 'Просмотр и перезапуск фоновых заданий');
+
+INSERT INTO role_permissions (role, permission) VALUES
+	('admin', 'jobs.manage');
@@ -0,0 +1,16 @@
+-- Восстановление пароля. В базе хранится только SHA-256 токена из письма,
+-- поэтому утечка таблицы не даёт сменить чужой пароль. session_version
+-- растёт при каждой смене пароля; сессии, выданные до неё, перестают
+-- действовать.
+
+ALTER TABLE users ADD COLUMN session_version INTEGER NOT NULL DEFAULT 1;
+
+CREATE TABLE password_resets (
+	token_hash CHAR(64) PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX password_resets_user_idx ON password_resets (user_id);
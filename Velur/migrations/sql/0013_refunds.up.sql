@@ -0,0 +1,17 @@
+-- Возвраты денег через платёжный шлюз. Возврат резервируется здесь до
+-- обращения к шлюзу, под блокировкой платежа: одновременные запросы с одним
+-- ключом получают один резерв, а шлюз получает тот же ключ идемпотентности
+-- и не возвращает деньги дважды. settled — шлюз подтвердил возврат, и сумма
+-- учтена в payments.refunded.
+
+CREATE TABLE refunds (
+	id SERIAL PRIMARY KEY,
+	payment_id INTEGER NOT NULL REFERENCES payments(id) ON DELETE CASCADE,
+	idempotency_key VARCHAR(64) NOT NULL UNIQUE,
+	amount DECIMAL(10, 2) NOT NULL CHECK (amount > 0),
+	settled BOOLEAN NOT NULL DEFAULT false,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	settled_at TIMESTAMP
+);
+
+CREATE INDEX refunds_unsettled_idx ON refunds (payment_id) WHERE NOT settled;
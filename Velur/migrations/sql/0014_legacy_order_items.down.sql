@@ -0,0 +1,3 @@
+-- Перенесённые позиции остаются: после обновления по ним могли оформить
+-- возвраты, а старые колонки заказа 0001 не удаляет.
+SELECT 1;
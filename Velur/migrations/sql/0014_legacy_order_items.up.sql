@@ -0,0 +1,31 @@
+-- В базах, перенятых из прежней версии магазина, товар хранился прямо в
+-- заказе: название, категория и количество, без цены. 0001 оставляет эти
+-- колонки, но позиций в order_items у таких заказов нет. Каждый старый
+-- заказ получает одну позицию. Цену старый заказ не сохранял, поэтому
+-- берётся цена товара с тем же названием на момент обновления; у
+-- удалённого товара она неизвестна и остаётся нулевой.
+
+DO $$ BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'orders' AND column_name = 'product_name') THEN
+		INSERT INTO order_items (order_id, category, product_id, product_name, image_url, unit_price, quantity)
+		SELECT o.id,
+			CASE WHEN o.product_category = 'Аксессуары' THEN 'accessory' ELSE 'clothing' END,
+			COALESCE(p.id, 0), o.product_name, p.image_url, COALESCE(p.price, 0), GREATEST(o.quantity, 1)
+		FROM orders o
+		LEFT JOIN LATERAL (
+			SELECT id, price, image_url FROM clothes
+			WHERE o.product_category IS DISTINCT FROM 'Аксессуары' AND name = o.product_name
+			UNION ALL
+			SELECT id, price, image_url FROM accessories
+			WHERE o.product_category = 'Аксессуары' AND name = o.product_name
+			ORDER BY id
+			LIMIT 1
+		) p ON true
+		WHERE o.product_name IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM order_items i WHERE i.order_id = o.id);
+
+		UPDATE orders o SET total = i.total
+		FROM (SELECT order_id, SUM(unit_price * quantity) AS total FROM order_items GROUP BY order_id) i
+		WHERE i.order_id = o.id AND o.total = 0;
+	END IF;
+END $$;
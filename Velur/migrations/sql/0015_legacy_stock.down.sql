@@ -0,0 +1,3 @@
+-- Остатки после обновления уже могли поменяться продажами и правками в
+-- админ-панели, поэтому откат их не трогает.
+SELECT 1;
@@ -0,0 +1,16 @@
+-- Прежние версии магазина остатков не знали и продавали товар без
+-- ограничений, а 0001 переносит такие товары с нулевым остатком, и магазин
+-- перестаёт их продавать. Если база перенята из прежней версии (в заказах
+-- остались её колонки) и новых заказов с тех пор не было, нули — это
+-- отсутствие учёта, а не распроданный товар: такие товары получают по 100
+-- единиц. Фактические остатки после обновления нужно внести в
+-- админ-панели. Перенесённые варианты одежды узнаются по артикулу VL-<id>.
+
+DO $$ BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'orders' AND column_name = 'product_name') THEN
+		IF NOT EXISTS (SELECT 1 FROM orders WHERE product_name IS NULL) THEN
+			UPDATE product_variants SET stock = 100 WHERE stock = 0 AND sku = 'VL-' || clothing_id;
+			UPDATE accessories SET stock = 100 WHERE stock = 0;
+		END IF;
+	END IF;
+END $$;
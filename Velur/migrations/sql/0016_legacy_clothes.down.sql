@@ -0,0 +1,3 @@
+-- Объединённые товары не разделяются обратно: удалённые строки и их
+-- фотографии не восстановить.
+SELECT 1;
@@ -0,0 +1,55 @@
+-- В прежних версиях магазина каждый размер и цвет одежды был отдельной
+-- строкой clothes, и 0001 переносит каждую строку в отдельный товар с
+-- единственным вариантом артикула VL-<id>, поэтому одно платье появляется
+-- в каталоге несколько раз. Такие товары с одинаковыми названием и ценой
+-- становятся вариантами одного — самого старого из них, остальные
+-- удаляются. Совпадающие размер и цвет складываются в один вариант с общим
+-- остатком. Корзины и позиции заказов переходят к объединённому товару.
+
+CREATE TEMP TABLE legacy_clothes ON COMMIT DROP AS
+SELECT c.id, MIN(c.id) OVER (PARTITION BY c.name, c.price) AS keeper
+FROM clothes c
+WHERE EXISTS (SELECT 1 FROM product_variants v WHERE v.clothing_id = c.id AND v.sku = 'VL-' || c.id);
+
+-- target — вариант, в который переходит вариант id: вариант товара keeper
+-- с тем же размером и цветом, если он есть, иначе самый старый из них.
+CREATE TEMP TABLE legacy_variants ON COMMIT DROP AS
+SELECT v.id, l.keeper, v.stock,
+	FIRST_VALUE(v.id) OVER (PARTITION BY l.keeper, v.size, v.color ORDER BY v.clothing_id = l.keeper DESC, v.id) AS target
+FROM product_variants v
+JOIN legacy_clothes l ON l.id = v.clothing_id;
+
+UPDATE product_variants v SET stock = s.stock
+FROM (SELECT target, SUM(stock)::INTEGER AS stock FROM legacy_variants GROUP BY target HAVING COUNT(*) > 1) s
+WHERE v.id = s.target;
+
+UPDATE product_variants v SET clothing_id = l.keeper
+FROM legacy_variants l
+WHERE v.id = l.id AND l.id = l.target AND v.clothing_id <> l.keeper;
+
+UPDATE order_items i SET product_id = l.keeper
+FROM legacy_clothes l
+WHERE i.category = 'clothing' AND i.product_id = l.id AND l.id <> l.keeper;
+
+UPDATE order_items i SET variant_id = l.target
+FROM legacy_variants l
+WHERE i.category = 'clothing' AND i.variant_id = l.id AND l.id <> l.target;
+
+-- Позиции корзины, попавшие в один вариант, складываются.
+CREATE TEMP TABLE legacy_cart ON COMMIT DROP AS
+SELECT c.user_id, l.keeper, l.target, SUM(c.quantity)::INTEGER AS quantity
+FROM cart_items c
+JOIN legacy_variants l ON c.category = 'clothing' AND c.variant_id = l.id
+GROUP BY c.user_id, l.keeper, l.target;
+
+DELETE FROM cart_items c
+USING legacy_variants l
+WHERE c.category = 'clothing' AND c.variant_id = l.id;
+
+INSERT INTO cart_items (user_id, category, product_id, variant_id, quantity)
+SELECT user_id, 'clothing', keeper, target, quantity FROM legacy_cart;
+
+-- Оставшиеся варианты и фотографии объединённых товаров удаляются каскадом.
+DELETE FROM clothes c
+USING legacy_clothes l
+WHERE c.id = l.id AND l.id <> l.keeper;
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"strconv"
+
+	"golangify.com/snippetbox/jobs"
+	"golangify.com/snippetbox/notify"
+	"golangify.com/snippetbox/repository"
+)
+
+// mailPayload — данные задания jobSendMail: письмо, отрисованное при
+// постановке, чтобы повторы отправляли то же самое.
+type mailPayload struct {
+	Kind notify.Kind `json:"kind"`
+	notify.Message
+}
+
+// mailJob отрисовывает письмо вида kind и собирает задание на его отправку.
+// false — адреса нет и писать некому.
+func mailJob(kind notify.Kind, to string, data map[string]interface{}) (jobs.New, bool, error) {
+	if to == "" {
+		return jobs.New{}, false, nil
+	}
+	msg, err := notify.Render(kind, to, data)
+	if err != nil {
+		return jobs.New{}, false, err
+	}
+	job, err := jobs.Make(jobSendMail, mailPayload{Kind: kind, Message: msg})
+	return job, err == nil, err
+}
+
+// enqueueMail ставит письмо в очередь. Ошибки только записываются в
+// журнал: письмо не должно мешать тому, о чём оно сообщает.
+func (srv *server) enqueueMail(ctx context.Context, kind notify.Kind, to string, data map[string]interface{}) {
+	job, ok, err := mailJob(kind, to, data)
+	if err != nil {
+		log.Println("Ошибка при подготовке письма:", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	if err := srv.enqueueJobs(ctx, job); err != nil {
+		log.Println("Ошибка при постановке письма в очередь:", err)
+	}
+}
+
+func (srv *server) sendMailJob(ctx context.Context, payload []byte) error {
+	var p mailPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return jobs.Permanent(err)
+	}
+	if err := srv.mailer.Send(ctx, p.Message); err != nil {
+		return err
+	}
+	log.Printf("Письмо «%s» отправлено на %s", p.Subject, p.To)
+	return nil
+}
+
+// orderPlacedJob сообщает о новом заказе покупателю и сотрудникам.
+// Задание ставится вместе с заказом, а письма отправляются отдельными
+// заданиями, чтобы неудача одного не повторяла другое.
+func (srv *server) orderPlacedJob(ctx context.Context, payload []byte) error {
+	var p repository.OrderPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return jobs.Permanent(err)
+	}
+	order, err := srv.orders.OrderByID(ctx, p.OrderID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return jobs.Permanent(fmt.Errorf("заказ №%d не найден", p.OrderID))
+	}
+	if err != nil {
+		return err
+	}
+
+	var mails []jobs.New
+	for _, m := range []struct {
+		kind notify.Kind
+		to   string
+		url  string
+	}{
+		{notify.OrderConfirmation, order.Customer.Email, srv.cfg.URL("/order/" + order.PublicID)},
+		{notify.NewOrder, srv.cfg.StaffEmail, srv.cfg.URL("/admin/orders/" + strconv.Itoa(order.ID))},
+	} {
+		job, ok, err := mailJob(m.kind, m.to, map[string]interface{}{"Order": order, "URL": m.url})
+		if err != nil {
+			return jobs.Permanent(err)
+		}
+		if ok {
+			mails = append(mails, job)
+		}
+	}
+	return srv.enqueueJobs(ctx, mails...)
+}
+
+// validEmail проверяет адрес, введённый в форму; пустой адрес допустим.
+func validEmail(email string) bool {
+	if email == "" {
+		return true
+	}
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
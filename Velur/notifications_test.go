@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"golangify.com/snippetbox/notify"
+	"golangify.com/snippetbox/roles"
+)
+
+// mailbox запоминает отправленные письма. Первые fail отправок
+// завершаются ошибкой.
+type mailbox struct {
+	mu   sync.Mutex
+	sent []notify.Message
+	fail int
+}
+
+func (m *mailbox) Send(ctx context.Context, msg notify.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail > 0 {
+		m.fail--
+		return errors.New("сервер недоступен")
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// deliver выполняет все задания, время которых наступило, и возвращает
+// письма, отправленные с прошлого вызова.
+func (m *mailbox) deliver(t *testing.T, app *testApp) []notify.Message {
+	t.Helper()
+	for {
+		claimed, err := app.srv.runJobs(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claimed == 0 {
+			break
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sent := m.sent
+	m.sent = nil
+	return sent
+}
+
+func newMailApp(t *testing.T) (*testApp, *mailbox) {
+	t.Helper()
+	box := &mailbox{}
+	app := newTestAppWith(t, func(srv *server) {
+		srv.mailer = box
+		srv.cfg.BaseURL = "https://velur.ru"
+		srv.cfg.StaffEmail = "orders@velur.ru"
+	})
+	return app, box
+}
+
+func TestOrderMail(t *testing.T) {
+	app, box := newMailApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 3)
+	c := app.client(t)
+
+	c.postForm("/cart/add", accessoryItem(accessory, "1"))
+	form := orderForm()
+	form.Set("email", "anna@example.com")
+	assertStatus(t, c.postForm("/order", form), http.StatusOK)
+	order := app.store.Orders()[0]
+	if order.Customer.Email != "anna@example.com" {
+		t.Fatalf("адрес в заказе %q", order.Customer.Email)
+	}
+
+	sent := box.deliver(t, app)
+	if len(sent) != 2 {
+		t.Fatalf("отправлено %d писем, ожидалось 2", len(sent))
+	}
+	confirmation, staff := sent[0], sent[1]
+	if confirmation.To != "anna@example.com" || !strings.Contains(confirmation.Text, "https://velur.ru/order/"+order.PublicID) {
+		t.Fatalf("письмо покупателю: %+v", confirmation)
+	}
+	if staff.To != "orders@velur.ru" || !strings.Contains(staff.Text, "https://velur.ru/admin/orders/") {
+		t.Fatalf("письмо сотрудникам: %+v", staff)
+	}
+
+	admin := app.adminClient(t)
+	admin.postForm(orderPath(order.ID)+"/status", url.Values{"status": {"confirmed"}, "comment": {"Отправим завтра"}})
+	sent = box.deliver(t, app)
+	if len(sent) != 1 || sent[0].Subject != fmt.Sprintf("Заказ №%d: Подтверждён", order.ID) || !strings.Contains(sent[0].Text, "Отправим завтра") {
+		t.Fatalf("письмо о смене статуса: %+v", sent)
+	}
+
+	// Без адреса покупателю не пишут, сотрудникам — по-прежнему.
+	c.postForm("/cart/add", accessoryItem(accessory, "1"))
+	assertStatus(t, c.postForm("/order", orderForm()), http.StatusOK)
+	if sent = box.deliver(t, app); len(sent) != 1 || sent[0].To != "orders@velur.ru" {
+		t.Fatalf("письма по заказу без адреса: %+v", sent)
+	}
+
+	c.postForm("/cart/add", accessoryItem(accessory, "1"))
+	form.Set("email", "Анна <anna@example.com>")
+	assertStatus(t, c.postForm("/order", form), http.StatusBadRequest)
+}
+
+func TestCheckoutEmail(t *testing.T) {
+	app, box := newMailApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 3)
+	app.createUser(t, "anna", "secret", roles.Customer)
+	c := app.client(t)
+	c.login("anna", "secret")
+	c.postForm("/cart/add", accessoryItem(accessory, "1"))
+	assertContains(t, c.get("/checkout").body, `value="anna@example.com"`)
+
+	guest := app.client(t)
+	form := url.Values{"username": {"maria"}, "password": {"secret"}, "email": {"maria@example.com"}}
+	assertRedirect(t, guest.postForm("/registration", form), "/")
+	sent := box.deliver(t, app)
+	if len(sent) != 1 || sent[0].To != "maria@example.com" || sent[0].Subject != "Добро пожаловать в Velur" {
+		t.Fatalf("приветственное письмо: %+v", sent)
+	}
+}
@@ -0,0 +1,45 @@
+package notify
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File складывает письма в каталог Dir файлами .eml, которые открываются
+// почтовым клиентом. Для разработки.
+type File struct {
+	Dir  string
+	From string
+}
+
+func (f *File) Send(ctx context.Context, msg Message) error {
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return err
+	}
+	now := time.Now()
+	file, err := os.CreateTemp(f.Dir, now.Format("20060102-150405")+"-*.eml")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(Compose(f.From, msg, now)); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	log.Printf("Письмо «%s» для %s сохранено в %s", msg.Subject, msg.To, filepath.Base(file.Name()))
+	return nil
+}
+
+// Log только записывает письмо в журнал. Отправитель по умолчанию, чтобы
+// магазин запускался без настройки почты.
+type Log struct{}
+
+func (Log) Send(ctx context.Context, msg Message) error {
+	log.Printf("Письмо «%s» для %s:\n%s", msg.Subject, msg.To, msg.Text)
+	return nil
+}
@@ -0,0 +1,93 @@
+// Package notify готовит и отправляет письма покупателям и сотрудникам.
+//
+// Каждое письмо собирается из пары встроенных шаблонов templates/<вид>.txt и
+// templates/<вид>.html; тема задаётся блоком subject текстового шаблона.
+// Отправляет письма Sender: SMTP-сервер, каталог файлов или журнал.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Kind — вид письма; по нему выбираются шаблоны.
+type Kind string
+
+const (
+	// OrderConfirmation — покупателю после оформления заказа.
+	OrderConfirmation Kind = "order_confirmation"
+	// NewOrder — сотрудникам о новом заказе.
+	NewOrder Kind = "new_order"
+	// StatusChanged — покупателю о смене статуса заказа.
+	StatusChanged Kind = "status_changed"
+	// PasswordReset — ссылка для смены забытого пароля.
+	PasswordReset Kind = "password_reset"
+	// Welcome — после регистрации.
+	Welcome Kind = "welcome"
+)
+
+var Kinds = []Kind{OrderConfirmation, NewOrder, StatusChanged, PasswordReset, Welcome}
+
+// Message — готовое письмо одному получателю. HTML может быть пустым, тогда
+// письмо уходит только текстом.
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+//go:embed templates/*.txt templates/*.html
+var files embed.FS
+
+type templates struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+var parsed = parseTemplates()
+
+func parseTemplates() map[Kind]templates {
+	parsed := make(map[Kind]templates, len(Kinds))
+	for _, kind := range Kinds {
+		parsed[kind] = templates{
+			text: texttemplate.Must(texttemplate.ParseFS(files, "templates/"+string(kind)+".txt")),
+			html: htmltemplate.Must(htmltemplate.ParseFS(files, "templates/"+string(kind)+".html")),
+		}
+	}
+	return parsed
+}
+
+// Render собирает письмо вида kind для получателя to из данных data.
+func Render(kind Kind, to string, data any) (Message, error) {
+	t, ok := parsed[kind]
+	if !ok {
+		return Message{}, fmt.Errorf("неизвестный вид письма %q", kind)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := t.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, fmt.Errorf("тема письма %s: %w", kind, err)
+	}
+	if err := t.text.Execute(&text, data); err != nil {
+		return Message{}, fmt.Errorf("текст письма %s: %w", kind, err)
+	}
+	if err := t.html.Execute(&html, data); err != nil {
+		return Message{}, fmt.Errorf("HTML письма %s: %w", kind, err)
+	}
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
@@ -0,0 +1,166 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"mime"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testStatus string
+
+func (s testStatus) Label() string { return string(s) }
+
+type testItem struct {
+	Name, Size, Color, SKU string
+	Price                  float64
+	Quantity               int
+}
+
+func testOrder() any {
+	type customer struct{ FirstName, LastName, Phone string }
+	return struct {
+		ID       int
+		Customer customer
+		Items    []testItem
+		Total    float64
+		Status   testStatus
+	}{
+		ID:       42,
+		Customer: customer{"Анна", "Иванова", "+79990000000"},
+		Items:    []testItem{{Name: "Льняное платье", Size: "M", Price: 4990, Quantity: 2}},
+		Total:    9980,
+		Status:   "Отправлен",
+	}
+}
+
+func TestRender(t *testing.T) {
+	data := map[Kind]any{
+		OrderConfirmation: map[string]any{"Order": testOrder(), "URL": "https://velur.ru/order/abc"},
+		NewOrder:          map[string]any{"Order": testOrder(), "URL": "https://velur.ru/admin/orders/42"},
+		StatusChanged:     map[string]any{"Order": testOrder(), "URL": "https://velur.ru/order/abc", "Comment": "<трек 123>"},
+		PasswordReset:     map[string]any{"Username": "anna", "URL": "https://velur.ru/password/reset/t", "ValidFor": "1 час"},
+		Welcome:           map[string]any{"Username": "anna", "URL": "https://velur.ru/"},
+	}
+	for _, kind := range Kinds {
+		msg, err := Render(kind, "anna@example.com", data[kind])
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if msg.Subject == "" || strings.Contains(msg.Subject, "\n") || msg.Text == "" || msg.HTML == "" {
+			t.Fatalf("%s: %+v", kind, msg)
+		}
+		if strings.Contains(msg.Text, "no value") {
+			t.Fatalf("%s: в тексте пропущены данные:\n%s", kind, msg.Text)
+		}
+	}
+
+	msg, _ := Render(StatusChanged, "anna@example.com", data[StatusChanged])
+	if msg.Subject != "Заказ №42: Отправлен" {
+		t.Fatalf("тема %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "<трек 123>") || !strings.Contains(msg.HTML, "&lt;трек 123&gt;") {
+		t.Fatalf("комментарий в письме:\n%s\n%s", msg.Text, msg.HTML)
+	}
+
+	if _, err := Render("spam", "anna@example.com", nil); err == nil {
+		t.Fatal("неизвестный вид письма отрисован")
+	}
+}
+
+// catchMail поднимает простейший SMTP-сервер и возвращает его порт и канал,
+// в который приходит текст каждого принятого письма.
+func catchMail(t *testing.T) (int, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 catcher")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch command := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(command, "EHLO"):
+				reply("250 catcher")
+			case command == "DATA":
+				reply("354 go ahead")
+				var body strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					body.WriteString(line)
+				}
+				received <- body.String()
+				reply("250 ok")
+			case command == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, received
+}
+
+func TestSMTP(t *testing.T) {
+	port, received := catchMail(t)
+	sender := &SMTP{Host: "127.0.0.1", Port: port, From: "Velur <noreply@velur.ru>"}
+	msg := Message{To: "anna@example.com", Subject: "Заказ №42 оформлен", Text: "Спасибо за заказ", HTML: "<p>Спасибо за заказ</p>"}
+	if err := sender.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(<-received))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if subject != msg.Subject || parsed.Header.Get("To") != msg.To {
+		t.Fatalf("заголовки %v", parsed.Header)
+	}
+	if !strings.HasPrefix(parsed.Header.Get("Content-Type"), "multipart/alternative") {
+		t.Fatalf("тип письма %q", parsed.Header.Get("Content-Type"))
+	}
+
+	if err := (&SMTP{Host: "127.0.0.1", Port: port, From: "Velur"}).Send(context.Background(), msg); err == nil {
+		t.Fatal("письмо с некорректным отправителем отправлено")
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	sender := &File{Dir: dir, From: "noreply@velur.ru"}
+	if err := sender.Send(context.Background(), Message{To: "anna@example.com", Subject: "Привет", Text: "Текст"}); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 || filepath.Ext(files[0].Name()) != ".eml" {
+		t.Fatalf("файлы в каталоге: %v", files)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	if !strings.Contains(string(data), "To: anna@example.com") {
+		t.Fatalf("письмо:\n%s", data)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"golangify.com/snippetbox/repository"
+	"golangify.com/snippetbox/returns"
+)
+
+func (srv *server) checkoutHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := srv.store.Get(r, "session-name")
+	cart, err := srv.loadCart(r.Context(), session)
+	if err != nil {
+		log.Println("Ошибка при загрузке корзины:", err)
+		http.Error(w, "Ошибка при загрузке корзины", http.StatusInternalServerError)
+		return
+	}
+
+	lines, total := srv.cartLines(cart)
+	if len(lines) == 0 {
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	// Адрес для писем о заказе подставляется из учётной записи, покупатель
+	// может его изменить.
+	var email string
+	if userID, ok := sessionUserID(session); ok {
+		if user, err := srv.users.UserByID(r.Context(), userID); err == nil {
+			email = user.Email
+		}
+	}
+
+	err = orderTpl.Execute(w, map[string]interface{}{
+		"Lines":     lines,
+		"Total":     total,
+		"Email":     email,
+		"CSRFToken": srv.csrfToken(w, r),
+	})
+	if err != nil {
+		log.Println("Ошибка при рендеринге шаблона заказа:", err)
+		http.Error(w, "Ошибка при отображении страницы", http.StatusInternalServerError)
+	}
+}
+
+func (srv *server) submitOrderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		customer := repository.Customer{
+			FirstName:  r.FormValue("first_name"),
+			LastName:   r.FormValue("last_name"),
+			MiddleName: r.FormValue("middle_name"),
+			Phone:      r.FormValue("phone"),
+			Region:     r.FormValue("region"),
+			City:       r.FormValue("city"),
+			Street:     r.FormValue("street"),
+			House:      r.FormValue("house"),
+			Apartment:  r.FormValue("apartment"),
+			Email:      strings.TrimSpace(r.FormValue("email")),
+		}
+		if !validEmail(customer.Email) {
+			http.Error(w, "Некорректный адрес электронной почты", http.StatusBadRequest)
+			return
+		}
+
+		session, _ := srv.store.Get(r, "session-name")
+		cart, err := srv.loadCart(r.Context(), session)
+		if err != nil {
+			log.Println("Ошибка при загрузке корзины:", err)
+			http.Error(w, "Ошибка при загрузке корзины", http.StatusInternalServerError)
+			return
+		}
+
+		lines, _ := srv.cartLines(cart)
+		if len(lines) == 0 {
+			http.Error(w, "Корзина пуста", http.StatusBadRequest)
+			return
+		}
+
+		newOrder := repository.NewOrder{Customer: customer, Jobs: []string{jobOrderPlaced}}
+		for _, line := range lines {
+			newOrder.Lines = append(newOrder.Lines, repository.OrderLine{
+				Item:     repository.CartItem(line.cartItem),
+				Quantity: line.Quantity,
+			})
+		}
+		newOrder.UserID, _ = sessionUserID(session)
+
+		order, err := srv.orders.PlaceOrder(r.Context(), newOrder)
+		if errors.Is(err, repository.ErrProductUnavailable) {
+			http.Error(w, "Некоторые товары больше недоступны, обновите корзину", http.StatusConflict)
+			return
+		}
+		var stockErr *repository.OutOfStockError
+		if errors.As(err, &stockErr) {
+			http.Error(w, stockErr.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Println("Ошибка при сохранении заказа в базу данных:", err)
+			http.Error(w, "Ошибка при сохранении заказа", http.StatusInternalServerError)
+			return
+		}
+
+		delete(session.Values, "cart")
+		session.Save(r, w)
+		srv.catalog.Invalidate()
+		srv.wakeWorker()
+
+		log.Printf("Заказ №%d успешно оформлен: %d позиций на сумму %.2f", order.ID, len(order.Items), order.Total)
+
+		// Если оплату начать не удалось, заказ остаётся: его можно оплатить
+		// при получении.
+		if srv.gateway != nil {
+			redirect, err := srv.startPayment(r.Context(), order)
+			if err == nil {
+				http.Redirect(w, r, redirect, http.StatusSeeOther)
+				return
+			}
+			log.Printf("Не удалось начать оплату заказа №%d: %v", order.ID, err)
+		}
+		orderSuccessTpl.Execute(w, order)
+	}
+}
+
+// trackOrderHandler — страница отслеживания заказа. Номер из ссылки
+// неугадываемый, поэтому вход не нужен.
+func (srv *server) trackOrderHandler(w http.ResponseWriter, r *http.Request) {
+	order, err := srv.orders.OrderByPublicID(r.Context(), mux.Vars(r)["public_id"])
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при загрузке заказа:", err)
+		http.Error(w, "Ошибка при загрузке заказа", http.StatusInternalServerError)
+		return
+	}
+
+	orderReturns, err := srv.returns.OrderReturns(r.Context(), order.ID)
+	if err != nil {
+		log.Println("Ошибка при загрузке возвратов:", err)
+		http.Error(w, "Ошибка при загрузке заказа", http.StatusInternalServerError)
+		return
+	}
+	available := returnableQuantities(order, orderReturns)
+
+	err = orderTrackingTpl.Execute(w, map[string]interface{}{
+		"Order":     order,
+		"Returns":   orderReturns,
+		"Available": available,
+		"CanReturn": canReturn(order, available),
+		"Reasons":   returns.Reasons,
+		"CSRFToken": srv.csrfToken(w, r),
+	})
+	if err != nil {
+		log.Println("Ошибка при рендеринге страницы заказа:", err)
+		http.Error(w, "Ошибка при отображении страницы", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,86 @@
+// Package orders описывает жизненный цикл заказа: статусы и допустимые
+// переходы между ними.
+package orders
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Status string
+
+const (
+	New       Status = "new"
+	Confirmed Status = "confirmed"
+	Packed    Status = "packed"
+	Shipped   Status = "shipped"
+	Delivered Status = "delivered"
+	Cancelled Status = "cancelled"
+	Returned  Status = "returned"
+)
+
+// Statuses — все статусы в порядке жизненного цикла.
+var Statuses = []Status{New, Confirmed, Packed, Shipped, Delivered, Cancelled, Returned}
+
+var labels = map[Status]string{
+	New:       "Новый",
+	Confirmed: "Подтверждён",
+	Packed:    "Собран",
+	Shipped:   "Отправлен",
+	Delivered: "Доставлен",
+	Cancelled: "Отменён",
+	Returned:  "Возвращён",
+}
+
+// transitions — допустимые переходы. Отменить можно заказ, который ещё не
+// передан в доставку; вернуть — отправленный или доставленный.
+var transitions = map[Status][]Status{
+	New:       {Confirmed, Cancelled},
+	Confirmed: {Packed, Cancelled},
+	Packed:    {Shipped, Cancelled},
+	Shipped:   {Delivered, Returned},
+	Delivered: {Returned},
+}
+
+var ErrTransition = errors.New("недопустимая смена статуса заказа")
+
+// Known сообщает, есть ли такой статус.
+func Known(s Status) bool {
+	_, ok := labels[s]
+	return ok
+}
+
+// Label — название статуса для покупателя и сотрудников.
+func (s Status) Label() string {
+	if label, ok := labels[s]; ok {
+		return label
+	}
+	return string(s)
+}
+
+// Next возвращает статусы, в которые заказ может перейти из s.
+func (s Status) Next() []Status {
+	return transitions[s]
+}
+
+// Transition проверяет переход из from в to.
+func Transition(from, to Status) error {
+	for _, next := range transitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: из «%s» в «%s»", ErrTransition, from.Label(), to.Label())
+}
+
+// Restocks сообщает, возвращаются ли товары заказа на склад при переходе в
+// статус s: отменённый заказ не покидал склад.
+func Restocks(s Status) bool {
+	return s == Cancelled
+}
+
+// Refunds сообщает, возвращаются ли покупателю деньги за оплаченный заказ
+// при переходе в статус s.
+func Refunds(s Status) bool {
+	return s == Cancelled || s == Returned
+}
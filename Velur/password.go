@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+	"golangify.com/snippetbox/notify"
+	"golangify.com/snippetbox/repository"
+)
+
+const (
+	// passwordResetTTL — срок действия ссылки из письма.
+	passwordResetTTL   = time.Hour
+	passwordResetLabel = "1 час"
+)
+
+// newResetToken возвращает токен для ссылки и его хеш для базы.
+func newResetToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashResetToken(token), nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// resetCandidates находит учётные записи по логину или адресу почты.
+// Записи без почты пропускаются: письмо отправить некуда.
+func (srv *server) resetCandidates(r *http.Request, login string) ([]repository.User, error) {
+	if strings.Contains(login, "@") {
+		return srv.users.UsersByEmail(r.Context(), login)
+	}
+	user, err := srv.users.UserByUsername(r.Context(), login)
+	if errors.Is(err, repository.ErrNotFound) || err == nil && user.Email == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []repository.User{user}, nil
+}
+
+// forgotPasswordHandler отправляет ссылку для смены пароля. Ответ не
+// зависит от того, нашлась ли учётная запись, чтобы по форме нельзя было
+// проверить, зарегистрирован ли адрес.
+func (srv *server) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	data := map[string]interface{}{"ValidFor": passwordResetLabel}
+	if r.Method != http.MethodPost {
+		data["CSRFToken"] = srv.csrfToken(w, r)
+		forgotTpl.Execute(w, data)
+		return
+	}
+
+	login := strings.TrimSpace(r.FormValue("login"))
+	users, err := srv.resetCandidates(r, login)
+	if err != nil {
+		log.Println("Ошибка при поиске пользователя:", err)
+	}
+	for _, user := range users {
+		token, hash, err := newResetToken()
+		if err != nil {
+			log.Println("Ошибка при создании токена:", err)
+			break
+		}
+		if err := srv.resets.CreatePasswordReset(r.Context(), user.ID, hash, passwordResetTTL); err != nil {
+			log.Println("Ошибка при сохранении токена восстановления:", err)
+			continue
+		}
+		srv.enqueueMail(r.Context(), notify.PasswordReset, user.Email, map[string]interface{}{
+			"Username": user.Username,
+			"URL":      srv.cfg.URL("/password/reset/" + token),
+			"ValidFor": passwordResetLabel,
+		})
+	}
+	srv.wakeWorker()
+
+	data["Sent"] = true
+	forgotTpl.Execute(w, data)
+}
+
+// resetPasswordHandler задаёт новый пароль по ссылке из письма. После
+// смены все сессии пользователя завершаются, включая текущую.
+func (srv *server) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	// Токен в адресе страницы не должен уходить сторонним сайтам.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	hash := hashResetToken(mux.Vars(r)["token"])
+
+	user, err := srv.resets.PasswordResetUser(r.Context(), hash)
+	if errors.Is(err, repository.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		resetTpl.Execute(w, map[string]interface{}{"Invalid": true})
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при проверке токена восстановления:", err)
+		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Username":  user.Username,
+		"MinLength": minPasswordLength,
+		"CSRFToken": srv.csrfToken(w, r),
+	}
+	if r.Method != http.MethodPost {
+		resetTpl.Execute(w, data)
+		return
+	}
+
+	password := r.FormValue("password")
+	switch {
+	case len([]rune(password)) < minPasswordLength:
+		data["ErrorMessage"] = fmt.Sprintf("Пароль должен быть не короче %d символов", minPasswordLength)
+	case password != r.FormValue("confirm_password"):
+		data["ErrorMessage"] = "Пароли не совпадают"
+	}
+	if data["ErrorMessage"] != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		resetTpl.Execute(w, data)
+		return
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Ошибка хеширования пароля", http.StatusInternalServerError)
+		return
+	}
+	user, err = srv.resets.ResetPassword(r.Context(), hash, hashed)
+	if errors.Is(err, repository.ErrNotFound) {
+		// Ссылкой успели воспользоваться в другой вкладке.
+		w.WriteHeader(http.StatusNotFound)
+		resetTpl.Execute(w, map[string]interface{}{"Invalid": true})
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при смене пароля:", err)
+		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Пользователь %s сменил пароль по ссылке из письма", user.Username)
+
+	http.Redirect(w, r, "/login?reset=done", http.StatusSeeOther)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golangify.com/snippetbox/repository"
+	"golangify.com/snippetbox/roles"
+)
+
+var resetLink = regexp.MustCompile(`https://velur\.ru(/password/reset/[A-Za-z0-9_-]+)`)
+
+// requestReset запрашивает восстановление пароля для login и возвращает
+// путь ссылки из письма.
+func requestReset(t *testing.T, app *testApp, box *mailbox, login string) string {
+	t.Helper()
+	resp := app.client(t).postForm("/password/forgot", url.Values{"login": {login}})
+	assertStatus(t, resp, http.StatusOK)
+	assertContains(t, resp.body, "Если учётная запись найдена")
+
+	sent := box.deliver(t, app)
+	if len(sent) != 1 {
+		t.Fatalf("отправлено писем: %d, ожидалось 1", len(sent))
+	}
+	m := resetLink.FindStringSubmatch(sent[0].Text)
+	if m == nil {
+		t.Fatalf("в письме нет ссылки:\n%s", sent[0].Text)
+	}
+	return m[1]
+}
+
+func newPassword(password string) url.Values {
+	return url.Values{"password": {password}, "confirm_password": {password}}
+}
+
+func TestPasswordReset(t *testing.T) {
+	app, box := newMailApp(t)
+	app.createUser(t, "anna", "old-secret", roles.Customer)
+
+	for _, login := range []string{"anna", "ANNA@example.com"} {
+		t.Run(login, func(t *testing.T) {
+			link := requestReset(t, app, box, login)
+
+			c := app.client(t)
+			assertContains(t, c.get(link).body, "Учётная запись anna")
+			assertStatus(t, c.postForm(link, url.Values{
+				"password":         {"new-secret-1"},
+				"confirm_password": {"new-secret-2"},
+			}), http.StatusBadRequest)
+			assertStatus(t, c.postForm(link, newPassword("short")), http.StatusBadRequest)
+
+			assertRedirect(t, c.postForm(link, newPassword("new-secret-"+login)), "/login?reset=done")
+			c.login("anna", "new-secret-"+login)
+
+			// Ссылка одноразовая.
+			resp := c.postForm(link, newPassword("another-secret"))
+			assertStatus(t, resp, http.StatusNotFound)
+			assertContains(t, resp.body, "Ссылка недействительна")
+		})
+	}
+}
+
+func TestPasswordResetUnknownLogin(t *testing.T) {
+	app, box := newMailApp(t)
+
+	for _, login := range []string{"nobody", "nobody@example.com"} {
+		resp := app.client(t).postForm("/password/forgot", url.Values{"login": {login}})
+		assertStatus(t, resp, http.StatusOK)
+		assertContains(t, resp.body, "Если учётная запись найдена")
+	}
+	if sent := box.deliver(t, app); len(sent) != 0 {
+		t.Fatalf("отправлено писем: %d", len(sent))
+	}
+}
+
+func TestPasswordResetExpired(t *testing.T) {
+	app, _ := newMailApp(t)
+	app.createUser(t, "anna", "old-secret", roles.Customer)
+	user, err := app.store.UserByUsername(context.Background(), "anna")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, hash, err := newResetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.store.CreatePasswordReset(context.Background(), user.ID, hash, -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	c := app.client(t)
+	assertStatus(t, c.get("/password/reset/"+token), http.StatusNotFound)
+	assertStatus(t, c.get("/password/reset/"+strings.Repeat("a", 43)), http.StatusNotFound)
+
+	if err := app.srv.cleanupJob(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := app.store.DeletePasswordResets(context.Background()); n != 0 {
+		t.Fatalf("после очистки осталось токенов: %d", n)
+	}
+}
+
+func TestPasswordResetEndsSessions(t *testing.T) {
+	app, box := newMailApp(t)
+	app.createUser(t, "anna", "old-secret", roles.Customer)
+	app.createUser(t, "admin", "secret", "admin")
+
+	shopper := app.client(t)
+	shopper.login("anna", "old-secret")
+	assertStatus(t, shopper.get("/account/orders"), http.StatusOK)
+	admin := app.client(t)
+	admin.login("admin", "secret")
+	assertStatus(t, admin.get("/admin/orders"), http.StatusOK)
+
+	link := requestReset(t, app, box, "anna")
+	assertRedirect(t, app.client(t).postForm(link, newPassword("new-secret")), "/login?reset=done")
+
+	assertRedirect(t, shopper.get("/account/orders"), "/login")
+	// Сессии других пользователей не затронуты.
+	assertStatus(t, admin.get("/admin/orders"), http.StatusOK)
+
+	// Смена пароля оператором тоже завершает сессии.
+	hash, err := bcrypt.GenerateFromPassword([]byte("operator-secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.store.SetPassword(context.Background(), "admin", hash); err != nil {
+		t.Fatal(err)
+	}
+	assertStatus(t, admin.get("/admin/orders"), http.StatusForbidden)
+}
+
+// countingUsers считает загрузки пользователя по id.
+type countingUsers struct {
+	repository.UserRepository
+	byID atomic.Int32
+}
+
+func (u *countingUsers) UserByID(ctx context.Context, id int) (repository.User, error) {
+	u.byID.Add(1)
+	return u.UserRepository.UserByID(ctx, id)
+}
+
+func TestStaticFilesSkipSessionCheck(t *testing.T) {
+	users := &countingUsers{}
+	app := newTestAppWith(t, func(srv *server) {
+		users.UserRepository = srv.users
+		srv.users = users
+	})
+	app.createUser(t, "anna", "secret", roles.Customer)
+	c := app.client(t)
+	c.login("anna", "secret")
+
+	users.byID.Store(0)
+	assertStatus(t, c.get("/assets/auth.css"), http.StatusOK)
+	c.get("/uploads/missing.jpg")
+	if n := users.byID.Load(); n != 0 {
+		t.Fatalf("статические файлы загрузили пользователя %d раз", n)
+	}
+
+	assertStatus(t, c.get("/account/orders"), http.StatusOK)
+	if n := users.byID.Load(); n != 1 {
+		t.Fatalf("страница загрузила пользователя %d раз, ожидался 1", n)
+	}
+}
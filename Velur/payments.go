@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"golangify.com/snippetbox/orders"
+	"golangify.com/snippetbox/payments"
+	"golangify.com/snippetbox/repository"
+)
+
+// startPayment создаёт онлайн-платёж за заказ и возвращает адрес страницы
+// оплаты у шлюза.
+func (srv *server) startPayment(ctx context.Context, order repository.Order) (string, error) {
+	created, err := srv.gateway.CreatePayment(ctx, payments.Request{
+		OrderID:     order.ID,
+		Amount:      payments.Kopecks(order.Total),
+		Description: fmt.Sprintf("Заказ №%d в Velur", order.ID),
+		ReturnURL:   srv.cfg.URL("/order/" + order.PublicID),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	_, err = srv.payments.CreatePayment(ctx, repository.Payment{
+		OrderID:    order.ID,
+		Provider:   srv.gateway.Name(),
+		ExternalID: created.ID,
+		Amount:     order.Total,
+		State:      payments.Pending,
+	})
+	if err != nil {
+		return "", err
+	}
+	return created.RedirectURL, nil
+}
+
+// refundPayment возвращает покупателю зарезервированный возврат через шлюз,
+// которым он платил, и учитывает его в платеже. Подтверждённый резерв
+// повторно не отправляется. Если шлюз ответил ошибкой, резерв остаётся, и
+// повтор уйдёт с тем же ключом и суммой: шлюз не вернёт деньги дважды.
+func (srv *server) refundPayment(ctx context.Context, payment repository.Payment, refund repository.Refund) error {
+	if refund.Amount <= 0 || refund.Settled {
+		return nil
+	}
+	if srv.gateway == nil || srv.gateway.Name() != payment.Provider {
+		return fmt.Errorf("платёжный шлюз %q не подключён", payment.Provider)
+	}
+	if err := srv.gateway.Refund(ctx, payment.ExternalID, payments.Kopecks(refund.Amount), refund.Key); err != nil {
+		return err
+	}
+	_, err := srv.payments.SettleRefund(ctx, refund.ID)
+	return err
+}
+
+// paymentWebhookHandler принимает уведомления шлюза. Шлюз повторяет
+// уведомление, пока не получит 2xx, поэтому обработанные уведомления и
+// устаревшие переходы подтверждаются без изменений.
+func (srv *server) paymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["provider"]
+	if srv.gateway == nil || srv.gateway.Name() != name {
+		http.NotFound(w, r)
+		return
+	}
+
+	event, err := srv.gateway.ParseWebhook(r)
+	if errors.Is(err, payments.ErrSignature) {
+		log.Printf("Отклонено уведомление шлюза %s с неверной подписью", name)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	payment, err := srv.payments.PaymentByExternalID(r.Context(), name, event.PaymentID)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Платёж не найден", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при загрузке платежа:", err)
+		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+
+	var to payments.State
+	switch event.Type {
+	case payments.EventAuthorized:
+		to, err = srv.capturePayment(r.Context(), payment)
+		if err != nil {
+			log.Printf("Не удалось списать платёж %s: %v", payment.ExternalID, err)
+			http.Error(w, "Не удалось списать платёж", http.StatusBadGateway)
+			return
+		}
+	case payments.EventSucceeded:
+		to = payments.Paid
+	case payments.EventFailed:
+		to = payments.Failed
+	case payments.EventRefunded:
+		to = payments.Refunded
+	default:
+		log.Printf("Пропущено уведомление шлюза %s неизвестного типа %q", name, event.Type)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	payment, applied, err := srv.payments.ApplyPaymentEvent(r.Context(), name, event.ID, payment.ID, to)
+	if err != nil {
+		log.Println("Ошибка при сохранении уведомления об оплате:", err)
+		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	if applied {
+		log.Printf("Платёж по заказу №%d: %s", payment.OrderID, payment.State.Label())
+	} else {
+		log.Printf("Уведомление %s о платеже %s пропущено: уже обработано или устарело", event.ID, payment.ExternalID)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// capturePayment списывает заблокированные деньги. Если заказ успели
+// отменить, деньги не списываются и платёж считается неудавшимся.
+func (srv *server) capturePayment(ctx context.Context, payment repository.Payment) (payments.State, error) {
+	if payment.State != payments.Pending {
+		return payments.Paid, nil
+	}
+	order, err := srv.orders.OrderByID(ctx, payment.OrderID)
+	if err != nil {
+		return "", err
+	}
+	if order.Status == orders.Cancelled {
+		return payments.Failed, nil
+	}
+	if err := srv.gateway.Capture(ctx, payment.ExternalID, payments.Kopecks(payment.Amount)); err != nil {
+		return "", err
+	}
+	return payments.Paid, nil
+}
@@ -0,0 +1,295 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// FakePath — адрес страницы оплаты учебного шлюза. Её раздаёт сам магазин,
+// поэтому для разработки не нужен ни внешний сервис, ни доступ в интернет.
+const FakePath = "/payments/fake/"
+
+// FakeSignatureHeader — заголовок с подписью уведомления Fake.
+const FakeSignatureHeader = "X-Fake-Signature"
+
+// Fake — учебный шлюз: хранит платежи в памяти, показывает страницу
+// «оплаты» с кнопками «Оплатить» и «Отказаться» и присылает магазину
+// подписанные уведомления, как настоящий шлюз. Оплата двухстадийная:
+// сначала приходит EventAuthorized, и магазин вызывает Capture.
+type Fake struct {
+	// Secret — ключ HMAC-подписи уведомлений.
+	Secret string
+	// WebhookURL — адрес, на который отправляются уведомления.
+	WebhookURL string
+	Client     *http.Client
+
+	mu       sync.Mutex
+	payments map[string]*fakePayment
+}
+
+type fakePayment struct {
+	Request
+	ID       string
+	state    string
+	refunded int64
+	// refunds — выполненные возвраты по ключам идемпотентности.
+	refunds map[string]int64
+}
+
+// Состояния платежа внутри Fake.
+const (
+	fakeCreated    = "created"
+	fakeAuthorized = "authorized"
+	fakeCaptured   = "captured"
+	fakeDeclined   = "declined"
+	fakeRefunded   = "refunded"
+)
+
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (f *Fake) CreatePayment(ctx context.Context, req Request) (Created, error) {
+	if req.Amount <= 0 {
+		return Created{}, fmt.Errorf("некорректная сумма платежа: %d", req.Amount)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.payments == nil {
+		f.payments = map[string]*fakePayment{}
+	}
+	p := &fakePayment{Request: req, ID: randomID(), state: fakeCreated}
+	f.payments[p.ID] = p
+	return Created{ID: p.ID, RedirectURL: FakePath + p.ID}, nil
+}
+
+func (f *Fake) payment(id string) (*fakePayment, error) {
+	p, ok := f.payments[id]
+	if !ok {
+		return nil, fmt.Errorf("платёж %s не найден", id)
+	}
+	return p, nil
+}
+
+func (f *Fake) Capture(ctx context.Context, paymentID string, amount int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, err := f.payment(paymentID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case p.state == fakeCaptured:
+		return nil
+	case p.state != fakeAuthorized:
+		return fmt.Errorf("платёж %s нельзя списать: %s", paymentID, p.state)
+	case amount != p.Amount:
+		return fmt.Errorf("сумма списания %d не совпадает с суммой платежа %d", amount, p.Amount)
+	}
+	p.state = fakeCaptured
+	return nil
+}
+
+func (f *Fake) Refund(ctx context.Context, paymentID string, amount int64, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, err := f.payment(paymentID)
+	if err != nil {
+		return err
+	}
+	if done, ok := p.refunds[key]; ok {
+		if done != amount {
+			return fmt.Errorf("возврат %s уже выполнен на другую сумму: %d", key, done)
+		}
+		return nil
+	}
+	switch {
+	case p.state != fakeCaptured:
+		return fmt.Errorf("платёж %s нельзя вернуть: %s", paymentID, p.state)
+	case amount <= 0 || p.refunded+amount > p.Amount:
+		return fmt.Errorf("%w: %d из оставшихся %d", ErrRefundAmount, amount, p.Amount-p.refunded)
+	}
+	if p.refunds == nil {
+		p.refunds = map[string]int64{}
+	}
+	p.refunds[key] = amount
+	p.refunded += amount
+	if p.refunded == p.Amount {
+		p.state = fakeRefunded
+	}
+	return nil
+}
+
+// Sign возвращает подпись тела уведомления.
+func (f *Fake) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(f.Secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// fakeEvent — тело уведомления Fake.
+type fakeEvent struct {
+	ID        string    `json:"id"`
+	PaymentID string    `json:"payment_id"`
+	Type      EventType `json:"type"`
+}
+
+func (f *Fake) ParseWebhook(r *http.Request) (Event, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return Event{}, err
+	}
+	got, err := hex.DecodeString(r.Header.Get(FakeSignatureHeader))
+	if err != nil {
+		return Event{}, ErrSignature
+	}
+	want, _ := hex.DecodeString(f.Sign(body))
+	if !hmac.Equal(got, want) {
+		return Event{}, ErrSignature
+	}
+
+	var event fakeEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return Event{}, fmt.Errorf("некорректное уведомление: %w", err)
+	}
+	if event.ID == "" || event.PaymentID == "" || event.Type == "" {
+		return Event{}, errors.New("некорректное уведомление: не хватает полей")
+	}
+	return Event{ID: event.ID, PaymentID: event.PaymentID, Type: event.Type}, nil
+}
+
+// notify отправляет магазину подписанное уведомление о платеже.
+func (f *Fake) notify(ctx context.Context, paymentID string, eventType EventType) error {
+	body, err := json.Marshal(fakeEvent{ID: randomID(), PaymentID: paymentID, Type: eventType})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(FakeSignatureHeader, f.Sign(body))
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("магазин ответил на уведомление статусом %d", resp.StatusCode)
+	}
+	return nil
+}
+
+var fakePage = template.Must(template.New("fake").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+    <meta charset="UTF-8">
+    <title>Оплата заказа</title>
+</head>
+<body>
+    <main>
+        <h1>Учебный платёжный шлюз</h1>
+        <p>{{ .Description }}</p>
+        <p>К оплате: {{ printf "%d.%02d" .Rubles .Kopecks }} ₽</p>
+        {{ if .Open }}
+        <form method="post">
+            <button type="submit" name="action" value="pay">Оплатить</button>
+            <button type="submit" name="action" value="decline">Отказаться</button>
+        </form>
+        {{ else }}
+        <p>Платёж уже обработан. <a href="{{ .ReturnURL }}">Вернуться в магазин</a></p>
+        {{ end }}
+    </main>
+</body>
+</html>
+`))
+
+// ServeHTTP показывает страницу оплаты и принимает решение покупателя.
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, FakePath)
+
+	f.mu.Lock()
+	p, err := f.payment(id)
+	var payment fakePayment
+	if err == nil {
+		payment = *p
+	}
+	f.mu.Unlock()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		err := fakePage.Execute(w, map[string]interface{}{
+			"Description": payment.Description,
+			"Rubles":      payment.Amount / 100,
+			"Kopecks":     payment.Amount % 100,
+			"Open":        payment.state == fakeCreated,
+			"ReturnURL":   payment.ReturnURL,
+		})
+		if err != nil {
+			log.Println("Ошибка при рендеринге страницы оплаты:", err)
+		}
+		return
+	}
+
+	var event EventType
+	switch r.FormValue("action") {
+	case "pay":
+		event = EventAuthorized
+	case "decline":
+		event = EventFailed
+	default:
+		http.Error(w, "Неизвестное действие", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	open := p.state == fakeCreated
+	if open && event == EventAuthorized {
+		p.state = fakeAuthorized
+	} else if open {
+		p.state = fakeDeclined
+	}
+	f.mu.Unlock()
+
+	// Уведомление отправляется без блокировки: обрабатывая его, магазин
+	// вызывает Capture.
+	if open {
+		if err := f.notify(r.Context(), id, event); err != nil {
+			log.Println("Не удалось доставить уведомление об оплате:", err)
+			http.Error(w, "Магазин не принял уведомление об оплате", http.StatusBadGateway)
+			return
+		}
+	}
+	http.Redirect(w, r, payment.ReturnURL, http.StatusSeeOther)
+}
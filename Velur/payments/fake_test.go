@@ -0,0 +1,109 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFakeCaptureAndRefund(t *testing.T) {
+	ctx := context.Background()
+	f := &Fake{Secret: "secret"}
+
+	created, err := f.CreatePayment(ctx, Request{OrderID: 1, Amount: 130050})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.RedirectURL != FakePath+created.ID {
+		t.Fatalf("страница оплаты %q", created.RedirectURL)
+	}
+	if _, err := f.CreatePayment(ctx, Request{OrderID: 2}); err == nil {
+		t.Fatal("создан платёж на нулевую сумму")
+	}
+
+	// Пока покупатель не оплатил, списывать и возвращать нечего.
+	if err := f.Capture(ctx, created.ID, 130050); err == nil {
+		t.Fatal("списан неоплаченный платёж")
+	}
+	if err := f.Refund(ctx, created.ID, 130050, "r0"); err == nil {
+		t.Fatal("возвращён неоплаченный платёж")
+	}
+
+	f.payments[created.ID].state = fakeAuthorized
+	if err := f.Capture(ctx, created.ID, 100); err == nil {
+		t.Fatal("списана чужая сумма")
+	}
+	for range 2 {
+		if err := f.Capture(ctx, created.ID, 130050); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Частичные возвраты не могут превысить платёж.
+	if err := f.Refund(ctx, created.ID, 30000, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Refund(ctx, created.ID, 100100, "r2"); !errors.Is(err, ErrRefundAmount) {
+		t.Fatalf("возврат сверх платежа: %v", err)
+	}
+	// Повтор с тем же ключом деньги второй раз не возвращает.
+	for range 2 {
+		if err := f.Refund(ctx, created.ID, 100050, "r3"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Refund(ctx, created.ID, 30000, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Refund(ctx, created.ID, 1, "r4"); err == nil {
+		t.Fatal("возвращено больше платежа")
+	}
+	if err := f.Capture(ctx, "missing", 1); err == nil {
+		t.Fatal("списан несуществующий платёж")
+	}
+}
+
+func TestFakeParseWebhook(t *testing.T) {
+	f := &Fake{Secret: "secret"}
+	body := `{"id":"evt-1","payment_id":"p-1","type":"failed"}`
+
+	tests := []struct {
+		name      string
+		signature string
+		body      string
+		wantErr   error
+	}{
+		{"valid", f.Sign([]byte(body)), body, nil},
+		{"no signature", "", body, ErrSignature},
+		{"other secret", (&Fake{Secret: "other"}).Sign([]byte(body)), body, ErrSignature},
+		{"tampered", f.Sign([]byte(body)), strings.Replace(body, "failed", "succeeded", 1), ErrSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/webhooks/payments/fake", strings.NewReader(tt.body))
+			r.Header.Set(FakeSignatureHeader, tt.signature)
+			event, err := f.ParseWebhook(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.wantErr)
+			}
+			if err == nil && event != (Event{ID: "evt-1", PaymentID: "p-1", Type: EventFailed}) {
+				t.Fatalf("уведомление %+v", event)
+			}
+		})
+	}
+}
+
+func TestTransition(t *testing.T) {
+	if err := Transition(Pending, Paid); err != nil {
+		t.Fatal(err)
+	}
+	for _, to := range []State{Pending, Refunded} {
+		if err := Transition(Paid, to); (err == nil) != (to == Refunded) {
+			t.Fatalf("переход в %s: %v", to, err)
+		}
+	}
+	if err := Transition(Failed, Paid); !errors.Is(err, ErrTransition) {
+		t.Fatalf("оплата после отказа: %v", err)
+	}
+}
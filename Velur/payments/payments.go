@@ -0,0 +1,117 @@
+// Package payments описывает онлайн-оплату заказов: состояния платежа,
+// интерфейс платёжного шлюза и учебный шлюз Fake для разработки и тестов.
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+type State string
+
+const (
+	Pending  State = "pending"
+	Paid     State = "paid"
+	Failed   State = "failed"
+	Refunded State = "refunded"
+)
+
+var labels = map[State]string{
+	Pending:  "Ожидает оплаты",
+	Paid:     "Оплачен",
+	Failed:   "Оплата не прошла",
+	Refunded: "Деньги возвращены",
+}
+
+var transitions = map[State][]State{
+	Pending: {Paid, Failed},
+	Paid:    {Refunded},
+}
+
+var (
+	ErrTransition = errors.New("недопустимая смена состояния платежа")
+	// ErrRefundAmount — сумма возврата больше, чем осталось на платеже.
+	ErrRefundAmount = errors.New("сумма возврата больше оплаченной")
+	// ErrSignature — подпись уведомления не сходится: его прислал не шлюз.
+	ErrSignature = errors.New("неверная подпись уведомления")
+)
+
+func (s State) Label() string {
+	if label, ok := labels[s]; ok {
+		return label
+	}
+	return string(s)
+}
+
+// Transition проверяет переход платежа из from в to.
+func Transition(from, to State) error {
+	for _, next := range transitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: из «%s» в «%s»", ErrTransition, from.Label(), to.Label())
+}
+
+// Kopecks переводит сумму в рублях в копейки: шлюзы принимают целые суммы.
+func Kopecks(rub float64) int64 {
+	return int64(math.Round(rub * 100))
+}
+
+// Request — платёж, который магазин просит создать.
+type Request struct {
+	OrderID     int
+	Amount      int64 // в копейках
+	Description string
+	// ReturnURL — куда шлюз вернёт покупателя после оплаты.
+	ReturnURL string
+}
+
+// Created — платёж, созданный шлюзом. Покупателя нужно отправить на
+// RedirectURL, где он введёт данные карты.
+type Created struct {
+	ID          string
+	RedirectURL string
+}
+
+// EventType — что сообщает уведомление шлюза.
+type EventType string
+
+const (
+	// EventAuthorized — деньги заблокированы на карте, платёж нужно
+	// подтвердить вызовом Capture.
+	EventAuthorized EventType = "authorized"
+	EventSucceeded  EventType = "succeeded"
+	EventFailed     EventType = "failed"
+	EventRefunded   EventType = "refunded"
+)
+
+// Event — уведомление шлюза об изменении платежа. Шлюз повторяет
+// уведомление, пока не получит ответ 2xx, поэтому одно и то же событие с
+// тем же ID может прийти несколько раз.
+type Event struct {
+	ID        string
+	PaymentID string
+	Type      EventType
+}
+
+// Provider — платёжный шлюз.
+type Provider interface {
+	// Name — имя шлюза в адресе уведомлений /webhooks/payments/{name}.
+	Name() string
+	CreatePayment(ctx context.Context, req Request) (Created, error)
+	// Capture списывает заблокированную сумму. Повторный вызов для уже
+	// списанного платежа не ошибка.
+	Capture(ctx context.Context, paymentID string, amount int64) error
+	// Refund возвращает покупателю amount. Частичных возвратов может быть
+	// несколько, пока их сумма не превысит платёж. key — ключ
+	// идемпотентности: повторный вызов с тем же ключом деньги второй раз не
+	// возвращает.
+	Refund(ctx context.Context, paymentID string, amount int64, key string) error
+	// ParseWebhook проверяет подпись уведомления и разбирает его. Неверная
+	// подпись возвращает ErrSignature.
+	ParseWebhook(r *http.Request) (Event, error)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"golangify.com/snippetbox/orders"
+	"golangify.com/snippetbox/payments"
+	"golangify.com/snippetbox/products"
+	"golangify.com/snippetbox/repository"
+)
+
+// newPaymentApp подключает к приложению учебный шлюз, который присылает
+// уведомления тестовому серверу.
+func newPaymentApp(t *testing.T) (*testApp, *payments.Fake) {
+	t.Helper()
+	fake := &payments.Fake{Secret: "test-secret"}
+	app := newTestAppWith(t, func(srv *server) { srv.gateway = fake })
+	fake.WebhookURL = app.ts.URL + "/webhooks/payments/fake"
+	fake.Client = app.ts.Client()
+	return app, fake
+}
+
+// checkout оформляет заказ на аксессуар и возвращает адрес страницы оплаты.
+func checkout(t *testing.T, c *testClient, accessory products.Accessory) string {
+	t.Helper()
+	c.postForm("/cart/add", accessoryItem(accessory, "1"))
+	resp := c.postForm("/order", orderForm())
+	assertStatus(t, resp, http.StatusSeeOther)
+	page := resp.header.Get("Location")
+	if !strings.HasPrefix(page, payments.FakePath) {
+		t.Fatalf("редирект на %q вместо страницы оплаты", page)
+	}
+	return page
+}
+
+func lastOrder(t *testing.T, app *testApp) repository.Order {
+	t.Helper()
+	placed := app.store.Orders()
+	order, err := app.store.OrderByID(context.Background(), placed[len(placed)-1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+func postWebhook(t *testing.T, c *testClient, fake *payments.Fake, provider, eventID, paymentID string, eventType payments.EventType) testResponse {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"id": eventID, "payment_id": paymentID, "type": string(eventType)})
+	req, err := http.NewRequest(http.MethodPost, c.base+"/webhooks/payments/"+provider, strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(payments.FakeSignatureHeader, fake.Sign(body))
+	return c.do(req)
+}
+
+func TestOnlinePayment(t *testing.T) {
+	app, _ := newPaymentApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 3)
+	c := app.client(t)
+
+	page := checkout(t, c, accessory)
+	order := lastOrder(t, app)
+	if order.Payment == nil || order.Payment.State != payments.Pending || order.Payment.Amount != 300 {
+		t.Fatalf("платёж после оформления: %+v", order.Payment)
+	}
+	assertContains(t, c.get("/order/"+order.PublicID).body, "Ожидает оплаты")
+
+	resp := c.get(page)
+	assertStatus(t, resp, http.StatusOK)
+	assertContains(t, resp.body, "300.00 ₽")
+
+	assertRedirect(t, c.postForm(page, url.Values{"action": {"pay"}}), "/order/"+order.PublicID)
+	if order = lastOrder(t, app); order.Payment.State != payments.Paid {
+		t.Fatalf("состояние платежа %q", order.Payment.State)
+	}
+	assertContains(t, c.get("/order/"+order.PublicID).body, "Оплачен")
+	assertContains(t, app.adminClient(t).get(orderPath(order.ID)).body, "Оплачен")
+
+	// Оплаченный платёж второй раз не проводится.
+	assertRedirect(t, c.postForm(page, url.Values{"action": {"decline"}}), "/order/"+order.PublicID)
+	if order = lastOrder(t, app); order.Payment.State != payments.Paid {
+		t.Fatalf("состояние платежа %q", order.Payment.State)
+	}
+}
+
+func TestDeclinedPayment(t *testing.T) {
+	app, _ := newPaymentApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 3)
+	c := app.client(t)
+
+	page := checkout(t, c, accessory)
+	c.postForm(page, url.Values{"action": {"decline"}})
+	order := lastOrder(t, app)
+	if order.Payment.State != payments.Failed {
+		t.Fatalf("состояние платежа %q", order.Payment.State)
+	}
+	assertContains(t, c.get("/order/"+order.PublicID).body, "Оплата не прошла")
+}
+
+func TestPaymentWebhook(t *testing.T) {
+	app, fake := newPaymentApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 3)
+	c := app.client(t)
+	checkout(t, c, accessory)
+	payment := lastOrder(t, app).Payment
+
+	// Повторная доставка и устаревшие уведомления подтверждаются, но
+	// ничего не меняют.
+	for range 2 {
+		assertStatus(t, postWebhook(t, c, fake, "fake", "evt-1", payment.ExternalID, payments.EventFailed), http.StatusOK)
+	}
+	assertStatus(t, postWebhook(t, c, fake, "fake", "evt-2", payment.ExternalID, payments.EventSucceeded), http.StatusOK)
+	assertStatus(t, postWebhook(t, c, fake, "fake", "evt-3", payment.ExternalID, "disputed"), http.StatusOK)
+	if state := lastOrder(t, app).Payment.State; state != payments.Failed {
+		t.Fatalf("состояние платежа %q", state)
+	}
+
+	assertStatus(t, postWebhook(t, c, fake, "fake", "evt-4", "missing", payments.EventFailed), http.StatusNotFound)
+	assertStatus(t, postWebhook(t, c, fake, "stripe", "evt-5", payment.ExternalID, payments.EventFailed), http.StatusNotFound)
+	forged := postWebhook(t, c, &payments.Fake{Secret: "guess"}, "fake", "evt-6", payment.ExternalID, payments.EventSucceeded)
+	assertStatus(t, forged, http.StatusUnauthorized)
+}
+
+func TestCancelRefundsPayment(t *testing.T) {
+	app, _ := newPaymentApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 3)
+	admin := app.adminClient(t)
+	c := app.client(t)
+
+	page := checkout(t, c, accessory)
+	c.postForm(page, url.Values{"action": {"pay"}})
+	order := lastOrder(t, app)
+
+	assertRedirect(t, admin.postForm(orderPath(order.ID)+"/status", url.Values{"status": {"cancelled"}}), orderPath(order.ID))
+	if order = lastOrder(t, app); order.Status != orders.Cancelled || order.Payment.State != payments.Refunded {
+		t.Fatalf("статус %q, платёж %q", order.Status, order.Payment.State)
+	}
+
+	// Деньги за отменённый заказ не списываются, даже если покупатель
+	// успел его оплатить.
+	page = checkout(t, c, accessory)
+	order = lastOrder(t, app)
+	assertRedirect(t, admin.postForm(orderPath(order.ID)+"/status", url.Values{"status": {"cancelled"}}), orderPath(order.ID))
+	c.postForm(page, url.Values{"action": {"pay"}})
+	if order = lastOrder(t, app); order.Payment.State != payments.Failed {
+		t.Fatalf("платёж по отменённому заказу %q", order.Payment.State)
+	}
+}
+
+// heldGateway задерживает первый возврат, пока тест его не отпустит, и
+// запоминает возвраты, которые шлюз выполнил, по ключам идемпотентности.
+type heldGateway struct {
+	*payments.Fake
+	held    chan struct{}
+	release chan struct{}
+	once    sync.Once
+
+	mu      sync.Mutex
+	refunds map[string]int64
+}
+
+func (g *heldGateway) Refund(ctx context.Context, paymentID string, amount int64, key string) error {
+	first := false
+	g.once.Do(func() {
+		first = true
+		close(g.held)
+	})
+	if first {
+		<-g.release
+	}
+	if err := g.Fake.Refund(ctx, paymentID, amount, key); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.refunds[key] = amount
+	return nil
+}
+
+func TestCancelDoubleSubmitRefundsOnce(t *testing.T) {
+	app, fake := newPaymentApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 3)
+	admin := app.adminClient(t)
+	admin.token()
+	c := app.client(t)
+	c.postForm(checkout(t, c, accessory), url.Values{"action": {"pay"}})
+	order := lastOrder(t, app)
+
+	// Страницу оплаты магазин раздаёт только для самого Fake, поэтому шлюз
+	// подменяется уже после оплаты.
+	gateway := &heldGateway{
+		Fake:    fake,
+		held:    make(chan struct{}),
+		release: make(chan struct{}),
+		refunds: map[string]int64{},
+	}
+	app.srv.gateway = gateway
+	cancel := url.Values{"status": {"cancelled"}}
+
+	// Первая отправка ждёт ответа шлюза, а вторая успевает пройти целиком.
+	first := make(chan testResponse)
+	go func() { first <- admin.postForm(orderPath(order.ID)+"/status", cancel) }()
+	<-gateway.held
+	assertRedirect(t, admin.postForm(orderPath(order.ID)+"/status", cancel), orderPath(order.ID))
+	close(gateway.release)
+	assertStatus(t, <-first, http.StatusConflict)
+
+	if len(gateway.refunds) != 1 || gateway.refunds["order-"+strconv.Itoa(order.ID)+"-cancelled"] != payments.Kopecks(order.Total) {
+		t.Fatalf("возвраты шлюза: %v", gateway.refunds)
+	}
+	order = lastOrder(t, app)
+	if order.Payment.State != payments.Refunded || order.Payment.Refunded != order.Total {
+		t.Fatalf("платёж %q, возвращено %.2f из %.2f", order.Payment.State, order.Payment.Refunded, order.Total)
+	}
+}
+
+// Пока шлюз не подтвердил возврат при отмене, заказ нельзя отправить:
+// иначе деньги вернутся, а товар уйдёт покупателю.
+func TestShipWhileCancelRefundPending(t *testing.T) {
+	app, fake := newPaymentApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 3)
+	admin := app.adminClient(t)
+	admin.token()
+	c := app.client(t)
+	c.postForm(checkout(t, c, accessory), url.Values{"action": {"pay"}})
+	order := lastOrder(t, app)
+	for _, status := range []string{"confirmed", "packed"} {
+		assertRedirect(t, admin.postForm(orderPath(order.ID)+"/status", url.Values{"status": {status}}), orderPath(order.ID))
+	}
+
+	gateway := &heldGateway{
+		Fake:    fake,
+		held:    make(chan struct{}),
+		release: make(chan struct{}),
+		refunds: map[string]int64{},
+	}
+	app.srv.gateway = gateway
+
+	cancel := make(chan testResponse)
+	go func() { cancel <- admin.postForm(orderPath(order.ID)+"/status", url.Values{"status": {"cancelled"}}) }()
+	<-gateway.held
+	assertStatus(t, admin.postForm(orderPath(order.ID)+"/status", url.Values{"status": {"shipped"}}), http.StatusConflict)
+	close(gateway.release)
+	assertRedirect(t, <-cancel, orderPath(order.ID))
+
+	if order = lastOrder(t, app); order.Status != orders.Cancelled || order.Payment.State != payments.Refunded {
+		t.Fatalf("статус %q, платёж %q", order.Status, order.Payment.State)
+	}
+}
+
+// Шлюз сообщил о возврате раньше, чем магазин его учёл: резерв всё равно
+// закрывается и не мешает сменить статус заказа.
+func TestSettleRefundAfterRefundWebhook(t *testing.T) {
+	app, fake := newPaymentApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 3)
+	admin := app.adminClient(t)
+	c := app.client(t)
+	c.postForm(checkout(t, c, accessory), url.Values{"action": {"pay"}})
+	order := lastOrder(t, app)
+
+	ctx := context.Background()
+	refund, err := app.store.ReserveOrderRefund(ctx, order.ID, orders.Cancelled)
+	if err != nil || refund.Amount != order.Total {
+		t.Fatalf("резерв %+v, ошибка %v", refund, err)
+	}
+	assertStatus(t, postWebhook(t, c, fake, "fake", "evt-refund", order.Payment.ExternalID, payments.EventRefunded), http.StatusOK)
+	for range 2 {
+		payment, err := app.store.SettleRefund(ctx, refund.ID)
+		if err != nil || payment.State != payments.Refunded || payment.Refunded != order.Total {
+			t.Fatalf("платёж %+v, ошибка %v", payment, err)
+		}
+	}
+
+	assertRedirect(t, admin.postForm(orderPath(order.ID)+"/status", url.Values{"status": {"confirmed"}}), orderPath(order.ID))
+}
@@ -1,30 +1,162 @@
 package products
 
+import (
+	"fmt"
+	"strings"
+)
+
+// Категории товаров, как они записываются в корзине и заказах.
+const (
+	CategoryClothing  = "clothing"
+	CategoryAccessory = "accessory"
+)
+
+// ImageSizes — уменьшенные копии изображения товара. Пустые поля значат,
+// что копии ещё не построены и страницы показывают исходник.
+type ImageSizes struct {
+	Thumb string
+	Card  string
+	Zoom  string
+}
+
+// Ширина копий в пикселях; по ней браузер выбирает копию из srcset.
+const (
+	ThumbWidth = 160
+	CardWidth  = 480
+	ZoomWidth  = 1200
+)
+
+// SrcSet возвращает значение атрибута srcset.
+func (s ImageSizes) SrcSet() string {
+	var parts []string
+	for _, size := range []struct {
+		url   string
+		width int
+	}{{s.Thumb, ThumbWidth}, {s.Card, CardWidth}, {s.Zoom, ZoomWidth}} {
+		if size.url != "" {
+			parts = append(parts, fmt.Sprintf("%s %dw", size.url, size.width))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Image — фотография товара. Фотографии хранятся в порядке показа.
+type Image struct {
+	ID    string
+	URL   string
+	Sizes ImageSizes
+	Alt   string
+}
+
+// Cover возвращает обложку — первую фотографию товара.
+func Cover(images []Image) (string, ImageSizes) {
+	if len(images) == 0 {
+		return "", ImageSizes{}
+	}
+	return images[0].URL, images[0].Sizes
+}
+
+// Clothing — товар одежды. ImageURL и ImageSizes повторяют первую из Images
+// и служат обложкой карточки в каталоге.
 type Clothing struct {
 	ID          string
 	Name        string
 	Description string
 	ImageURL    string
+	ImageSizes  ImageSizes
+	Images      []Image
 	Price       float64
-	Size        string
-	Color       string
 	Material    string
 	Type        string
 	Season      string
+	Variants    []Variant
+}
+
+// Variant — конкретный размер и цвет товара одежды со своим артикулом,
+// остатком и, при необходимости, собственной ценой.
+type Variant struct {
+	ID            string
+	Size          string
+	Color         string
+	SKU           string
+	Stock         int
+	PriceOverride float64 // 0 — используется цена товара
+}
+
+// SetImages задаёт фотографии товара и обновляет по ним обложку.
+func (c *Clothing) SetImages(images []Image) {
+	c.Images = images
+	c.ImageURL, c.ImageSizes = Cover(images)
+}
+
+func (c Clothing) InStock() bool {
+	for _, v := range c.Variants {
+		if v.Stock > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Clothing) Variant(id string) (Variant, bool) {
+	for _, v := range c.Variants {
+		if v.ID == id {
+			return v, true
+		}
+	}
+	return Variant{}, false
+}
+
+func (c Clothing) VariantPrice(v Variant) float64 {
+	if v.PriceOverride > 0 {
+		return v.PriceOverride
+	}
+	return c.Price
 }
 
-var Clothes = map[string]Clothing{}
+func (c Clothing) Sizes() []string {
+	return c.unique(func(v Variant) string { return v.Size })
+}
+
+func (c Clothing) Colors() []string {
+	return c.unique(func(v Variant) string { return v.Color })
+}
+
+func (c Clothing) unique(field func(Variant) string) []string {
+	var values []string
+	seen := map[string]bool{}
+	for _, v := range c.Variants {
+		value := field(v)
+		if value == "" || seen[value] {
+			continue
+		}
+		seen[value] = true
+		values = append(values, value)
+	}
+	return values
+}
 
 type Accessory struct {
 	ID          string
 	Name        string
 	Description string
 	ImageURL    string
+	ImageSizes  ImageSizes
+	Images      []Image
 	Price       float64
 	Type        string
 	Color       string
 	Material    string
 	Target      string
+	Stock       int
 }
 
-var Accessories = map[string]Accessory{}
+// SetImages задаёт фотографии товара и обновляет по ним обложку.
+func (a *Accessory) SetImages(images []Image) {
+	a.Images = images
+	a.ImageURL, a.ImageSizes = Cover(images)
+}
+
+func (a Accessory) InStock() bool {
+	return a.Stock > 0
+}
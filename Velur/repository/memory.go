@@ -0,0 +1,1249 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golangify.com/snippetbox/catalog"
+	"golangify.com/snippetbox/jobs"
+	"golangify.com/snippetbox/orders"
+	"golangify.com/snippetbox/payments"
+	"golangify.com/snippetbox/products"
+	"golangify.com/snippetbox/returns"
+	"golangify.com/snippetbox/roles"
+)
+
+// Memory хранит данные магазина в памяти процесса и ведёт себя так же, как
+// Postgres: проверяет уникальность, остатки и атомарно оформляет заказы.
+// Данные теряются при перезапуске.
+type Memory struct {
+	mu sync.Mutex
+
+	lastID      int
+	clothes     map[string]products.Clothing
+	accessories map[string]products.Accessory
+	users       map[int]User
+	carts       map[int]map[CartItem]int
+	orders      []Order
+	payments    []Payment
+	returns     []Return
+	jobs        []memoryJob
+	resets      []memoryReset
+	refunds     []Refund
+	// paymentEvents — обработанные уведомления шлюзов, ключ provider/eventID.
+	paymentEvents map[string]bool
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		clothes:     map[string]products.Clothing{},
+		accessories: map[string]products.Accessory{},
+		users:       map[int]User{},
+		carts:       map[int]map[CartItem]int{},
+
+		paymentEvents: map[string]bool{},
+	}
+}
+
+// nextID выдаёт идентификаторы из одной последовательности для всех таблиц.
+func (m *Memory) nextID() int {
+	m.lastID++
+	return m.lastID
+}
+
+// Orders возвращает оформленные заказы в порядке оформления.
+func (m *Memory) Orders() []Order {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.orders)
+}
+
+func (m *Memory) LoadCatalog(ctx context.Context) (*catalog.Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := &catalog.Snapshot{
+		Clothes:     make(map[string]products.Clothing, len(m.clothes)),
+		Accessories: make(map[string]products.Accessory, len(m.accessories)),
+	}
+	for id, clothing := range m.clothes {
+		clothing.Variants = slices.Clone(clothing.Variants)
+		clothing.Images = slices.Clone(clothing.Images)
+		snapshot.Clothes[id] = clothing
+	}
+	for id, accessory := range m.accessories {
+		accessory.Images = slices.Clone(accessory.Images)
+		snapshot.Accessories[id] = accessory
+	}
+	return snapshot, nil
+}
+
+// skuTaken проверяет уникальность артикула среди всех вариантов, кроме
+// варианта с ID except.
+func (m *Memory) skuTaken(sku, except string) bool {
+	for _, clothing := range m.clothes {
+		for _, v := range clothing.Variants {
+			if v.SKU == sku && v.ID != except {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *Memory) CreateClothing(ctx context.Context, clothing products.Clothing) (products.Clothing, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clothing.ID = strconv.Itoa(m.nextID())
+	variants := slices.Clone(clothing.Variants)
+	assignSKUs(clothing.ID, variants)
+
+	seen := map[[2]string]bool{}
+	for i := range variants {
+		key := [2]string{variants[i].Size, variants[i].Color}
+		if seen[key] || m.skuTaken(variants[i].SKU, "") {
+			return clothing, ErrConflict
+		}
+		seen[key] = true
+		variants[i].ID = strconv.Itoa(m.nextID())
+	}
+
+	clothing.Variants = variants
+	clothing.SetImages(m.withCover(nil, clothing.ImageURL, clothing.ImageSizes, clothing.Name))
+	m.clothes[clothing.ID] = clothing
+	return clothing, nil
+}
+
+func (m *Memory) UpdateClothing(ctx context.Context, clothing products.Clothing) (products.Clothing, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, exists := m.clothes[clothing.ID]
+	if !exists {
+		return clothing, ErrNotFound
+	}
+	clothing.SetImages(m.withCover(current.Images, clothing.ImageURL, clothing.ImageSizes, clothing.Name))
+	clothing.Variants = current.Variants
+	m.clothes[clothing.ID] = clothing
+	return clothing, nil
+}
+
+func (m *Memory) DeleteClothing(ctx context.Context, id string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clothing := m.clothes[id]
+	delete(m.clothes, id)
+	return m.unusedImages(clothing.ImageURL, clothing.ImageSizes, clothing.Images), nil
+}
+
+func (m *Memory) AddVariant(ctx context.Context, clothingID string, variant products.Variant) (products.Variant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clothing, exists := m.clothes[clothingID]
+	if !exists {
+		return variant, ErrNotFound
+	}
+	for _, v := range clothing.Variants {
+		if v.Size == variant.Size && v.Color == variant.Color {
+			return variant, ErrConflict
+		}
+	}
+	if m.skuTaken(variant.SKU, "") {
+		return variant, ErrConflict
+	}
+
+	variant.ID = strconv.Itoa(m.nextID())
+	clothing.Variants = append(slices.Clone(clothing.Variants), variant)
+	m.clothes[clothingID] = clothing
+	return variant, nil
+}
+
+func (m *Memory) UpdateVariant(ctx context.Context, variant products.Variant) (string, products.Variant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for clothingID, clothing := range m.clothes {
+		i := slices.IndexFunc(clothing.Variants, func(v products.Variant) bool { return v.ID == variant.ID })
+		if i < 0 {
+			continue
+		}
+		if m.skuTaken(variant.SKU, variant.ID) {
+			return "", variant, ErrConflict
+		}
+
+		variant.Size, variant.Color = clothing.Variants[i].Size, clothing.Variants[i].Color
+		clothing.Variants = slices.Clone(clothing.Variants)
+		clothing.Variants[i] = variant
+		m.clothes[clothingID] = clothing
+		return clothingID, variant, nil
+	}
+	return "", variant, ErrNotFound
+}
+
+func (m *Memory) DeleteVariant(ctx context.Context, id string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for clothingID, clothing := range m.clothes {
+		i := slices.IndexFunc(clothing.Variants, func(v products.Variant) bool { return v.ID == id })
+		if i < 0 {
+			continue
+		}
+		clothing.Variants = slices.Delete(slices.Clone(clothing.Variants), i, i+1)
+		m.clothes[clothingID] = clothing
+		return clothingID, nil
+	}
+	return "", ErrNotFound
+}
+
+func (m *Memory) CreateAccessory(ctx context.Context, accessory products.Accessory) (products.Accessory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	accessory.ID = strconv.Itoa(m.nextID())
+	accessory.SetImages(m.withCover(nil, accessory.ImageURL, accessory.ImageSizes, accessory.Name))
+	m.accessories[accessory.ID] = accessory
+	return accessory, nil
+}
+
+func (m *Memory) UpdateAccessory(ctx context.Context, accessory products.Accessory) (products.Accessory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, exists := m.accessories[accessory.ID]
+	if !exists {
+		return accessory, ErrNotFound
+	}
+	accessory.SetImages(m.withCover(current.Images, accessory.ImageURL, accessory.ImageSizes, accessory.Name))
+	m.accessories[accessory.ID] = accessory
+	return accessory, nil
+}
+
+func (m *Memory) DeleteAccessory(ctx context.Context, id string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	accessory := m.accessories[id]
+	delete(m.accessories, id)
+	return m.unusedImages(accessory.ImageURL, accessory.ImageSizes, accessory.Images), nil
+}
+
+// unusedImages отбирает из ссылок удалённого товара те, на которые больше
+// ничего не ссылается.
+func (m *Memory) unusedImages(cover string, sizes products.ImageSizes, images []products.Image) []string {
+	urls := map[string]bool{}
+	addImageURLs(urls, cover, sizes)
+	for _, image := range images {
+		addImageURLs(urls, image.URL, image.Sizes)
+	}
+
+	referenced := m.imageURLs()
+	var unused []string
+	for url := range urls {
+		if !referenced[url] {
+			unused = append(unused, url)
+		}
+	}
+	slices.Sort(unused)
+	return unused
+}
+
+func addImageURLs(urls map[string]bool, url string, sizes products.ImageSizes) {
+	for _, u := range []string{url, sizes.Thumb, sizes.Card, sizes.Zoom} {
+		if u != "" {
+			urls[u] = true
+		}
+	}
+}
+
+func (m *Memory) imageURLs() map[string]bool {
+	urls := map[string]bool{}
+	for _, clothing := range m.clothes {
+		addImageURLs(urls, clothing.ImageURL, clothing.ImageSizes)
+		for _, image := range clothing.Images {
+			addImageURLs(urls, image.URL, image.Sizes)
+		}
+	}
+	for _, accessory := range m.accessories {
+		addImageURLs(urls, accessory.ImageURL, accessory.ImageSizes)
+		for _, image := range accessory.Images {
+			addImageURLs(urls, image.URL, image.Sizes)
+		}
+	}
+	for _, order := range m.orders {
+		for _, item := range order.Items {
+			addImageURLs(urls, item.ImageURL, products.ImageSizes{})
+		}
+	}
+	return urls
+}
+
+func (m *Memory) ImageURLs(ctx context.Context) (map[string]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.imageURLs(), nil
+}
+
+// withCover заменяет файл первой фотографии галереи или, если галерея
+// пуста, добавляет фотографию. Пустой url оставляет галерею как есть.
+func (m *Memory) withCover(images []products.Image, url string, sizes products.ImageSizes, alt string) []products.Image {
+	images = slices.Clone(images)
+	switch {
+	case url == "":
+	case len(images) == 0:
+		images = append(images, products.Image{ID: strconv.Itoa(m.nextID()), URL: url, Sizes: sizes, Alt: alt})
+	default:
+		images[0].URL, images[0].Sizes = url, sizes
+	}
+	return images
+}
+
+// images возвращает галерею товара; false, если товара нет.
+func (m *Memory) images(owner ImageOwner) ([]products.Image, bool) {
+	if owner.Category == products.CategoryAccessory {
+		accessory, exists := m.accessories[owner.ProductID]
+		return slices.Clone(accessory.Images), exists
+	}
+	clothing, exists := m.clothes[owner.ProductID]
+	return slices.Clone(clothing.Images), exists
+}
+
+func (m *Memory) setImages(owner ImageOwner, images []products.Image) {
+	if owner.Category == products.CategoryAccessory {
+		accessory := m.accessories[owner.ProductID]
+		accessory.SetImages(images)
+		m.accessories[owner.ProductID] = accessory
+		return
+	}
+	clothing := m.clothes[owner.ProductID]
+	clothing.SetImages(images)
+	m.clothes[owner.ProductID] = clothing
+}
+
+func (m *Memory) AddImage(ctx context.Context, owner ImageOwner, image products.Image) ([]products.Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	images, exists := m.images(owner)
+	if !exists {
+		return nil, ErrNotFound
+	}
+	image.ID = strconv.Itoa(m.nextID())
+	images = append(images, image)
+	m.setImages(owner, images)
+	return images, nil
+}
+
+// changeImage находит фотографию id и сохраняет галерею, которую вернёт
+// change.
+func (m *Memory) changeImage(id string, change func(images []products.Image, i int) []products.Image) (ImageOwner, []products.Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var owners []ImageOwner
+	for _, clothing := range m.clothes {
+		owners = append(owners, ImageOwner{products.CategoryClothing, clothing.ID})
+	}
+	for _, accessory := range m.accessories {
+		owners = append(owners, ImageOwner{products.CategoryAccessory, accessory.ID})
+	}
+	for _, owner := range owners {
+		images, _ := m.images(owner)
+		for i := range images {
+			if images[i].ID == id {
+				images = change(images, i)
+				m.setImages(owner, images)
+				return owner, images, nil
+			}
+		}
+	}
+	return ImageOwner{}, nil, ErrNotFound
+}
+
+func (m *Memory) UpdateImageAlt(ctx context.Context, id, alt string) (ImageOwner, []products.Image, error) {
+	return m.changeImage(id, func(images []products.Image, i int) []products.Image {
+		images[i].Alt = alt
+		return images
+	})
+}
+
+func (m *Memory) SetImageSizes(ctx context.Context, id string, sizes products.ImageSizes) (ImageOwner, []products.Image, error) {
+	return m.changeImage(id, func(images []products.Image, i int) []products.Image {
+		images[i].Sizes = sizes
+		return images
+	})
+}
+
+func (m *Memory) MoveImage(ctx context.Context, id string, delta int) (ImageOwner, []products.Image, error) {
+	return m.changeImage(id, func(images []products.Image, i int) []products.Image {
+		return moveImage(images, id, delta)
+	})
+}
+
+func (m *Memory) DeleteImage(ctx context.Context, id string) (ImageOwner, []products.Image, error) {
+	return m.changeImage(id, func(images []products.Image, i int) []products.Image {
+		return slices.Delete(images, i, i+1)
+	})
+}
+
+func (m *Memory) SetAccessoryStock(ctx context.Context, id string, stock int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if accessory, exists := m.accessories[id]; exists {
+		accessory.Stock = stock
+		m.accessories[id] = accessory
+	}
+	return nil
+}
+
+func (m *Memory) CreateUser(ctx context.Context, user User) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Username == user.Username {
+			return user, ErrConflict
+		}
+	}
+	user.ID = m.nextID()
+	user.SessionVersion = 1
+	m.users[user.ID] = user
+	return user, nil
+}
+
+func (m *Memory) UserByID(ctx context.Context, id int) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (m *Memory) UserByUsername(ctx context.Context, username string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (m *Memory) UsersByEmail(ctx context.Context, email string) ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var users []User
+	for _, u := range m.users {
+		if u.Email != "" && strings.EqualFold(u.Email, email) {
+			users = append(users, u)
+		}
+	}
+	slices.SortFunc(users, func(a, b User) int { return a.ID - b.ID })
+	return users, nil
+}
+
+func (m *Memory) Users(ctx context.Context) ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := make([]User, 0, len(m.users))
+	for _, u := range m.users {
+		users = append(users, u)
+	}
+	slices.SortFunc(users, func(a, b User) int { return a.ID - b.ID })
+	return users, nil
+}
+
+// updateUser применяет fn к пользователю username.
+func (m *Memory) updateUser(username string, fn func(*User)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, u := range m.users {
+		if u.Username == username {
+			fn(&u)
+			m.users[id] = u
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *Memory) SetRole(ctx context.Context, username, role string) error {
+	return m.updateUser(username, func(u *User) { u.Role = role })
+}
+
+func (m *Memory) SetPassword(ctx context.Context, username string, hash []byte) error {
+	return m.updateUser(username, func(u *User) {
+		u.PasswordHash = hash
+		u.SessionVersion++
+	})
+}
+
+func (m *Memory) RolePermissions(ctx context.Context, role string) ([]roles.Permission, error) {
+	return slices.Clone(roles.Defaults[role]), nil
+}
+
+func (m *Memory) Cart(ctx context.Context, userID int) (map[CartItem]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cart := map[CartItem]int{}
+	for item, quantity := range m.carts[userID] {
+		cart[item] = quantity
+	}
+	return cart, nil
+}
+
+func (m *Memory) userCart(userID int) map[CartItem]int {
+	cart, ok := m.carts[userID]
+	if !ok {
+		cart = map[CartItem]int{}
+		m.carts[userID] = cart
+	}
+	return cart
+}
+
+func (m *Memory) SetCartQuantity(ctx context.Context, userID int, item CartItem, quantity int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if quantity <= 0 {
+		delete(m.carts[userID], item)
+		return nil
+	}
+	m.userCart(userID)[item] = quantity
+	return nil
+}
+
+func (m *Memory) AddToCart(ctx context.Context, userID int, item CartItem, quantity int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.userCart(userID)[item] += quantity
+	return nil
+}
+
+// reserve находит товар позиции и проверяет остаток, ничего не меняя.
+func (m *Memory) reserve(line OrderLine) (OrderItem, error) {
+	item := OrderItem{CartItem: line.Item, Quantity: line.Quantity}
+	var stock int
+
+	switch line.Item.Category {
+	case products.CategoryClothing:
+		clothing, exists := m.clothes[line.Item.ProductID]
+		if !exists {
+			return item, ErrProductUnavailable
+		}
+		variant, exists := clothing.Variant(line.Item.VariantID)
+		if !exists {
+			return item, ErrProductUnavailable
+		}
+		item.Name, item.ImageURL, item.Price = clothing.Name, clothing.ImageURL, clothing.VariantPrice(variant)
+		item.Size, item.Color, item.SKU = variant.Size, variant.Color, variant.SKU
+		stock = variant.Stock
+	case products.CategoryAccessory:
+		accessory, exists := m.accessories[line.Item.ProductID]
+		if !exists {
+			return item, ErrProductUnavailable
+		}
+		item.Name, item.ImageURL, item.Price = accessory.Name, accessory.ImageURL, accessory.Price
+		stock = accessory.Stock
+	default:
+		return item, ErrProductUnavailable
+	}
+
+	if stock < line.Quantity {
+		return item, &OutOfStockError{Name: item.Name, Available: stock}
+	}
+	return item, nil
+}
+
+func (m *Memory) PlaceOrder(ctx context.Context, newOrder NewOrder) (Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	order := Order{
+		PublicID:        newPublicID(),
+		UserID:          newOrder.UserID,
+		Customer:        newOrder.Customer,
+		CreatedAt:       now,
+		Status:          orders.New,
+		StatusChangedAt: now,
+		History:         []StatusChange{{To: orders.New, ChangedAt: now}},
+	}
+	for _, line := range newOrder.Lines {
+		item, err := m.reserve(line)
+		if err != nil {
+			return Order{}, err
+		}
+		order.Items = append(order.Items, item)
+		order.Total += item.Price * float64(item.Quantity)
+	}
+
+	// Остатки списываются только после проверки всех позиций, чтобы
+	// неудачный заказ ничего не менял.
+	for _, item := range order.Items {
+		if item.Category == products.CategoryClothing {
+			clothing := m.clothes[item.ProductID]
+			clothing.Variants = slices.Clone(clothing.Variants)
+			for i := range clothing.Variants {
+				if clothing.Variants[i].ID == item.VariantID {
+					clothing.Variants[i].Stock -= item.Quantity
+				}
+			}
+			m.clothes[item.ProductID] = clothing
+		} else {
+			accessory := m.accessories[item.ProductID]
+			accessory.Stock -= item.Quantity
+			m.accessories[item.ProductID] = accessory
+		}
+	}
+
+	order.ID = m.nextID()
+	for i := range order.Items {
+		order.Items[i].ID = m.nextID()
+	}
+	orderJobs, err := orderJobs(newOrder.Jobs, order.ID)
+	if err != nil {
+		return order, err
+	}
+	m.orders = append(m.orders, order)
+	m.enqueueJobs(orderJobs)
+	if newOrder.UserID != 0 {
+		delete(m.carts, newOrder.UserID)
+	}
+	return order, nil
+}
+
+func (m *Memory) ListOrders(ctx context.Context, filter OrderFilter) ([]Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	phone := phoneDigits(filter.Phone)
+	var list []Order
+	for i := len(m.orders) - 1; i >= 0; i-- {
+		order := m.orders[i]
+		switch {
+		case filter.Status != "" && order.Status != filter.Status:
+		case !filter.From.IsZero() && order.CreatedAt.Before(filter.From):
+		case !filter.To.IsZero() && !order.CreatedAt.Before(filter.To):
+		case phone != "" && !strings.Contains(phoneDigits(order.Customer.Phone), phone):
+		case filter.UserID != 0 && order.UserID != filter.UserID:
+		default:
+			order.Items, order.History = nil, nil
+			list = append(list, order)
+		}
+		if filter.Limit > 0 && len(list) == filter.Limit {
+			break
+		}
+	}
+	return list, nil
+}
+
+func (m *Memory) OrderByID(ctx context.Context, id int) (Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, order := range m.orders {
+		if order.ID == id {
+			return m.withPayment(order), nil
+		}
+	}
+	return Order{}, ErrNotFound
+}
+
+func (m *Memory) OrderByPublicID(ctx context.Context, publicID string) (Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, order := range m.orders {
+		if order.PublicID == publicID {
+			return m.withPayment(order), nil
+		}
+	}
+	return Order{}, ErrNotFound
+}
+
+func (m *Memory) SetOrderStatus(ctx context.Context, id int, to orders.Status, userID int, comment string) (Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.orders, func(o Order) bool { return o.ID == id })
+	if i < 0 {
+		return Order{}, ErrNotFound
+	}
+	order := m.orders[i]
+	if err := orders.Transition(order.Status, to); err != nil {
+		return order, err
+	}
+	if payment := m.withPayment(order).Payment; payment != nil {
+		key := orderRefundKey(id, to)
+		if slices.ContainsFunc(m.refunds, func(r Refund) bool { return r.PaymentID == payment.ID && !r.Settled && r.Key != key }) {
+			return order, ErrRefundPending
+		}
+	}
+
+	if orders.Restocks(to) {
+		m.restock(order.Items)
+	}
+	now := time.Now()
+	order.History = append(slices.Clone(order.History), StatusChange{
+		From:      order.Status,
+		To:        to,
+		ChangedBy: m.users[userID].Username,
+		Comment:   comment,
+		ChangedAt: now,
+	})
+	order.Status, order.StatusChangedAt = to, now
+	m.orders[i] = order
+	return m.withPayment(order), nil
+}
+
+func (m *Memory) ReserveOrderRefund(ctx context.Context, id int, to orders.Status) (Refund, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order, ok := m.order(id)
+	if !ok {
+		return Refund{}, ErrNotFound
+	}
+	if err := orders.Transition(order.Status, to); err != nil {
+		return Refund{}, err
+	}
+	payment := m.withPayment(order).Payment
+	if !orders.Refunds(to) || payment == nil || payment.State != payments.Paid {
+		return Refund{}, nil
+	}
+	return m.reserveRefund(payment.ID, orderRefundKey(id, to), payment.Amount)
+}
+
+// restock возвращает позиции заказа на склад; удалённые товары пропускаются.
+func (m *Memory) restock(items []OrderItem) {
+	for _, item := range items {
+		if item.Category == products.CategoryClothing {
+			clothing, exists := m.clothes[item.ProductID]
+			if !exists {
+				continue
+			}
+			clothing.Variants = slices.Clone(clothing.Variants)
+			for i := range clothing.Variants {
+				if clothing.Variants[i].ID == item.VariantID {
+					clothing.Variants[i].Stock += item.Quantity
+				}
+			}
+			m.clothes[item.ProductID] = clothing
+		} else if accessory, exists := m.accessories[item.ProductID]; exists {
+			accessory.Stock += item.Quantity
+			m.accessories[item.ProductID] = accessory
+		}
+	}
+}
+
+// withPayment добавляет к заказу его последний платёж.
+func (m *Memory) withPayment(order Order) Order {
+	for i := len(m.payments) - 1; i >= 0; i-- {
+		if m.payments[i].OrderID == order.ID {
+			payment := m.payments[i]
+			order.Payment = &payment
+			break
+		}
+	}
+	return order
+}
+
+func (m *Memory) CreatePayment(ctx context.Context, payment Payment) (Payment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !slices.ContainsFunc(m.orders, func(o Order) bool { return o.ID == payment.OrderID }) {
+		return Payment{}, ErrNotFound
+	}
+	payment.ID = m.nextID()
+	payment.CreatedAt = time.Now()
+	payment.UpdatedAt = payment.CreatedAt
+	m.payments = append(m.payments, payment)
+	return payment, nil
+}
+
+func (m *Memory) PaymentByExternalID(ctx context.Context, provider, externalID string) (Payment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, payment := range m.payments {
+		if payment.Provider == provider && payment.ExternalID == externalID {
+			return payment, nil
+		}
+	}
+	return Payment{}, ErrNotFound
+}
+
+func (m *Memory) ApplyPaymentEvent(ctx context.Context, provider, eventID string, paymentID int, to payments.State) (Payment, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.payments, func(p Payment) bool { return p.ID == paymentID })
+	if i < 0 {
+		return Payment{}, false, ErrNotFound
+	}
+	key := provider + "/" + eventID
+	if m.paymentEvents[key] {
+		return m.payments[i], false, nil
+	}
+	m.paymentEvents[key] = true
+	if payments.Transition(m.payments[i].State, to) != nil {
+		return m.payments[i], false, nil
+	}
+	m.payments[i].State, m.payments[i].UpdatedAt = to, time.Now()
+	return m.payments[i], true, nil
+}
+
+// reserveRefund резервирует возврат amount с платежа paymentID под ключом
+// key; вызывается под m.mu.
+func (m *Memory) reserveRefund(paymentID int, key string, amount float64) (Refund, error) {
+	i := slices.IndexFunc(m.payments, func(p Payment) bool { return p.ID == paymentID })
+	if i < 0 {
+		return Refund{}, ErrNotFound
+	}
+	var reserved float64
+	for _, refund := range m.refunds {
+		if refund.Key == key {
+			return refund, nil
+		}
+		if refund.PaymentID == paymentID && !refund.Settled {
+			reserved += refund.Amount
+		}
+	}
+
+	refund := Refund{PaymentID: paymentID, Key: key, Amount: refundReserve(m.payments[i], reserved, amount)}
+	if refund.Amount > 0 {
+		refund.ID = m.nextID()
+		m.refunds = append(m.refunds, refund)
+	}
+	return refund, nil
+}
+
+func (m *Memory) SettleRefund(ctx context.Context, id int) (Payment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := slices.IndexFunc(m.refunds, func(refund Refund) bool { return refund.ID == id })
+	if r < 0 {
+		return Payment{}, ErrNotFound
+	}
+	refund := &m.refunds[r]
+	i := slices.IndexFunc(m.payments, func(p Payment) bool { return p.ID == refund.PaymentID })
+	if i < 0 {
+		return Payment{}, ErrNotFound
+	}
+	payment := &m.payments[i]
+	if refund.Settled {
+		return *payment, nil
+	}
+	state, err := refundState(*payment, refund.Amount)
+	if err != nil {
+		return *payment, err
+	}
+	refund.Settled = true
+	payment.Refunded = min(payment.Refunded+refund.Amount, payment.Amount)
+	payment.State, payment.UpdatedAt = state, time.Now()
+	return *payment, nil
+}
+
+func (m *Memory) order(id int) (Order, bool) {
+	i := slices.IndexFunc(m.orders, func(o Order) bool { return o.ID == id })
+	if i < 0 {
+		return Order{}, false
+	}
+	return m.orders[i], true
+}
+
+func (m *Memory) CreateReturn(ctx context.Context, newReturn NewReturn) (Return, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order, ok := m.order(newReturn.OrderID)
+	if !ok {
+		return Return{}, ErrNotFound
+	}
+	if order.Status != orders.Delivered {
+		return Return{}, ErrReturnNotAllowed
+	}
+
+	available := map[int]int{}
+	items := map[int]OrderItem{}
+	for _, item := range order.Items {
+		available[item.ID], items[item.ID] = item.Quantity, item
+	}
+	for _, r := range m.returns {
+		if r.OrderID != order.ID || r.Status == returns.Rejected {
+			continue
+		}
+		for _, line := range r.Lines {
+			available[line.OrderItemID] -= line.Quantity
+		}
+	}
+	lines, err := returnLines(newReturn.Quantities, available)
+	if err != nil {
+		return Return{}, err
+	}
+	for i, line := range lines {
+		item := items[line.OrderItemID]
+		lines[i].Name, lines[i].Size, lines[i].Color, lines[i].Price = item.Name, item.Size, item.Color, item.Price
+	}
+
+	now := time.Now()
+	r := Return{
+		ID:        m.nextID(),
+		OrderID:   order.ID,
+		Status:    returns.Requested,
+		Reason:    newReturn.Reason,
+		Comment:   newReturn.Comment,
+		Lines:     lines,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	m.returns = append(m.returns, r)
+	return r, nil
+}
+
+func (m *Memory) ReturnByID(ctx context.Context, id int) (Return, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range m.returns {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return Return{}, ErrNotFound
+}
+
+func (m *Memory) OrderReturns(ctx context.Context, orderID int) ([]Return, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var list []Return
+	for _, r := range m.returns {
+		if r.OrderID == orderID {
+			list = append(list, r)
+		}
+	}
+	return list, nil
+}
+
+func (m *Memory) ListReturns(ctx context.Context, status returns.Status) ([]Return, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var list []Return
+	for i := len(m.returns) - 1; i >= 0; i-- {
+		if r := m.returns[i]; status == "" || r.Status == status {
+			r.Lines = nil
+			list = append(list, r)
+		}
+	}
+	return list, nil
+}
+
+func (m *Memory) SetReturnStatus(ctx context.Context, id int, to returns.Status) (Return, error) {
+	if to == returns.Completed {
+		return Return{}, fmt.Errorf("%w: заявка завершается через CompleteReturn", returns.ErrTransition)
+	}
+	return m.changeReturn(id, to, func(r *Return) {})
+}
+
+func (m *Memory) CompleteReturn(ctx context.Context, id int, refund float64, restock bool) (Return, error) {
+	return m.changeReturn(id, returns.Completed, func(r *Return) {
+		r.Refund, r.Restocked = refund, restock
+		if !restock {
+			return
+		}
+		order, _ := m.order(r.OrderID)
+		var items []OrderItem
+		for _, line := range r.Lines {
+			for _, item := range order.Items {
+				if item.ID == line.OrderItemID {
+					item.Quantity = line.Quantity
+					items = append(items, item)
+				}
+			}
+		}
+		m.restock(items)
+	})
+}
+
+func (m *Memory) ReserveReturnRefund(ctx context.Context, id, paymentID int) (Refund, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.returns, func(r Return) bool { return r.ID == id })
+	if i < 0 {
+		return Refund{}, ErrNotFound
+	}
+	if err := returns.Transition(m.returns[i].Status, returns.Completed); err != nil {
+		return Refund{}, err
+	}
+	return m.reserveRefund(paymentID, returnRefundKey(id), m.returns[i].Total())
+}
+
+func (m *Memory) changeReturn(id int, to returns.Status, change func(r *Return)) (Return, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.returns, func(r Return) bool { return r.ID == id })
+	if i < 0 {
+		return Return{}, ErrNotFound
+	}
+	r := m.returns[i]
+	if err := returns.Transition(r.Status, to); err != nil {
+		return r, err
+	}
+	change(&r)
+	r.Status, r.UpdatedAt = to, time.Now()
+	m.returns[i] = r
+	return r, nil
+}
+
+// memoryJob — задание и его ключ, который в Job не выдаётся.
+type memoryJob struct {
+	Job
+	key string
+}
+
+func (m *Memory) EnqueueJobs(ctx context.Context, list ...jobs.New) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enqueueJobs(list)
+	return nil
+}
+
+func (m *Memory) enqueueJobs(list []jobs.New) {
+	now := time.Now()
+	for _, job := range list {
+		if job.Key != "" && slices.ContainsFunc(m.jobs, func(j memoryJob) bool {
+			return j.Kind == job.Kind && j.key == job.Key && (j.Status == jobs.Pending || j.Status == jobs.Running)
+		}) {
+			continue
+		}
+		stored := memoryJob{key: job.Key, Job: Job{
+			ID:          m.nextID(),
+			Kind:        job.Kind,
+			Payload:     slices.Clone(job.Payload),
+			Status:      jobs.Pending,
+			MaxAttempts: job.MaxAttempts,
+			RunAt:       now.Add(job.Delay),
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}}
+		if stored.Payload == nil {
+			stored.Payload = json.RawMessage("{}")
+		}
+		if stored.MaxAttempts == 0 {
+			stored.MaxAttempts = jobs.DefaultMaxAttempts
+		}
+		m.jobs = append(m.jobs, stored)
+	}
+}
+
+func (m *Memory) ClaimJobs(ctx context.Context, limit int, lease time.Duration) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var list []Job
+	for i := range m.jobs {
+		job := &m.jobs[i]
+		if len(list) == limit {
+			break
+		}
+		if (job.Status != jobs.Pending && job.Status != jobs.Running) || job.RunAt.After(now) {
+			continue
+		}
+		job.Status, job.Attempts, job.RunAt, job.UpdatedAt = jobs.Running, job.Attempts+1, now.Add(lease), now
+		list = append(list, job.Job)
+	}
+	return list, nil
+}
+
+func (m *Memory) CompleteJob(ctx context.Context, id int) error {
+	m.changeJob(id, jobs.Running, func(job *Job) {
+		job.Status, job.LastError = jobs.Done, ""
+	})
+	return nil
+}
+
+func (m *Memory) FailJob(ctx context.Context, id int, reason string, retryIn time.Duration) error {
+	m.changeJob(id, jobs.Running, func(job *Job) {
+		job.Status, job.LastError, job.RunAt = jobs.Pending, reason, time.Now().Add(retryIn)
+		if retryIn == 0 {
+			job.Status = jobs.Dead
+		}
+	})
+	return nil
+}
+
+func (m *Memory) ListJobs(ctx context.Context, status jobs.Status, limit int) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var list []Job
+	for i := len(m.jobs) - 1; i >= 0; i-- {
+		if m.jobs[i].Status == status {
+			list = append(list, m.jobs[i].Job)
+		}
+	}
+	slices.SortStableFunc(list, func(a, b Job) int { return b.UpdatedAt.Compare(a.UpdatedAt) })
+	return list[:min(len(list), limit)], nil
+}
+
+func (m *Memory) RetryJob(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.jobs, func(j memoryJob) bool { return j.ID == id && j.Status == jobs.Dead })
+	if i < 0 {
+		return ErrNotFound
+	}
+	job := m.jobs[i]
+	if job.key != "" && slices.ContainsFunc(m.jobs, func(j memoryJob) bool {
+		return j.Kind == job.Kind && j.key == job.key && (j.Status == jobs.Pending || j.Status == jobs.Running)
+	}) {
+		return ErrConflict
+	}
+	now := time.Now()
+	job.Status, job.Attempts, job.RunAt, job.UpdatedAt = jobs.Pending, 0, now, now
+	m.jobs[i] = job
+	return nil
+}
+
+func (m *Memory) DeleteJobs(ctx context.Context, age time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before := time.Now().Add(-age)
+	n := len(m.jobs)
+	m.jobs = slices.DeleteFunc(m.jobs, func(j memoryJob) bool {
+		return j.Status == jobs.Done && j.UpdatedAt.Before(before)
+	})
+	return n - len(m.jobs), nil
+}
+
+// changeJob применяет change к заданию id, если оно в статусе from.
+func (m *Memory) changeJob(id int, from jobs.Status, change func(job *Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.jobs, func(j memoryJob) bool { return j.ID == id && j.Status == from })
+	if i >= 0 {
+		change(&m.jobs[i].Job)
+		m.jobs[i].UpdatedAt = time.Now()
+	}
+}
+
+// Jobs возвращает все задания в порядке постановки.
+func (m *Memory) Jobs() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]Job, len(m.jobs))
+	for i, job := range m.jobs {
+		list[i] = job.Job
+	}
+	return list
+}
+
+type memoryReset struct {
+	tokenHash string
+	userID    int
+	expiresAt time.Time
+	used      bool
+}
+
+func (m *Memory) CreatePasswordReset(ctx context.Context, userID int, tokenHash string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return ErrNotFound
+	}
+	m.resets = append(m.resets, memoryReset{tokenHash: tokenHash, userID: userID, expiresAt: time.Now().Add(ttl)})
+	return nil
+}
+
+// passwordReset возвращает индекс действующего токена; вызывается под m.mu.
+func (m *Memory) passwordReset(tokenHash string) (int, error) {
+	for i, r := range m.resets {
+		if r.tokenHash == tokenHash {
+			if r.used || !time.Now().Before(r.expiresAt) {
+				break
+			}
+			if _, ok := m.users[r.userID]; !ok {
+				break
+			}
+			return i, nil
+		}
+	}
+	return 0, ErrNotFound
+}
+
+func (m *Memory) PasswordResetUser(ctx context.Context, tokenHash string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, err := m.passwordReset(tokenHash)
+	if err != nil {
+		return User{}, err
+	}
+	return m.users[m.resets[i].userID], nil
+}
+
+func (m *Memory) ResetPassword(ctx context.Context, tokenHash string, hash []byte) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, err := m.passwordReset(tokenHash)
+	if err != nil {
+		return User{}, err
+	}
+	userID := m.resets[i].userID
+	for j := range m.resets {
+		if m.resets[j].userID == userID {
+			m.resets[j].used = true
+		}
+	}
+	user := m.users[userID]
+	user.PasswordHash = hash
+	user.SessionVersion++
+	m.users[userID] = user
+	return user, nil
+}
+
+func (m *Memory) DeletePasswordResets(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	n := len(m.resets)
+	m.resets = slices.DeleteFunc(m.resets, func(r memoryReset) bool {
+		return r.used || !now.Before(r.expiresAt)
+	})
+	return n - len(m.resets), nil
+}
@@ -0,0 +1,1800 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"golangify.com/snippetbox/catalog"
+	"golangify.com/snippetbox/jobs"
+	"golangify.com/snippetbox/orders"
+	"golangify.com/snippetbox/payments"
+	"golangify.com/snippetbox/products"
+	"golangify.com/snippetbox/returns"
+	"golangify.com/snippetbox/roles"
+)
+
+// Postgres реализует все репозитории поверх PostgreSQL.
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+// uniqueViolation переводит нарушение уникального ключа в ErrConflict.
+func uniqueViolation(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrConflict
+	}
+	return err
+}
+
+// nullPrice сохраняет нулевую цену варианта как NULL — «цена товара».
+func nullPrice(price float64) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: price, Valid: price > 0}
+}
+
+func (p *Postgres) LoadCatalog(ctx context.Context) (*catalog.Snapshot, error) {
+	snapshot := &catalog.Snapshot{
+		Clothes:     map[string]products.Clothing{},
+		Accessories: map[string]products.Accessory{},
+	}
+
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT id, name, description, image_url, image_thumb_url, image_card_url, image_zoom_url,
+			price, material, type, season
+		FROM clothes`)
+	if err != nil {
+		return nil, fmt.Errorf("загрузка одежды: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var clothing products.Clothing
+		if err := rows.Scan(&clothing.ID, &clothing.Name, &clothing.Description, &clothing.ImageURL,
+			&clothing.ImageSizes.Thumb, &clothing.ImageSizes.Card, &clothing.ImageSizes.Zoom, &clothing.Price,
+			&clothing.Material, &clothing.Type, &clothing.Season); err != nil {
+			return nil, fmt.Errorf("сканирование строки одежды: %w", err)
+		}
+
+		clothing.ImageURL = strings.Replace(clothing.ImageURL, "\\", "/", -1)
+		snapshot.Clothes[clothing.ID] = clothing
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("загрузка одежды: %w", err)
+	}
+
+	rowsVariants, err := p.db.QueryContext(ctx, "SELECT id, clothing_id, size, color, sku, stock, price FROM product_variants ORDER BY clothing_id, id")
+	if err != nil {
+		return nil, fmt.Errorf("загрузка вариантов одежды: %w", err)
+	}
+	defer rowsVariants.Close()
+
+	for rowsVariants.Next() {
+		var variant products.Variant
+		var clothingID string
+		var price sql.NullFloat64
+		if err := rowsVariants.Scan(&variant.ID, &clothingID, &variant.Size, &variant.Color, &variant.SKU,
+			&variant.Stock, &price); err != nil {
+			return nil, fmt.Errorf("сканирование варианта одежды: %w", err)
+		}
+		variant.PriceOverride = price.Float64
+
+		if clothing, exists := snapshot.Clothes[clothingID]; exists {
+			clothing.Variants = append(clothing.Variants, variant)
+			snapshot.Clothes[clothingID] = clothing
+		}
+	}
+	if err := rowsVariants.Err(); err != nil {
+		return nil, fmt.Errorf("загрузка вариантов одежды: %w", err)
+	}
+
+	rowsAccessories, err := p.db.QueryContext(ctx, `
+		SELECT id, name, description, image_url, image_thumb_url, image_card_url, image_zoom_url,
+			price, type, color, material, target, stock
+		FROM accessories`)
+	if err != nil {
+		return nil, fmt.Errorf("загрузка аксессуаров: %w", err)
+	}
+	defer rowsAccessories.Close()
+
+	for rowsAccessories.Next() {
+		var accessory products.Accessory
+		if err := rowsAccessories.Scan(&accessory.ID, &accessory.Name, &accessory.Description, &accessory.ImageURL,
+			&accessory.ImageSizes.Thumb, &accessory.ImageSizes.Card, &accessory.ImageSizes.Zoom, &accessory.Price,
+			&accessory.Type, &accessory.Color, &accessory.Material, &accessory.Target, &accessory.Stock); err != nil {
+			return nil, fmt.Errorf("сканирование строки аксессуара: %w", err)
+		}
+
+		accessory.ImageURL = strings.Replace(accessory.ImageURL, "\\", "/", -1)
+		snapshot.Accessories[accessory.ID] = accessory
+	}
+	if err := rowsAccessories.Err(); err != nil {
+		return nil, fmt.Errorf("загрузка аксессуаров: %w", err)
+	}
+
+	rowsImages, err := p.db.QueryContext(ctx, `
+		SELECT id, clothing_id, accessory_id, url, thumb_url, card_url, zoom_url, alt
+		FROM product_images
+		ORDER BY sort_order, id`)
+	if err != nil {
+		return nil, fmt.Errorf("загрузка фотографий товаров: %w", err)
+	}
+	defer rowsImages.Close()
+
+	for rowsImages.Next() {
+		var image products.Image
+		var clothingID, accessoryID sql.NullString
+		if err := rowsImages.Scan(&image.ID, &clothingID, &accessoryID, &image.URL,
+			&image.Sizes.Thumb, &image.Sizes.Card, &image.Sizes.Zoom, &image.Alt); err != nil {
+			return nil, fmt.Errorf("сканирование фотографии товара: %w", err)
+		}
+		image.URL = strings.Replace(image.URL, "\\", "/", -1)
+
+		if clothing, exists := snapshot.Clothes[clothingID.String]; clothingID.Valid && exists {
+			clothing.Images = append(clothing.Images, image)
+			snapshot.Clothes[clothing.ID] = clothing
+		}
+		if accessory, exists := snapshot.Accessories[accessoryID.String]; accessoryID.Valid && exists {
+			accessory.Images = append(accessory.Images, image)
+			snapshot.Accessories[accessory.ID] = accessory
+		}
+	}
+	if err := rowsImages.Err(); err != nil {
+		return nil, fmt.Errorf("загрузка фотографий товаров: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// CreateClothing сохраняет товар вместе со всеми вариантами в одной
+// транзакции.
+func (p *Postgres) CreateClothing(ctx context.Context, clothing products.Clothing) (products.Clothing, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return clothing, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO clothes (name, description, price, image_url, image_thumb_url, image_card_url, image_zoom_url,
+			material, type, season)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id`,
+		clothing.Name, clothing.Description, clothing.Price, clothing.ImageURL,
+		clothing.ImageSizes.Thumb, clothing.ImageSizes.Card, clothing.ImageSizes.Zoom,
+		clothing.Material, clothing.Type, clothing.Season).Scan(&clothing.ID)
+	if err != nil {
+		return clothing, err
+	}
+
+	variants := append([]products.Variant(nil), clothing.Variants...)
+	assignSKUs(clothing.ID, variants)
+	for i := range variants {
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO product_variants (clothing_id, size, color, sku, stock, price)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id`,
+			clothing.ID, variants[i].Size, variants[i].Color, variants[i].SKU, variants[i].Stock,
+			nullPrice(variants[i].PriceOverride)).Scan(&variants[i].ID)
+		if err != nil {
+			return clothing, uniqueViolation(err)
+		}
+	}
+	clothing.Variants = variants
+
+	images, err := createCover(ctx, tx, ImageOwner{products.CategoryClothing, clothing.ID}, clothing.ImageURL, clothing.ImageSizes, clothing.Name)
+	if err != nil {
+		return clothing, err
+	}
+	clothing.SetImages(images)
+
+	return clothing, tx.Commit()
+}
+
+// UpdateClothing меняет описание товара, а если передано новое изображение —
+// заменяет им обложку.
+func (p *Postgres) UpdateClothing(ctx context.Context, clothing products.Clothing) (products.Clothing, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return clothing, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE clothes
+		SET name = $1, description = $2, price = $3, material = $4, type = $5, season = $6
+		WHERE id = $7
+		RETURNING id`,
+		clothing.Name, clothing.Description, clothing.Price, clothing.Material, clothing.Type, clothing.Season,
+		clothing.ID).Scan(&clothing.ID)
+	if err == sql.ErrNoRows {
+		return clothing, ErrNotFound
+	}
+	if err != nil {
+		return clothing, err
+	}
+
+	images, err := replaceCover(ctx, tx, ImageOwner{products.CategoryClothing, clothing.ID}, clothing.ImageURL, clothing.ImageSizes, clothing.Name)
+	if err != nil {
+		return clothing, err
+	}
+	clothing.SetImages(images)
+
+	return clothing, tx.Commit()
+}
+
+func (p *Postgres) DeleteClothing(ctx context.Context, id string) ([]string, error) {
+	return p.deleteProduct(ctx, ImageOwner{products.CategoryClothing, id})
+}
+
+func (p *Postgres) AddVariant(ctx context.Context, clothingID string, variant products.Variant) (products.Variant, error) {
+	err := p.db.QueryRowContext(ctx, `
+		INSERT INTO product_variants (clothing_id, size, color, sku, stock, price)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`,
+		clothingID, variant.Size, variant.Color, variant.SKU, variant.Stock, nullPrice(variant.PriceOverride)).Scan(&variant.ID)
+	return variant, uniqueViolation(err)
+}
+
+func (p *Postgres) UpdateVariant(ctx context.Context, variant products.Variant) (string, products.Variant, error) {
+	var clothingID string
+	err := p.db.QueryRowContext(ctx, `
+		UPDATE product_variants SET sku = $1, stock = $2, price = $3
+		WHERE id = $4
+		RETURNING clothing_id, size, color`,
+		variant.SKU, variant.Stock, nullPrice(variant.PriceOverride), variant.ID).Scan(&clothingID, &variant.Size, &variant.Color)
+	if err == sql.ErrNoRows {
+		return "", variant, ErrNotFound
+	}
+	return clothingID, variant, uniqueViolation(err)
+}
+
+func (p *Postgres) DeleteVariant(ctx context.Context, id string) (string, error) {
+	var clothingID string
+	err := p.db.QueryRowContext(ctx, "DELETE FROM product_variants WHERE id = $1 RETURNING clothing_id", id).Scan(&clothingID)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return clothingID, err
+}
+
+func (p *Postgres) CreateAccessory(ctx context.Context, accessory products.Accessory) (products.Accessory, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return accessory, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO accessories (name, description, price, image_url, image_thumb_url, image_card_url, image_zoom_url,
+			type, color, material, target, stock)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id`,
+		accessory.Name, accessory.Description, accessory.Price, accessory.ImageURL,
+		accessory.ImageSizes.Thumb, accessory.ImageSizes.Card, accessory.ImageSizes.Zoom, accessory.Type,
+		accessory.Color, accessory.Material, accessory.Target, accessory.Stock).Scan(&accessory.ID)
+	if err != nil {
+		return accessory, err
+	}
+
+	images, err := createCover(ctx, tx, ImageOwner{products.CategoryAccessory, accessory.ID}, accessory.ImageURL, accessory.ImageSizes, accessory.Name)
+	if err != nil {
+		return accessory, err
+	}
+	accessory.SetImages(images)
+
+	return accessory, tx.Commit()
+}
+
+func (p *Postgres) UpdateAccessory(ctx context.Context, accessory products.Accessory) (products.Accessory, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return accessory, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE accessories
+		SET name = $1, description = $2, price = $3, type = $4, color = $5, material = $6, target = $7, stock = $8
+		WHERE id = $9
+		RETURNING id`,
+		accessory.Name, accessory.Description, accessory.Price, accessory.Type, accessory.Color,
+		accessory.Material, accessory.Target, accessory.Stock, accessory.ID).Scan(&accessory.ID)
+	if err == sql.ErrNoRows {
+		return accessory, ErrNotFound
+	}
+	if err != nil {
+		return accessory, err
+	}
+
+	images, err := replaceCover(ctx, tx, ImageOwner{products.CategoryAccessory, accessory.ID}, accessory.ImageURL, accessory.ImageSizes, accessory.Name)
+	if err != nil {
+		return accessory, err
+	}
+	accessory.SetImages(images)
+
+	return accessory, tx.Commit()
+}
+
+func (p *Postgres) DeleteAccessory(ctx context.Context, id string) ([]string, error) {
+	return p.deleteProduct(ctx, ImageOwner{products.CategoryAccessory, id})
+}
+
+// deleteProduct удаляет товар и возвращает ссылки на его изображения, которые
+// не используются ни другими товарами (одинаковые файлы хранятся один раз),
+// ни заказами.
+func (p *Postgres) deleteProduct(ctx context.Context, owner ImageOwner) ([]string, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	table, column := imageOwnerColumn(owner.Category)
+	urls, err := scanURLs(tx.QueryContext(ctx, `
+		SELECT u FROM `+table+`, unnest(ARRAY[image_url, image_thumb_url, image_card_url, image_zoom_url]) AS u
+		WHERE id = $1
+		UNION
+		SELECT u FROM product_images, unnest(ARRAY[url, thumb_url, card_url, zoom_url]) AS u
+		WHERE `+column+` = $1`, owner.ProductID))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE id = $1", owner.ProductID); err != nil {
+		return nil, err
+	}
+
+	var unused []string
+	for url := range urls {
+		var referenced bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM clothes WHERE $1 IN (image_url, image_thumb_url, image_card_url, image_zoom_url))
+				OR EXISTS (SELECT 1 FROM accessories WHERE $1 IN (image_url, image_thumb_url, image_card_url, image_zoom_url))
+				OR EXISTS (SELECT 1 FROM product_images WHERE $1 IN (url, thumb_url, card_url, zoom_url))
+				OR EXISTS (SELECT 1 FROM order_items WHERE image_url = $1)`, url).Scan(&referenced)
+		if err != nil {
+			return nil, err
+		}
+		if !referenced {
+			unused = append(unused, url)
+		}
+	}
+	sort.Strings(unused)
+	return unused, tx.Commit()
+}
+
+func (p *Postgres) ImageURLs(ctx context.Context) (map[string]bool, error) {
+	return scanURLs(p.db.QueryContext(ctx, `
+		SELECT u FROM clothes, unnest(ARRAY[image_url, image_thumb_url, image_card_url, image_zoom_url]) AS u
+		UNION
+		SELECT u FROM accessories, unnest(ARRAY[image_url, image_thumb_url, image_card_url, image_zoom_url]) AS u
+		UNION
+		SELECT u FROM product_images, unnest(ARRAY[url, thumb_url, card_url, zoom_url]) AS u
+		UNION
+		SELECT image_url FROM order_items`))
+}
+
+// scanURLs собирает непустые ссылки из запроса с одной колонкой.
+func scanURLs(rows *sql.Rows, err error) (map[string]bool, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := map[string]bool{}
+	for rows.Next() {
+		var url sql.NullString
+		if err := rows.Scan(&url); err != nil {
+			return nil, err
+		}
+		if url.String != "" {
+			urls[url.String] = true
+		}
+	}
+	return urls, rows.Err()
+}
+
+func (p *Postgres) SetAccessoryStock(ctx context.Context, id string, stock int) error {
+	_, err := p.db.ExecContext(ctx, "UPDATE accessories SET stock = $1 WHERE id = $2", stock, id)
+	return err
+}
+
+// imageOwnerColumn — таблица товара и колонка product_images, которая на
+// него ссылается.
+func imageOwnerColumn(category string) (table, column string) {
+	if category == products.CategoryAccessory {
+		return "accessories", "accessory_id"
+	}
+	return "clothes", "clothing_id"
+}
+
+func listImages(ctx context.Context, tx *sql.Tx, owner ImageOwner) ([]products.Image, error) {
+	_, column := imageOwnerColumn(owner.Category)
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, url, thumb_url, card_url, zoom_url, alt
+		FROM product_images
+		WHERE `+column+` = $1
+		ORDER BY sort_order, id`, owner.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []products.Image
+	for rows.Next() {
+		var image products.Image
+		if err := rows.Scan(&image.ID, &image.URL, &image.Sizes.Thumb, &image.Sizes.Card, &image.Sizes.Zoom, &image.Alt); err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, rows.Err()
+}
+
+// syncImages перечитывает фотографии товара и переписывает по первой из них
+// обложку в строке товара.
+func syncImages(ctx context.Context, tx *sql.Tx, owner ImageOwner) ([]products.Image, error) {
+	images, err := listImages(ctx, tx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	table, _ := imageOwnerColumn(owner.Category)
+	url, sizes := products.Cover(images)
+	_, err = tx.ExecContext(ctx, `
+		UPDATE `+table+`
+		SET image_url = $1, image_thumb_url = $2, image_card_url = $3, image_zoom_url = $4
+		WHERE id = $5`,
+		url, sizes.Thumb, sizes.Card, sizes.Zoom, owner.ProductID)
+	return images, err
+}
+
+// insertImage добавляет фотографию в конец галереи товара.
+func insertImage(ctx context.Context, tx *sql.Tx, owner ImageOwner, image products.Image) error {
+	_, column := imageOwnerColumn(owner.Category)
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO product_images (`+column+`, url, thumb_url, card_url, zoom_url, alt, sort_order)
+		SELECT $1, $2, $3, $4, $5, $6, COALESCE(MAX(sort_order) + 1, 0)
+		FROM product_images
+		WHERE `+column+` = $1`,
+		owner.ProductID, image.URL, image.Sizes.Thumb, image.Sizes.Card, image.Sizes.Zoom, image.Alt)
+	return err
+}
+
+// createCover сохраняет изображение нового товара первой фотографией.
+func createCover(ctx context.Context, tx *sql.Tx, owner ImageOwner, url string, sizes products.ImageSizes, alt string) ([]products.Image, error) {
+	if url != "" {
+		if err := insertImage(ctx, tx, owner, products.Image{URL: url, Sizes: sizes, Alt: alt}); err != nil {
+			return nil, err
+		}
+	}
+	return syncImages(ctx, tx, owner)
+}
+
+// replaceCover заменяет файл первой фотографии товара, сохраняя её место и
+// подпись. Пустой url оставляет галерею как есть.
+func replaceCover(ctx context.Context, tx *sql.Tx, owner ImageOwner, url string, sizes products.ImageSizes, alt string) ([]products.Image, error) {
+	if url == "" {
+		return listImages(ctx, tx, owner)
+	}
+
+	_, column := imageOwnerColumn(owner.Category)
+	res, err := tx.ExecContext(ctx, `
+		UPDATE product_images
+		SET url = $1, thumb_url = $2, card_url = $3, zoom_url = $4
+		WHERE id = (SELECT id FROM product_images WHERE `+column+` = $5 ORDER BY sort_order, id LIMIT 1)`,
+		url, sizes.Thumb, sizes.Card, sizes.Zoom, owner.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return createCover(ctx, tx, owner, url, sizes, alt)
+	}
+	return syncImages(ctx, tx, owner)
+}
+
+func (p *Postgres) AddImage(ctx context.Context, owner ImageOwner, image products.Image) ([]products.Image, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Блокировка строки товара упорядочивает одновременные изменения галереи.
+	table, _ := imageOwnerColumn(owner.Category)
+	var id string
+	err = tx.QueryRowContext(ctx, "SELECT id FROM "+table+" WHERE id = $1 FOR UPDATE", owner.ProductID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := insertImage(ctx, tx, owner, image); err != nil {
+		return nil, err
+	}
+	images, err := syncImages(ctx, tx, owner)
+	if err != nil {
+		return nil, err
+	}
+	return images, tx.Commit()
+}
+
+// changeImage выполняет change над фотографией id в транзакции и обновляет
+// обложку её товара.
+func (p *Postgres) changeImage(ctx context.Context, id string, change func(tx *sql.Tx, owner ImageOwner) error) (ImageOwner, []products.Image, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ImageOwner{}, nil, err
+	}
+	defer tx.Rollback()
+
+	var clothingID, accessoryID sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT clothing_id, accessory_id FROM product_images WHERE id = $1", id).Scan(&clothingID, &accessoryID)
+	if err == sql.ErrNoRows {
+		return ImageOwner{}, nil, ErrNotFound
+	}
+	if err != nil {
+		return ImageOwner{}, nil, err
+	}
+	owner := ImageOwner{products.CategoryClothing, clothingID.String}
+	if accessoryID.Valid {
+		owner = ImageOwner{products.CategoryAccessory, accessoryID.String}
+	}
+
+	table, _ := imageOwnerColumn(owner.Category)
+	if _, err := tx.ExecContext(ctx, "SELECT id FROM "+table+" WHERE id = $1 FOR UPDATE", owner.ProductID); err != nil {
+		return owner, nil, err
+	}
+	if err := change(tx, owner); err != nil {
+		return owner, nil, err
+	}
+
+	images, err := syncImages(ctx, tx, owner)
+	if err != nil {
+		return owner, nil, err
+	}
+	return owner, images, tx.Commit()
+}
+
+func (p *Postgres) UpdateImageAlt(ctx context.Context, id, alt string) (ImageOwner, []products.Image, error) {
+	return p.changeImage(ctx, id, func(tx *sql.Tx, owner ImageOwner) error {
+		_, err := tx.ExecContext(ctx, "UPDATE product_images SET alt = $1 WHERE id = $2", alt, id)
+		return err
+	})
+}
+
+func (p *Postgres) SetImageSizes(ctx context.Context, id string, sizes products.ImageSizes) (ImageOwner, []products.Image, error) {
+	return p.changeImage(ctx, id, func(tx *sql.Tx, owner ImageOwner) error {
+		_, err := tx.ExecContext(ctx, "UPDATE product_images SET thumb_url = $1, card_url = $2, zoom_url = $3 WHERE id = $4",
+			sizes.Thumb, sizes.Card, sizes.Zoom, id)
+		return err
+	})
+}
+
+func (p *Postgres) MoveImage(ctx context.Context, id string, delta int) (ImageOwner, []products.Image, error) {
+	return p.changeImage(ctx, id, func(tx *sql.Tx, owner ImageOwner) error {
+		images, err := listImages(ctx, tx, owner)
+		if err != nil {
+			return err
+		}
+		images = moveImage(images, id, delta)
+		for i, image := range images {
+			if _, err := tx.ExecContext(ctx, "UPDATE product_images SET sort_order = $1 WHERE id = $2", i, image.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *Postgres) DeleteImage(ctx context.Context, id string) (ImageOwner, []products.Image, error) {
+	return p.changeImage(ctx, id, func(tx *sql.Tx, owner ImageOwner) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM product_images WHERE id = $1", id)
+		return err
+	})
+}
+
+func (p *Postgres) CreateUser(ctx context.Context, user User) (User, error) {
+	err := p.db.QueryRowContext(ctx,
+		"INSERT INTO users (username, password, email, role) VALUES ($1, $2, $3, $4) RETURNING id, session_version",
+		user.Username, user.PasswordHash, user.Email, user.Role).Scan(&user.ID, &user.SessionVersion)
+	return user, uniqueViolation(err)
+}
+
+func (p *Postgres) UserByID(ctx context.Context, id int) (User, error) {
+	user := User{ID: id}
+	err := p.db.QueryRowContext(ctx, "SELECT username, password, email, role, session_version FROM users WHERE id = $1",
+		id).Scan(&user.Username, &user.PasswordHash, &user.Email, &user.Role, &user.SessionVersion)
+	if err == sql.ErrNoRows {
+		return user, ErrNotFound
+	}
+	return user, err
+}
+
+func (p *Postgres) UserByUsername(ctx context.Context, username string) (User, error) {
+	user := User{Username: username}
+	err := p.db.QueryRowContext(ctx, "SELECT id, password, email, role, session_version FROM users WHERE username = $1",
+		username).Scan(&user.ID, &user.PasswordHash, &user.Email, &user.Role, &user.SessionVersion)
+	if err == sql.ErrNoRows {
+		return user, ErrNotFound
+	}
+	return user, err
+}
+
+func (p *Postgres) UsersByEmail(ctx context.Context, email string) ([]User, error) {
+	rows, err := p.db.QueryContext(ctx,
+		"SELECT id, username, email, role, session_version FROM users WHERE lower(email) = lower($1) ORDER BY id", email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.SessionVersion); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (p *Postgres) Users(ctx context.Context) ([]User, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT id, username, email, role FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Role); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// updateUser выполняет UPDATE одного пользователя и сообщает ErrNotFound,
+// если такого пользователя нет.
+func (p *Postgres) updateUser(ctx context.Context, query string, args ...any) error {
+	res, err := p.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) SetRole(ctx context.Context, username, role string) error {
+	return p.updateUser(ctx, "UPDATE users SET role = $1 WHERE username = $2", role, username)
+}
+
+func (p *Postgres) SetPassword(ctx context.Context, username string, hash []byte) error {
+	return p.updateUser(ctx,
+		"UPDATE users SET password = $1, session_version = session_version + 1 WHERE username = $2", hash, username)
+}
+
+func (p *Postgres) RolePermissions(ctx context.Context, role string) ([]roles.Permission, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT permission FROM role_permissions WHERE role = $1 ORDER BY permission", role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []roles.Permission
+	for rows.Next() {
+		var permission roles.Permission
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+func (p *Postgres) Cart(ctx context.Context, userID int) (map[CartItem]int, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT category, product_id, variant_id, quantity FROM cart_items WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cart := map[CartItem]int{}
+	for rows.Next() {
+		var item CartItem
+		var quantity int
+		if err := rows.Scan(&item.Category, &item.ProductID, &item.VariantID, &quantity); err != nil {
+			return nil, err
+		}
+		cart[item] = quantity
+	}
+	return cart, rows.Err()
+}
+
+func (p *Postgres) SetCartQuantity(ctx context.Context, userID int, item CartItem, quantity int) error {
+	var err error
+	if quantity <= 0 {
+		_, err = p.db.ExecContext(ctx, `
+			DELETE FROM cart_items
+			WHERE user_id = $1 AND category = $2 AND product_id = $3 AND variant_id = $4`,
+			userID, item.Category, item.ProductID, item.VariantID)
+	} else {
+		_, err = p.db.ExecContext(ctx, `
+			INSERT INTO cart_items (user_id, category, product_id, variant_id, quantity)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (user_id, category, product_id, variant_id) DO UPDATE SET quantity = EXCLUDED.quantity`,
+			userID, item.Category, item.ProductID, item.VariantID, quantity)
+	}
+	return err
+}
+
+func (p *Postgres) AddToCart(ctx context.Context, userID int, item CartItem, quantity int) error {
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO cart_items (user_id, category, product_id, variant_id, quantity)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, category, product_id, variant_id) DO UPDATE SET quantity = cart_items.quantity + EXCLUDED.quantity`,
+		userID, item.Category, item.ProductID, item.VariantID, quantity)
+	return err
+}
+
+// reserveProduct читает актуальные название, изображение и цену товара и
+// списывает количество со склада. Строка с остатком блокируется до конца
+// транзакции, так что два одновременных заказа не продадут последний
+// экземпляр дважды. Для одежды остаток и цена берутся из выбранного варианта.
+func reserveProduct(ctx context.Context, tx *sql.Tx, line OrderLine) (OrderItem, error) {
+	item := OrderItem{CartItem: line.Item, Quantity: line.Quantity}
+	var size, color, sku sql.NullString
+	var stock int
+	var err error
+
+	switch line.Item.Category {
+	case products.CategoryClothing:
+		var variantPrice sql.NullFloat64
+		err = tx.QueryRowContext(ctx, `
+			SELECT c.name, COALESCE(c.image_url, ''), c.price, v.price, v.stock, v.size, v.color, v.sku
+			FROM product_variants v
+			JOIN clothes c ON c.id = v.clothing_id
+			WHERE v.id = $1 AND v.clothing_id = $2
+			FOR UPDATE OF v`,
+			line.Item.VariantID, line.Item.ProductID).Scan(&item.Name, &item.ImageURL, &item.Price, &variantPrice,
+			&stock, &size, &color, &sku)
+		if variantPrice.Valid {
+			item.Price = variantPrice.Float64
+		}
+	case products.CategoryAccessory:
+		err = tx.QueryRowContext(ctx, "SELECT name, COALESCE(image_url, ''), price, stock FROM accessories WHERE id = $1 FOR UPDATE",
+			line.Item.ProductID).Scan(&item.Name, &item.ImageURL, &item.Price, &stock)
+	default:
+		return item, ErrProductUnavailable
+	}
+	if err == sql.ErrNoRows {
+		return item, ErrProductUnavailable
+	}
+	if err != nil {
+		return item, err
+	}
+	item.Size, item.Color, item.SKU = size.String, color.String, sku.String
+
+	if stock < line.Quantity {
+		return item, &OutOfStockError{Name: item.Name, Available: stock}
+	}
+
+	if line.Item.Category == products.CategoryClothing {
+		_, err = tx.ExecContext(ctx, "UPDATE product_variants SET stock = stock - $1 WHERE id = $2", line.Quantity, line.Item.VariantID)
+	} else {
+		_, err = tx.ExecContext(ctx, "UPDATE accessories SET stock = stock - $1 WHERE id = $2", line.Quantity, line.Item.ProductID)
+	}
+	return item, err
+}
+
+// PlaceOrder сохраняет заказ и его позиции. Название и цена каждой позиции
+// копируются в order_items, поэтому последующие правки и удаления товаров
+// не меняют историю заказов.
+func (p *Postgres) PlaceOrder(ctx context.Context, newOrder NewOrder) (Order, error) {
+	order := Order{PublicID: newPublicID(), UserID: newOrder.UserID, Customer: newOrder.Customer}
+
+	var userID sql.NullInt64
+	if newOrder.UserID != 0 {
+		userID = sql.NullInt64{Int64: int64(newOrder.UserID), Valid: true}
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return order, err
+	}
+	defer tx.Rollback()
+
+	customer := newOrder.Customer
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO orders (first_name, last_name, middle_name, phone, region, city, street, house, apartment,
+			email, status, public_id, user_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id, created_at, status_changed_at`,
+		customer.FirstName, customer.LastName, customer.MiddleName, customer.Phone,
+		customer.Region, customer.City, customer.Street, customer.House, customer.Apartment,
+		customer.Email, orders.New, order.PublicID, userID).Scan(&order.ID, &order.CreatedAt, &order.StatusChangedAt)
+	if err != nil {
+		return order, err
+	}
+	order.Status = orders.New
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO order_status_history (order_id, to_status, changed_at) VALUES ($1, $2, $3)",
+		order.ID, order.Status, order.StatusChangedAt)
+	if err != nil {
+		return order, err
+	}
+	order.History = []StatusChange{{To: order.Status, ChangedAt: order.StatusChangedAt}}
+
+	for _, line := range newOrder.Lines {
+		item, err := reserveProduct(ctx, tx, line)
+		if err != nil {
+			return order, err
+		}
+
+		var variantID sql.NullString
+		if item.Category == products.CategoryClothing {
+			variantID = sql.NullString{String: item.VariantID, Valid: true}
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO order_items (order_id, category, product_id, variant_id, product_name, image_url, size, color, sku, unit_price, quantity)
+			VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), $10, $11)
+			RETURNING id`,
+			order.ID, item.Category, item.ProductID, variantID, item.Name, item.ImageURL,
+			item.Size, item.Color, item.SKU, item.Price, item.Quantity).Scan(&item.ID)
+		if err != nil {
+			return order, err
+		}
+		order.Items = append(order.Items, item)
+		order.Total += item.Price * float64(item.Quantity)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE orders SET total = $1 WHERE id = $2", order.Total, order.ID); err != nil {
+		return order, err
+	}
+
+	if newOrder.UserID != 0 {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM cart_items WHERE user_id = $1", newOrder.UserID); err != nil {
+			return order, err
+		}
+	}
+	orderJobs, err := orderJobs(newOrder.Jobs, order.ID)
+	if err != nil {
+		return order, err
+	}
+	if err := enqueueJobs(ctx, tx, orderJobs...); err != nil {
+		return order, err
+	}
+	return order, tx.Commit()
+}
+
+// orderColumns — колонки заказа в порядке, который ожидает scanOrder.
+const orderColumns = `id, first_name, last_name, COALESCE(middle_name, ''), phone, region, city, street, house,
+	COALESCE(apartment, ''), email, total, created_at, status, status_changed_at, public_id, COALESCE(user_id, 0)`
+
+func scanOrder(row interface{ Scan(...any) error }) (Order, error) {
+	var order Order
+	var createdAt sql.NullTime
+	c := &order.Customer
+	err := row.Scan(&order.ID, &c.FirstName, &c.LastName, &c.MiddleName, &c.Phone, &c.Region, &c.City,
+		&c.Street, &c.House, &c.Apartment, &c.Email, &order.Total, &createdAt, &order.Status, &order.StatusChangedAt,
+		&order.PublicID, &order.UserID)
+	order.CreatedAt = createdAt.Time
+	return order, err
+}
+
+func (p *Postgres) ListOrders(ctx context.Context, filter OrderFilter) ([]Order, error) {
+	var from, to sql.NullTime
+	if !filter.From.IsZero() {
+		from = sql.NullTime{Time: filter.From, Valid: true}
+	}
+	if !filter.To.IsZero() {
+		to = sql.NullTime{Time: filter.To, Valid: true}
+	}
+
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT `+orderColumns+`
+		FROM orders
+		WHERE ($1 = '' OR status = $1)
+			AND ($2::timestamp IS NULL OR created_at >= $2)
+			AND ($3::timestamp IS NULL OR created_at < $3)
+			AND ($4 = '' OR regexp_replace(phone, '\D', '', 'g') LIKE '%' || $4 || '%')
+			AND ($5 = 0 OR user_id = $5)
+		ORDER BY created_at DESC NULLS LAST, id DESC
+		LIMIT NULLIF($6, 0)`,
+		filter.Status, from, to, phoneDigits(filter.Phone), filter.UserID, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, order)
+	}
+	return list, rows.Err()
+}
+
+func (p *Postgres) OrderByID(ctx context.Context, id int) (Order, error) {
+	tx, err := p.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return Order{}, err
+	}
+	defer tx.Rollback()
+
+	order, err := loadOrder(ctx, tx, id, false)
+	if err != nil {
+		return order, err
+	}
+	return order, tx.Commit()
+}
+
+func (p *Postgres) OrderByPublicID(ctx context.Context, publicID string) (Order, error) {
+	tx, err := p.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return Order{}, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx, "SELECT id FROM orders WHERE public_id = $1", publicID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return Order{}, ErrNotFound
+	}
+	if err != nil {
+		return Order{}, err
+	}
+
+	order, err := loadOrder(ctx, tx, id, false)
+	if err != nil {
+		return order, err
+	}
+	return order, tx.Commit()
+}
+
+// loadOrder читает заказ с позициями и историей; forUpdate блокирует его до
+// конца транзакции.
+func loadOrder(ctx context.Context, tx *sql.Tx, id int, forUpdate bool) (Order, error) {
+	query := "SELECT " + orderColumns + " FROM orders WHERE id = $1"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	order, err := scanOrder(tx.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return order, ErrNotFound
+	}
+	if err != nil {
+		return order, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, category, product_id, COALESCE(variant_id::text, ''), product_name, COALESCE(image_url, ''),
+			COALESCE(size, ''), COALESCE(color, ''), COALESCE(sku, ''), unit_price, quantity
+		FROM order_items
+		WHERE order_id = $1
+		ORDER BY id`, id)
+	if err != nil {
+		return order, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item OrderItem
+		if err := rows.Scan(&item.ID, &item.Category, &item.ProductID, &item.VariantID, &item.Name, &item.ImageURL,
+			&item.Size, &item.Color, &item.SKU, &item.Price, &item.Quantity); err != nil {
+			return order, err
+		}
+		order.Items = append(order.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return order, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+		SELECT COALESCE(h.from_status, ''), h.to_status, COALESCE(u.username, ''), h.comment, h.changed_at
+		FROM order_status_history h
+		LEFT JOIN users u ON u.id = h.changed_by
+		WHERE h.order_id = $1
+		ORDER BY h.changed_at, h.id`, id)
+	if err != nil {
+		return order, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var change StatusChange
+		if err := rows.Scan(&change.From, &change.To, &change.ChangedBy, &change.Comment, &change.ChangedAt); err != nil {
+			return order, err
+		}
+		order.History = append(order.History, change)
+	}
+	if err := rows.Err(); err != nil {
+		return order, err
+	}
+
+	payment, err := scanPayment(tx.QueryRowContext(ctx,
+		"SELECT "+paymentColumns+" FROM payments WHERE order_id = $1 ORDER BY id DESC LIMIT 1", id))
+	if err == sql.ErrNoRows {
+		return order, nil
+	}
+	if err != nil {
+		return order, err
+	}
+	order.Payment = &payment
+	return order, nil
+}
+
+func (p *Postgres) SetOrderStatus(ctx context.Context, id int, to orders.Status, userID int, comment string) (Order, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Order{}, err
+	}
+	defer tx.Rollback()
+
+	order, err := loadOrder(ctx, tx, id, true)
+	if err != nil {
+		return order, err
+	}
+	if err := orders.Transition(order.Status, to); err != nil {
+		return order, err
+	}
+	if order.Payment != nil {
+		var pending bool
+		err := tx.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM refunds WHERE payment_id = $1 AND NOT settled AND idempotency_key <> $2)",
+			order.Payment.ID, orderRefundKey(id, to)).Scan(&pending)
+		if err != nil {
+			return order, err
+		}
+		if pending {
+			return order, ErrRefundPending
+		}
+	}
+
+	if orders.Restocks(to) {
+		if err := restock(ctx, tx, orderItems, id); err != nil {
+			return order, err
+		}
+	}
+
+	var changedBy sql.NullInt64
+	if userID != 0 {
+		changedBy = sql.NullInt64{Int64: int64(userID), Valid: true}
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO order_status_history (order_id, from_status, to_status, changed_by, comment)
+		VALUES ($1, $2, $3, $4, $5)`,
+		id, order.Status, to, changedBy, comment)
+	if err != nil {
+		return order, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE orders SET status = $1, status_changed_at = CURRENT_TIMESTAMP WHERE id = $2", to, id); err != nil {
+		return order, err
+	}
+
+	order, err = loadOrder(ctx, tx, id, false)
+	if err != nil {
+		return order, err
+	}
+	return order, tx.Commit()
+}
+
+func (p *Postgres) ReserveOrderRefund(ctx context.Context, id int, to orders.Status) (Refund, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Refund{}, err
+	}
+	defer tx.Rollback()
+
+	order, err := loadOrder(ctx, tx, id, true)
+	if err != nil {
+		return Refund{}, err
+	}
+	if err := orders.Transition(order.Status, to); err != nil {
+		return Refund{}, err
+	}
+	payment := order.Payment
+	if !orders.Refunds(to) || payment == nil || payment.State != payments.Paid {
+		return Refund{}, nil
+	}
+	refund, err := reserveRefund(ctx, tx, payment.ID, orderRefundKey(id, to), payment.Amount)
+	if err != nil {
+		return refund, err
+	}
+	return refund, tx.Commit()
+}
+
+// Позиции, которые restock возвращает на склад: все позиции заказа или
+// позиции заявки на возврат. Запрос получает ID заказа или заявки в $1.
+const (
+	orderItems  = "SELECT category, product_id, variant_id, quantity FROM order_items WHERE order_id = $1"
+	returnItems = `SELECT oi.category, oi.product_id, oi.variant_id, ri.quantity
+		FROM return_items ri JOIN order_items oi ON oi.id = ri.order_item_id
+		WHERE ri.return_id = $1`
+)
+
+// restock возвращает на склад позиции items с ID id. Удалённые с тех пор
+// товары и варианты пропускаются.
+func restock(ctx context.Context, tx *sql.Tx, items string, id int) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE product_variants v
+		SET stock = v.stock + i.quantity
+		FROM (
+			SELECT variant_id, SUM(quantity) AS quantity
+			FROM (`+items+`) items
+			WHERE category = $2
+			GROUP BY variant_id
+		) i
+		WHERE v.id = i.variant_id`, id, products.CategoryClothing)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE accessories a
+		SET stock = a.stock + i.quantity
+		FROM (
+			SELECT product_id, SUM(quantity) AS quantity
+			FROM (`+items+`) items
+			WHERE category = $2
+			GROUP BY product_id
+		) i
+		WHERE a.id = i.product_id`, id, products.CategoryAccessory)
+	return err
+}
+
+const paymentColumns = "id, order_id, provider, external_id, amount, refunded, state, created_at, updated_at"
+
+func scanPayment(row interface{ Scan(...any) error }) (Payment, error) {
+	var payment Payment
+	err := row.Scan(&payment.ID, &payment.OrderID, &payment.Provider, &payment.ExternalID, &payment.Amount,
+		&payment.Refunded, &payment.State, &payment.CreatedAt, &payment.UpdatedAt)
+	return payment, err
+}
+
+func (p *Postgres) CreatePayment(ctx context.Context, payment Payment) (Payment, error) {
+	return scanPayment(p.db.QueryRowContext(ctx, `
+		INSERT INTO payments (order_id, provider, external_id, amount, state)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+paymentColumns,
+		payment.OrderID, payment.Provider, payment.ExternalID, payment.Amount, payment.State))
+}
+
+func (p *Postgres) PaymentByExternalID(ctx context.Context, provider, externalID string) (Payment, error) {
+	payment, err := scanPayment(p.db.QueryRowContext(ctx,
+		"SELECT "+paymentColumns+" FROM payments WHERE provider = $1 AND external_id = $2", provider, externalID))
+	if err == sql.ErrNoRows {
+		return payment, ErrNotFound
+	}
+	return payment, err
+}
+
+func (p *Postgres) ApplyPaymentEvent(ctx context.Context, provider, eventID string, paymentID int, to payments.State) (Payment, bool, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Payment{}, false, err
+	}
+	defer tx.Rollback()
+
+	payment, err := scanPayment(tx.QueryRowContext(ctx,
+		"SELECT "+paymentColumns+" FROM payments WHERE id = $1 FOR UPDATE", paymentID))
+	if err == sql.ErrNoRows {
+		return payment, false, ErrNotFound
+	}
+	if err != nil {
+		return payment, false, err
+	}
+
+	// Повторная доставка уведомления упирается в первичный ключ.
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO payment_events (provider, event_id, payment_id, state)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING`,
+		provider, eventID, paymentID, to)
+	if err != nil {
+		return payment, false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return payment, false, err
+	}
+
+	applied := payments.Transition(payment.State, to) == nil
+	if applied {
+		payment, err = scanPayment(tx.QueryRowContext(ctx, `
+			UPDATE payments SET state = $1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $2
+			RETURNING `+paymentColumns, to, paymentID))
+		if err != nil {
+			return payment, false, err
+		}
+	}
+	return payment, applied, tx.Commit()
+}
+
+// lockPayment блокирует платёж до конца транзакции. Резервы возвратов
+// меняются только под этой блокировкой.
+func lockPayment(ctx context.Context, tx *sql.Tx, id int) (Payment, error) {
+	payment, err := scanPayment(tx.QueryRowContext(ctx,
+		"SELECT "+paymentColumns+" FROM payments WHERE id = $1 FOR UPDATE", id))
+	if err == sql.ErrNoRows {
+		return payment, ErrNotFound
+	}
+	return payment, err
+}
+
+// reserveRefund резервирует возврат amount с платежа paymentID под ключом
+// key.
+func reserveRefund(ctx context.Context, tx *sql.Tx, paymentID int, key string, amount float64) (Refund, error) {
+	payment, err := lockPayment(ctx, tx, paymentID)
+	if err != nil {
+		return Refund{}, err
+	}
+
+	refund := Refund{PaymentID: paymentID, Key: key}
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, amount, settled FROM refunds WHERE idempotency_key = $1", key).Scan(&refund.ID, &refund.Amount, &refund.Settled)
+	if err != sql.ErrNoRows {
+		return refund, err
+	}
+
+	var reserved float64
+	err = tx.QueryRowContext(ctx,
+		"SELECT COALESCE(SUM(amount), 0) FROM refunds WHERE payment_id = $1 AND NOT settled", paymentID).Scan(&reserved)
+	if err != nil {
+		return refund, err
+	}
+	refund.Amount = refundReserve(payment, reserved, amount)
+	if refund.Amount <= 0 {
+		return refund, nil
+	}
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO refunds (payment_id, idempotency_key, amount) VALUES ($1, $2, $3) RETURNING id",
+		paymentID, key, refund.Amount).Scan(&refund.ID)
+	return refund, err
+}
+
+func (p *Postgres) SettleRefund(ctx context.Context, id int) (Payment, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Payment{}, err
+	}
+	defer tx.Rollback()
+
+	var paymentID int
+	err = tx.QueryRowContext(ctx, "SELECT payment_id FROM refunds WHERE id = $1", id).Scan(&paymentID)
+	if err == sql.ErrNoRows {
+		return Payment{}, ErrNotFound
+	}
+	if err != nil {
+		return Payment{}, err
+	}
+	payment, err := lockPayment(ctx, tx, paymentID)
+	if err != nil {
+		return payment, err
+	}
+
+	var amount float64
+	var settled bool
+	err = tx.QueryRowContext(ctx, "SELECT amount, settled FROM refunds WHERE id = $1", id).Scan(&amount, &settled)
+	if err != nil || settled {
+		return payment, err
+	}
+	state, err := refundState(payment, amount)
+	if err != nil {
+		return payment, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE refunds SET settled = true, settled_at = CURRENT_TIMESTAMP WHERE id = $1", id)
+	if err != nil {
+		return payment, err
+	}
+	payment, err = scanPayment(tx.QueryRowContext(ctx, `
+		UPDATE payments SET refunded = LEAST(refunded + $1, amount), state = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+		RETURNING `+paymentColumns, amount, state, paymentID))
+	if err != nil {
+		return payment, err
+	}
+	return payment, tx.Commit()
+}
+
+const returnColumns = "id, order_id, status, reason, comment, refund, restocked, created_at, updated_at"
+
+func scanReturn(row interface{ Scan(...any) error }) (Return, error) {
+	var r Return
+	err := row.Scan(&r.ID, &r.OrderID, &r.Status, &r.Reason, &r.Comment, &r.Refund, &r.Restocked,
+		&r.CreatedAt, &r.UpdatedAt)
+	return r, err
+}
+
+// loadReturn читает заявку с позициями; forUpdate блокирует её до конца
+// транзакции.
+func loadReturn(ctx context.Context, tx *sql.Tx, id int, forUpdate bool) (Return, error) {
+	query := "SELECT " + returnColumns + " FROM returns WHERE id = $1"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	r, err := scanReturn(tx.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return r, ErrNotFound
+	}
+	if err != nil {
+		return r, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT ri.order_item_id, oi.product_name, COALESCE(oi.size, ''), COALESCE(oi.color, ''), oi.unit_price, ri.quantity
+		FROM return_items ri
+		JOIN order_items oi ON oi.id = ri.order_item_id
+		WHERE ri.return_id = $1
+		ORDER BY oi.id`, id)
+	if err != nil {
+		return r, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var line ReturnLine
+		if err := rows.Scan(&line.OrderItemID, &line.Name, &line.Size, &line.Color, &line.Price, &line.Quantity); err != nil {
+			return r, err
+		}
+		r.Lines = append(r.Lines, line)
+	}
+	return r, rows.Err()
+}
+
+func (p *Postgres) CreateReturn(ctx context.Context, newReturn NewReturn) (Return, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Return{}, err
+	}
+	defer tx.Rollback()
+
+	// Блокировка заказа не даёт двум заявкам одновременно вернуть одни и
+	// те же позиции.
+	var status orders.Status
+	err = tx.QueryRowContext(ctx, "SELECT status FROM orders WHERE id = $1 FOR UPDATE", newReturn.OrderID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return Return{}, ErrNotFound
+	}
+	if err != nil {
+		return Return{}, err
+	}
+	if status != orders.Delivered {
+		return Return{}, ErrReturnNotAllowed
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT oi.id, oi.quantity - COALESCE((
+			SELECT SUM(ri.quantity)
+			FROM return_items ri
+			JOIN returns r ON r.id = ri.return_id
+			WHERE ri.order_item_id = oi.id AND r.status <> $2
+		), 0)
+		FROM order_items oi
+		WHERE oi.order_id = $1`, newReturn.OrderID, returns.Rejected)
+	if err != nil {
+		return Return{}, err
+	}
+	defer rows.Close()
+	available := map[int]int{}
+	for rows.Next() {
+		var id, quantity int
+		if err := rows.Scan(&id, &quantity); err != nil {
+			return Return{}, err
+		}
+		available[id] = quantity
+	}
+	if err := rows.Err(); err != nil {
+		return Return{}, err
+	}
+	lines, err := returnLines(newReturn.Quantities, available)
+	if err != nil {
+		return Return{}, err
+	}
+
+	var id int
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO returns (order_id, status, reason, comment)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`,
+		newReturn.OrderID, returns.Requested, newReturn.Reason, newReturn.Comment).Scan(&id)
+	if err != nil {
+		return Return{}, err
+	}
+	for _, line := range lines {
+		_, err := tx.ExecContext(ctx, "INSERT INTO return_items (return_id, order_item_id, quantity) VALUES ($1, $2, $3)",
+			id, line.OrderItemID, line.Quantity)
+		if err != nil {
+			return Return{}, err
+		}
+	}
+
+	r, err := loadReturn(ctx, tx, id, false)
+	if err != nil {
+		return r, err
+	}
+	return r, tx.Commit()
+}
+
+func (p *Postgres) ReturnByID(ctx context.Context, id int) (Return, error) {
+	tx, err := p.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return Return{}, err
+	}
+	defer tx.Rollback()
+
+	r, err := loadReturn(ctx, tx, id, false)
+	if err != nil {
+		return r, err
+	}
+	return r, tx.Commit()
+}
+
+func (p *Postgres) OrderReturns(ctx context.Context, orderID int) ([]Return, error) {
+	tx, err := p.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM returns WHERE order_id = $1 ORDER BY created_at, id", orderID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var list []Return
+	for _, id := range ids {
+		r, err := loadReturn(ctx, tx, id, false)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, tx.Commit()
+}
+
+func (p *Postgres) ListReturns(ctx context.Context, status returns.Status) ([]Return, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT `+returnColumns+`
+		FROM returns
+		WHERE $1 = '' OR status = $1
+		ORDER BY created_at DESC, id DESC`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Return
+	for rows.Next() {
+		r, err := scanReturn(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+func (p *Postgres) SetReturnStatus(ctx context.Context, id int, to returns.Status) (Return, error) {
+	if to == returns.Completed {
+		return Return{}, fmt.Errorf("%w: заявка завершается через CompleteReturn", returns.ErrTransition)
+	}
+	return p.changeReturn(ctx, id, to, func(tx *sql.Tx, r Return) error {
+		_, err := tx.ExecContext(ctx, "UPDATE returns SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", to, id)
+		return err
+	})
+}
+
+func (p *Postgres) CompleteReturn(ctx context.Context, id int, refund float64, restockItems bool) (Return, error) {
+	return p.changeReturn(ctx, id, returns.Completed, func(tx *sql.Tx, r Return) error {
+		if restockItems {
+			if err := restock(ctx, tx, returnItems, id); err != nil {
+				return err
+			}
+		}
+		_, err := tx.ExecContext(ctx, `
+			UPDATE returns SET status = $1, refund = $2, restocked = $3, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $4`,
+			returns.Completed, refund, restockItems, id)
+		return err
+	})
+}
+
+func (p *Postgres) ReserveReturnRefund(ctx context.Context, id, paymentID int) (Refund, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Refund{}, err
+	}
+	defer tx.Rollback()
+
+	r, err := loadReturn(ctx, tx, id, true)
+	if err != nil {
+		return Refund{}, err
+	}
+	if err := returns.Transition(r.Status, returns.Completed); err != nil {
+		return Refund{}, err
+	}
+	refund, err := reserveRefund(ctx, tx, paymentID, returnRefundKey(id), r.Total())
+	if err != nil {
+		return refund, err
+	}
+	return refund, tx.Commit()
+}
+
+// changeReturn блокирует заявку, проверяет переход в статус to и применяет
+// изменение change в той же транзакции.
+func (p *Postgres) changeReturn(ctx context.Context, id int, to returns.Status, change func(tx *sql.Tx, r Return) error) (Return, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Return{}, err
+	}
+	defer tx.Rollback()
+
+	r, err := loadReturn(ctx, tx, id, true)
+	if err != nil {
+		return r, err
+	}
+	if err := returns.Transition(r.Status, to); err != nil {
+		return r, err
+	}
+	if err := change(tx, r); err != nil {
+		return r, err
+	}
+
+	r, err = loadReturn(ctx, tx, id, false)
+	if err != nil {
+		return r, err
+	}
+	return r, tx.Commit()
+}
+
+// execer — то, что умеет выполнять запросы: база или транзакция.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func enqueueJobs(ctx context.Context, db execer, list ...jobs.New) error {
+	for _, job := range list {
+		payload := job.Payload
+		if payload == nil {
+			payload = json.RawMessage("{}")
+		}
+		maxAttempts := job.MaxAttempts
+		if maxAttempts == 0 {
+			maxAttempts = jobs.DefaultMaxAttempts
+		}
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO jobs (kind, payload, unique_key, max_attempts, run_at)
+			VALUES ($1, $2, NULLIF($3, ''), $4, CURRENT_TIMESTAMP + $5::float8 * interval '1 second')
+			ON CONFLICT (kind, unique_key) WHERE unique_key IS NOT NULL AND status IN ('pending', 'running')
+			DO NOTHING`,
+			job.Kind, []byte(payload), job.Key, maxAttempts, job.Delay.Seconds())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Postgres) EnqueueJobs(ctx context.Context, list ...jobs.New) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := enqueueJobs(ctx, tx, list...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const jobColumns = "id, kind, payload, status, attempts, max_attempts, last_error, run_at, created_at, updated_at"
+
+func scanJobs(rows *sql.Rows) ([]Job, error) {
+	defer rows.Close()
+
+	var list []Job
+	for rows.Next() {
+		var job Job
+		var payload []byte
+		err := rows.Scan(&job.ID, &job.Kind, &payload, &job.Status, &job.Attempts, &job.MaxAttempts,
+			&job.LastError, &job.RunAt, &job.CreatedAt, &job.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		job.Payload = payload
+		list = append(list, job)
+	}
+	return list, rows.Err()
+}
+
+func (p *Postgres) ClaimJobs(ctx context.Context, limit int, lease time.Duration) ([]Job, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1,
+			run_at = CURRENT_TIMESTAMP + $2::float8 * interval '1 second', updated_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT id FROM jobs
+			WHERE status IN ('pending', 'running') AND run_at <= CURRENT_TIMESTAMP
+			ORDER BY run_at, id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns,
+		limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	list, err := scanJobs(rows)
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, err
+}
+
+func (p *Postgres) CompleteJob(ctx context.Context, id int) error {
+	_, err := p.db.ExecContext(ctx,
+		"UPDATE jobs SET status = 'done', last_error = '', updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND status = 'running'", id)
+	return err
+}
+
+func (p *Postgres) FailJob(ctx context.Context, id int, reason string, retryIn time.Duration) error {
+	_, err := p.db.ExecContext(ctx, `
+		UPDATE jobs
+		SET status = CASE WHEN $3::float8 = 0 THEN 'dead' ELSE 'pending' END,
+			last_error = $2,
+			run_at = CURRENT_TIMESTAMP + $3::float8 * interval '1 second',
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'running'`,
+		id, reason, retryIn.Seconds())
+	return err
+}
+
+func (p *Postgres) ListJobs(ctx context.Context, status jobs.Status, limit int) ([]Job, error) {
+	rows, err := p.db.QueryContext(ctx,
+		"SELECT "+jobColumns+" FROM jobs WHERE status = $1 ORDER BY updated_at DESC, id DESC LIMIT $2",
+		status, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanJobs(rows)
+}
+
+func (p *Postgres) RetryJob(ctx context.Context, id int) error {
+	res, err := p.db.ExecContext(ctx, `
+		UPDATE jobs
+		SET status = 'pending', attempts = 0, run_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'dead'`, id)
+	if err != nil {
+		return uniqueViolation(err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) DeleteJobs(ctx context.Context, age time.Duration) (int, error) {
+	res, err := p.db.ExecContext(ctx,
+		"DELETE FROM jobs WHERE status = 'done' AND updated_at < CURRENT_TIMESTAMP - $1::float8 * interval '1 second'",
+		age.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (p *Postgres) CreatePasswordReset(ctx context.Context, userID int, tokenHash string, ttl time.Duration) error {
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO password_resets (token_hash, user_id, expires_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP + $3::float8 * interval '1 second')`,
+		tokenHash, userID, ttl.Seconds())
+	return err
+}
+
+// passwordResetUser находит владельца действующего токена; с lock
+// блокирует токен до конца транзакции.
+func passwordResetUser(ctx context.Context, q querier, tokenHash string, lock bool) (int, error) {
+	query := `
+		SELECT user_id FROM password_resets
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP`
+	if lock {
+		query += " FOR UPDATE"
+	}
+	var userID int
+	err := q.QueryRowContext(ctx, query, tokenHash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return userID, err
+}
+
+func (p *Postgres) PasswordResetUser(ctx context.Context, tokenHash string) (User, error) {
+	userID, err := passwordResetUser(ctx, p.db, tokenHash, false)
+	if err != nil {
+		return User{}, err
+	}
+	return p.UserByID(ctx, userID)
+}
+
+func (p *Postgres) ResetPassword(ctx context.Context, tokenHash string, hash []byte) (User, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	userID, err := passwordResetUser(ctx, tx, tokenHash, true)
+	if err != nil {
+		return User{}, err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE password_resets SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND used_at IS NULL", userID)
+	if err != nil {
+		return User{}, err
+	}
+
+	user := User{ID: userID, PasswordHash: hash}
+	err = tx.QueryRowContext(ctx, `
+		UPDATE users SET password = $1, session_version = session_version + 1
+		WHERE id = $2
+		RETURNING username, email, role, session_version`,
+		hash, userID).Scan(&user.Username, &user.Email, &user.Role, &user.SessionVersion)
+	if err != nil {
+		return User{}, err
+	}
+	return user, tx.Commit()
+}
+
+func (p *Postgres) DeletePasswordResets(ctx context.Context) (int, error) {
+	res, err := p.db.ExecContext(ctx,
+		"DELETE FROM password_resets WHERE used_at IS NOT NULL OR expires_at <= CURRENT_TIMESTAMP")
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
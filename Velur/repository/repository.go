@@ -0,0 +1,566 @@
+// Package repository описывает хранилище магазина: каталог, пользователей,
+// корзины и заказы. Postgres — рабочая реализация, Memory хранит всё в
+// памяти процесса и нужна для тестов и локального запуска без базы.
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"golangify.com/snippetbox/catalog"
+	"golangify.com/snippetbox/jobs"
+	"golangify.com/snippetbox/orders"
+	"golangify.com/snippetbox/payments"
+	"golangify.com/snippetbox/products"
+	"golangify.com/snippetbox/returns"
+	"golangify.com/snippetbox/roles"
+)
+
+var (
+	ErrNotFound = errors.New("запись не найдена")
+	// ErrConflict — запись нарушает уникальность: занятое имя пользователя,
+	// повторный артикул или уже существующий размер и цвет товара.
+	ErrConflict = errors.New("запись с такими данными уже существует")
+	// ErrProductUnavailable — товар или вариант из корзины удалён.
+	ErrProductUnavailable = errors.New("товар больше не продаётся")
+	// ErrReturnNotAllowed — заказ ещё не доставлен или уже возвращён.
+	ErrReturnNotAllowed = errors.New("вернуть можно только доставленный заказ")
+	// ErrReturnQuantity — в заявке нет позиций или позиций больше, чем
+	// осталось к возврату.
+	ErrReturnQuantity = errors.New("неверное количество товаров к возврату")
+	// ErrRefundPending — по заказу зарезервирован возврат оплаты для другого
+	// перехода, и шлюз его ещё не подтвердил.
+	ErrRefundPending = errors.New("по заказу не завершён возврат оплаты")
+)
+
+type OutOfStockError struct {
+	Name      string
+	Available int
+}
+
+func (e *OutOfStockError) Error() string {
+	if e.Available == 0 {
+		return fmt.Sprintf("Товара «%s» нет в наличии", e.Name)
+	}
+	return fmt.Sprintf("Товара «%s» осталось только %d шт.", e.Name, e.Available)
+}
+
+// ProductRepository хранит одежду с вариантами и аксессуары. Поле ImageURL
+// при обновлении может быть пустым — тогда изображение и его копии не
+// меняются; методы возвращают итоговые значения.
+type ProductRepository interface {
+	LoadCatalog(ctx context.Context) (*catalog.Snapshot, error)
+
+	CreateClothing(ctx context.Context, clothing products.Clothing) (products.Clothing, error)
+	UpdateClothing(ctx context.Context, clothing products.Clothing) (products.Clothing, error)
+	// DeleteClothing удаляет товар вместе с фотографиями и возвращает ссылки
+	// на изображения, на которые больше ничего не ссылается.
+	DeleteClothing(ctx context.Context, id string) ([]string, error)
+
+	AddVariant(ctx context.Context, clothingID string, variant products.Variant) (products.Variant, error)
+	// UpdateVariant меняет артикул, остаток и цену варианта и возвращает ID
+	// товара, к которому вариант относится.
+	UpdateVariant(ctx context.Context, variant products.Variant) (string, products.Variant, error)
+	DeleteVariant(ctx context.Context, id string) (string, error)
+
+	CreateAccessory(ctx context.Context, accessory products.Accessory) (products.Accessory, error)
+	UpdateAccessory(ctx context.Context, accessory products.Accessory) (products.Accessory, error)
+	DeleteAccessory(ctx context.Context, id string) ([]string, error)
+	SetAccessoryStock(ctx context.Context, id string, stock int) error
+
+	// Фотографии товара. Первая из них служит обложкой: после каждого
+	// изменения методы возвращают товар, к которому относится фотография, и
+	// его галерею в новом порядке.
+	AddImage(ctx context.Context, owner ImageOwner, image products.Image) ([]products.Image, error)
+	UpdateImageAlt(ctx context.Context, id, alt string) (ImageOwner, []products.Image, error)
+	// MoveImage сдвигает фотографию на delta позиций в галерее.
+	MoveImage(ctx context.Context, id string, delta int) (ImageOwner, []products.Image, error)
+	DeleteImage(ctx context.Context, id string) (ImageOwner, []products.Image, error)
+	// SetImageSizes сохраняет перестроенные копии фотографии.
+	SetImageSizes(ctx context.Context, id string, sizes products.ImageSizes) (ImageOwner, []products.Image, error)
+	// ImageURLs возвращает все ссылки на изображения: обложки, фотографии
+	// галерей и снимки товаров в заказах.
+	ImageURLs(ctx context.Context) (map[string]bool, error)
+}
+
+// ImageOwner — товар, которому принадлежит фотография.
+type ImageOwner struct {
+	Category  string
+	ProductID string
+}
+
+// moveImage возвращает галерею, в которой фотография id сдвинута на delta
+// позиций; за края галереи она не выходит.
+func moveImage(images []products.Image, id string, delta int) []products.Image {
+	from := -1
+	for i, image := range images {
+		if image.ID == id {
+			from = i
+		}
+	}
+	if from < 0 {
+		return images
+	}
+	to := min(max(from+delta, 0), len(images)-1)
+
+	moved := append([]products.Image(nil), images...)
+	image := moved[from]
+	moved = append(moved[:from], moved[from+1:]...)
+	moved = append(moved[:to], append([]products.Image{image}, moved[to:]...)...)
+	return moved
+}
+
+type User struct {
+	ID           int
+	Username     string
+	Email        string
+	PasswordHash []byte
+	Role         string
+	// SessionVersion растёт при каждой смене пароля; сессия, выданная при
+	// другой версии, недействительна.
+	SessionVersion int
+}
+
+type UserRepository interface {
+	CreateUser(ctx context.Context, user User) (User, error)
+	UserByID(ctx context.Context, id int) (User, error)
+	UserByUsername(ctx context.Context, username string) (User, error)
+	// UsersByEmail возвращает пользователей с адресом email без учёта
+	// регистра: один адрес могут указать несколько учётных записей.
+	UsersByEmail(ctx context.Context, email string) ([]User, error)
+	// Users возвращает всех пользователей в порядке регистрации.
+	Users(ctx context.Context) ([]User, error)
+	SetRole(ctx context.Context, username, role string) error
+	// SetPassword меняет пароль и завершает все сессии пользователя.
+	SetPassword(ctx context.Context, username string, hash []byte) error
+	// RolePermissions возвращает права роли; у покупателя их нет.
+	RolePermissions(ctx context.Context, role string) ([]roles.Permission, error)
+}
+
+// PasswordResetRepository хранит токены восстановления пароля. Сам токен
+// уходит только в письмо; здесь — его хеш.
+type PasswordResetRepository interface {
+	// CreatePasswordReset сохраняет токен пользователя userID, который
+	// действует ttl.
+	CreatePasswordReset(ctx context.Context, userID int, tokenHash string, ttl time.Duration) error
+	// PasswordResetUser возвращает владельца действующего токена. Если токен
+	// неизвестен, истёк или уже использован — ErrNotFound.
+	PasswordResetUser(ctx context.Context, tokenHash string) (User, error)
+	// ResetPassword по действующему токену задаёт новый пароль, гасит все
+	// токены пользователя и завершает его сессии. Ошибки — как у
+	// PasswordResetUser.
+	ResetPassword(ctx context.Context, tokenHash string, hash []byte) (User, error)
+	// DeletePasswordResets удаляет истёкшие и использованные токены и
+	// возвращает их число.
+	DeletePasswordResets(ctx context.Context) (int, error)
+}
+
+// CartItem — позиция корзины: товар и, для одежды, выбранный вариант.
+type CartItem struct {
+	Category  string
+	ProductID string
+	VariantID string
+}
+
+// CartRepository хранит корзины вошедших пользователей. Корзина гостя
+// живёт в сессии и сюда не попадает.
+type CartRepository interface {
+	Cart(ctx context.Context, userID int) (map[CartItem]int, error)
+	// SetCartQuantity задаёт количество позиции; 0 удаляет её из корзины.
+	SetCartQuantity(ctx context.Context, userID int, item CartItem, quantity int) error
+	// AddToCart прибавляет количество к уже лежащему в корзине.
+	AddToCart(ctx context.Context, userID int, item CartItem, quantity int) error
+}
+
+type Customer struct {
+	FirstName  string
+	LastName   string
+	MiddleName string
+	Phone      string
+	Region     string
+	City       string
+	Street     string
+	House      string
+	Apartment  string
+	// Email — адрес для писем о заказе; может быть пустым.
+	Email string
+}
+
+type OrderLine struct {
+	Item     CartItem
+	Quantity int
+}
+
+// NewOrder — заказ перед оформлением. Если UserID не 0, корзина этого
+// пользователя очищается в той же транзакции. Jobs — виды фоновых
+// заданий, которые ставятся в очередь вместе с заказом; их данные —
+// OrderPayload.
+type NewOrder struct {
+	Customer Customer
+	Lines    []OrderLine
+	UserID   int
+	Jobs     []string
+}
+
+// OrderPayload — данные заданий, поставленных при оформлении заказа.
+type OrderPayload struct {
+	OrderID int `json:"order_id"`
+}
+
+// OrderItem — позиция заказа с названием и ценой на момент покупки.
+type OrderItem struct {
+	ID int
+	CartItem
+	Name     string
+	ImageURL string
+	Size     string
+	Color    string
+	SKU      string
+	Price    float64
+	Quantity int
+}
+
+type Order struct {
+	ID int
+	// PublicID — неугадываемый номер для страницы отслеживания заказа.
+	PublicID string
+	// UserID — покупатель, оформивший заказ; 0 у гостевых заказов.
+	UserID          int
+	Customer        Customer
+	Items           []OrderItem
+	Total           float64
+	CreatedAt       time.Time
+	Status          orders.Status
+	StatusChangedAt time.Time
+	// History — смены статуса от оформления до текущего.
+	History []StatusChange
+	// Payment — последний онлайн-платёж; nil, если заказ оплачивается при
+	// получении.
+	Payment *Payment
+}
+
+// StatusChange — запись истории статусов заказа. From пуст у записи об
+// оформлении.
+type StatusChange struct {
+	From      orders.Status
+	To        orders.Status
+	ChangedBy string // имя сотрудника; пусто, если статус сменил магазин
+	Comment   string
+	ChangedAt time.Time
+}
+
+// OrderFilter отбирает заказы для списка; пустые поля не ограничивают.
+type OrderFilter struct {
+	Status orders.Status
+	// From и To — границы времени оформления, To не включается.
+	From, To time.Time
+	// Phone ищется по цифрам номера, поэтому формат записи не важен.
+	Phone  string
+	UserID int
+	Limit  int
+}
+
+type OrderRepository interface {
+	// PlaceOrder сохраняет заказ и списывает остатки. Название и цена каждой
+	// позиции берутся из хранилища, а не из корзины. Если товара не хватает,
+	// возвращается *OutOfStockError, если он удалён — ErrProductUnavailable,
+	// и ничего не сохраняется.
+	PlaceOrder(ctx context.Context, order NewOrder) (Order, error)
+	// ListOrders возвращает заказы без позиций и истории, новые первыми.
+	ListOrders(ctx context.Context, filter OrderFilter) ([]Order, error)
+	OrderByID(ctx context.Context, id int) (Order, error)
+	OrderByPublicID(ctx context.Context, publicID string) (Order, error)
+	// SetOrderStatus переводит заказ в статус to от имени сотрудника userID
+	// и записывает переход в историю. Недопустимый переход возвращает
+	// ошибку orders.ErrTransition, а переход при неподтверждённом возврате
+	// оплаты под другой статус — ErrRefundPending. Отмена возвращает товары
+	// на склад.
+	SetOrderStatus(ctx context.Context, id int, to orders.Status, userID int, comment string) (Order, error)
+	// ReserveOrderRefund под блокировкой заказа проверяет переход в статус
+	// to и, если при нём оплаченный заказ возвращает деньги, резервирует
+	// возврат остатка платежа под ключом заказа и статуса.
+	ReserveOrderRefund(ctx context.Context, id int, to orders.Status) (Refund, error)
+}
+
+// Payment — онлайн-платёж за заказ. ExternalID — номер платежа у шлюза
+// Provider.
+type Payment struct {
+	ID         int
+	OrderID    int
+	Provider   string
+	ExternalID string
+	Amount     float64
+	// Refunded — сколько уже возвращено покупателю; при частичных
+	// возвратах платёж остаётся оплаченным.
+	Refunded  float64
+	State     payments.State
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type PaymentRepository interface {
+	CreatePayment(ctx context.Context, payment Payment) (Payment, error)
+	PaymentByExternalID(ctx context.Context, provider, externalID string) (Payment, error)
+	// ApplyPaymentEvent запоминает уведомление шлюза eventID и переводит
+	// платёж в состояние to. false означает, что уведомление уже
+	// обрабатывалось или переход недопустим, и платёж не изменился.
+	ApplyPaymentEvent(ctx context.Context, provider, eventID string, paymentID int, to payments.State) (Payment, bool, error)
+	// SettleRefund учитывает подтверждённый шлюзом возврат id в платеже.
+	// Когда возвращена вся сумма, платёж переходит в payments.Refunded.
+	// Повторный вызов ничего не меняет.
+	SettleRefund(ctx context.Context, id int) (Payment, error)
+}
+
+// Refund — возврат денег через шлюз. Он резервируется до обращения к
+// шлюзу, а Key передаётся шлюзу как ключ идемпотентности, поэтому повтор
+// или одновременный запрос не вернёт деньги второй раз. Резерв не больше
+// остатка платежа за вычетом возвращённого и уже зарезервированного; если
+// возвращать нечего, Amount равен 0 и ничего не резервируется. Повторный
+// резерв с тем же ключом возвращает прежний.
+type Refund struct {
+	ID        int
+	PaymentID int
+	Key       string
+	Amount    float64
+	// Settled — шлюз подтвердил возврат, сумма учтена в Payment.Refunded.
+	Settled bool
+}
+
+// ReturnLine — позиция заказа в заявке на возврат: название и цена на
+// момент покупки и возвращаемое количество.
+type ReturnLine struct {
+	OrderItemID int
+	Name        string
+	Size        string
+	Color       string
+	Price       float64
+	Quantity    int
+}
+
+type Return struct {
+	ID      int
+	OrderID int
+	Status  returns.Status
+	Reason  returns.Reason
+	Comment string
+	Lines   []ReturnLine
+	// Refund — сумма, возвращённая покупателю при завершении возврата.
+	Refund float64
+	// Restocked — товар вернулся на склад, а не списан как брак.
+	Restocked bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Total — стоимость возвращаемых позиций.
+func (r Return) Total() float64 {
+	var total float64
+	for _, line := range r.Lines {
+		total += line.Price * float64(line.Quantity)
+	}
+	return total
+}
+
+// NewReturn — заявка покупателя. Quantities — сколько штук каждой позиции
+// заказа он возвращает, по ID позиции.
+type NewReturn struct {
+	OrderID    int
+	Reason     returns.Reason
+	Comment    string
+	Quantities map[int]int
+}
+
+type ReturnRepository interface {
+	// CreateReturn сохраняет заявку. Вернуть можно только доставленный
+	// заказ (иначе ErrReturnNotAllowed) и не больше купленного за вычетом
+	// других неотклонённых заявок (иначе ErrReturnQuantity).
+	CreateReturn(ctx context.Context, r NewReturn) (Return, error)
+	ReturnByID(ctx context.Context, id int) (Return, error)
+	// OrderReturns возвращает заявки по заказу в порядке подачи.
+	OrderReturns(ctx context.Context, orderID int) ([]Return, error)
+	// ListReturns возвращает заявки без позиций, новые первыми; пустой
+	// status — все заявки.
+	ListReturns(ctx context.Context, status returns.Status) ([]Return, error)
+	// SetReturnStatus одобряет или отклоняет заявку. Завершение — только
+	// через CompleteReturn.
+	SetReturnStatus(ctx context.Context, id int, to returns.Status) (Return, error)
+	// CompleteReturn завершает одобренную заявку: записывает сумму
+	// возврата и, если restock, возвращает товар на склад.
+	CompleteReturn(ctx context.Context, id int, refund float64, restock bool) (Return, error)
+	// ReserveReturnRefund под блокировкой заявки проверяет, что её можно
+	// завершить (иначе returns.ErrTransition), и резервирует возврат её
+	// суммы с платежа paymentID под ключом заявки.
+	ReserveReturnRefund(ctx context.Context, id, paymentID int) (Refund, error)
+}
+
+// Job — фоновое задание в очереди.
+type Job struct {
+	ID      int
+	Kind    string
+	Payload json.RawMessage
+	Status  jobs.Status
+	// Attempts — сколько раз задание выдавалось исполнителю.
+	Attempts    int
+	MaxAttempts int
+	LastError   string
+	// RunAt — когда задание выполнится; у выполняющегося — когда истечёт
+	// аренда.
+	RunAt     time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type JobRepository interface {
+	// EnqueueJobs ставит задания в очередь. Задание с ключом, у которого
+	// уже есть невыполненный двойник, пропускается.
+	EnqueueJobs(ctx context.Context, list ...jobs.New) error
+	// ClaimJobs выдаёт до limit заданий, время которых наступило, и
+	// переводит их в jobs.Running на срок lease. Другие исполнители эти
+	// задания не получат, а если исполнитель упадёт, по истечении аренды
+	// задания выдадутся снова.
+	ClaimJobs(ctx context.Context, limit int, lease time.Duration) ([]Job, error)
+	CompleteJob(ctx context.Context, id int) error
+	// FailJob записывает ошибку и откладывает задание на retryIn; нулевое
+	// retryIn переводит его в jobs.Dead.
+	FailJob(ctx context.Context, id int, reason string, retryIn time.Duration) error
+	// ListJobs возвращает задания со статусом status, недавно изменённые
+	// первыми.
+	ListJobs(ctx context.Context, status jobs.Status, limit int) ([]Job, error)
+	// RetryJob возвращает невыполненное задание в очередь с обнулёнными
+	// попытками; ErrNotFound, если такого задания в jobs.Dead нет.
+	RetryJob(ctx context.Context, id int) error
+	// DeleteJobs удаляет выполненные задания, изменённые раньше чем age
+	// назад, и возвращает их число.
+	DeleteJobs(ctx context.Context, age time.Duration) (int, error)
+}
+
+// Store объединяет все репозитории. Его реализуют Postgres и Memory.
+type Store interface {
+	ProductRepository
+	UserRepository
+	CartRepository
+	OrderRepository
+	PaymentRepository
+	ReturnRepository
+	JobRepository
+	PasswordResetRepository
+}
+
+// returnLines проверяет количества из заявки по остаткам к возврату
+// available и возвращает позиции заявки в порядке ID. Нулевые количества
+// пропускаются.
+func returnLines(quantities, available map[int]int) ([]ReturnLine, error) {
+	var lines []ReturnLine
+	for id, quantity := range quantities {
+		left, ok := available[id]
+		switch {
+		case quantity == 0:
+			continue
+		case !ok || quantity < 0 || quantity > left:
+			return nil, ErrReturnQuantity
+		}
+		lines = append(lines, ReturnLine{OrderItemID: id, Quantity: quantity})
+	}
+	if len(lines) == 0 {
+		return nil, ErrReturnQuantity
+	}
+	slices.SortFunc(lines, func(a, b ReturnLine) int { return a.OrderItemID - b.OrderItemID })
+	return lines, nil
+}
+
+// orderRefundKey — ключ идемпотентности возврата денег при переводе заказа
+// id в статус to.
+func orderRefundKey(id int, to orders.Status) string {
+	return fmt.Sprintf("order-%d-%s", id, to)
+}
+
+// returnRefundKey — ключ идемпотентности возврата денег по заявке id.
+func returnRefundKey(id int) string {
+	return fmt.Sprintf("return-%d", id)
+}
+
+// refundReserve — сколько можно зарезервировать с платежа: не больше amount
+// и остатка за вычетом возвращённого и зарезервированного reserved.
+func refundReserve(payment Payment, reserved, amount float64) float64 {
+	if payment.State != payments.Paid {
+		return 0
+	}
+	left := payments.Kopecks(payment.Amount) - payments.Kopecks(payment.Refunded) - payments.Kopecks(reserved)
+	return float64(max(min(payments.Kopecks(amount), left), 0)) / 100
+}
+
+// refundState проверяет возврат amount с платежа и возвращает состояние
+// платежа после него. Суммы сравниваются в копейках. Уведомление шлюза о
+// возврате может прийти раньше, чем магазин учтёт свой возврат, поэтому
+// возврат с уже возвращённого платежа ошибкой не считается.
+func refundState(payment Payment, amount float64) (payments.State, error) {
+	if payment.State == payments.Refunded {
+		return payments.Refunded, nil
+	}
+	if payment.State != payments.Paid {
+		return payment.State, payments.Transition(payment.State, payments.Refunded)
+	}
+	left := payments.Kopecks(payment.Amount) - payments.Kopecks(payment.Refunded)
+	switch refund := payments.Kopecks(amount); {
+	case refund <= 0 || refund > left:
+		return payment.State, payments.ErrRefundAmount
+	case refund == left:
+		return payments.Refunded, nil
+	}
+	return payments.Paid, nil
+}
+
+// orderJobs собирает задания kinds, поставленные при оформлении заказа id.
+func orderJobs(kinds []string, id int) ([]jobs.New, error) {
+	list := make([]jobs.New, 0, len(kinds))
+	for _, kind := range kinds {
+		job, err := jobs.Make(kind, OrderPayload{OrderID: id})
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, job)
+	}
+	return list, nil
+}
+
+// newPublicID возвращает 128 случайных бит в шестнадцатеричной записи —
+// столько же символов, сколько у номеров, выданных миграцией.
+func newPublicID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// phoneDigits оставляет в номере телефона только цифры.
+func phoneDigits(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+}
+
+// assignSKUs проставляет артикулы вариантам, у которых их нет:
+// VL-<товар>-<размер>-<номер цвета в порядке перечисления>.
+func assignSKUs(clothingID string, variants []products.Variant) {
+	colors := map[string]int{}
+	for i := range variants {
+		if variants[i].SKU != "" {
+			continue
+		}
+		n, ok := colors[variants[i].Color]
+		if !ok {
+			n = len(colors) + 1
+			colors[variants[i].Color] = n
+		}
+		variants[i].SKU = fmt.Sprintf("VL-%s-%s-%d", clothingID, variants[i].Size, n)
+	}
+}
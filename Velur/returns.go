@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"golangify.com/snippetbox/orders"
+	"golangify.com/snippetbox/payments"
+	"golangify.com/snippetbox/repository"
+	"golangify.com/snippetbox/returns"
+)
+
+// returnableQuantities — сколько штук каждой позиции заказа ещё можно
+// вернуть с учётом неотклонённых заявок.
+func returnableQuantities(order repository.Order, list []repository.Return) map[int]int {
+	available := map[int]int{}
+	for _, item := range order.Items {
+		available[item.ID] = item.Quantity
+	}
+	for _, r := range list {
+		if r.Status == returns.Rejected {
+			continue
+		}
+		for _, line := range r.Lines {
+			available[line.OrderItemID] -= line.Quantity
+		}
+	}
+	return available
+}
+
+// canReturn сообщает, можно ли подать по заказу новую заявку.
+func canReturn(order repository.Order, available map[int]int) bool {
+	if order.Status != orders.Delivered {
+		return false
+	}
+	for _, quantity := range available {
+		if quantity > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// createReturnHandler принимает заявку на возврат со страницы заказа.
+// Количество каждой позиции приходит в поле quantity_<ID позиции>.
+func (srv *server) createReturnHandler(w http.ResponseWriter, r *http.Request) {
+	publicID := mux.Vars(r)["public_id"]
+	order, err := srv.orders.OrderByPublicID(r.Context(), publicID)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при загрузке заказа:", err)
+		http.Error(w, "Ошибка при загрузке заказа", http.StatusInternalServerError)
+		return
+	}
+
+	newReturn := repository.NewReturn{
+		OrderID:    order.ID,
+		Reason:     returns.Reason(r.FormValue("reason")),
+		Comment:    r.FormValue("comment"),
+		Quantities: map[int]int{},
+	}
+	if !returns.KnownReason(newReturn.Reason) {
+		http.Error(w, "Укажите причину возврата", http.StatusBadRequest)
+		return
+	}
+	for _, item := range order.Items {
+		value := r.FormValue("quantity_" + strconv.Itoa(item.ID))
+		if value == "" {
+			continue
+		}
+		quantity, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Некорректное количество", http.StatusBadRequest)
+			return
+		}
+		newReturn.Quantities[item.ID] = quantity
+	}
+
+	created, err := srv.returns.CreateReturn(r.Context(), newReturn)
+	if errors.Is(err, repository.ErrReturnNotAllowed) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, repository.ErrReturnQuantity) {
+		http.Error(w, "Выберите товары и количество не больше купленного", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при сохранении заявки на возврат:", err)
+		http.Error(w, "Ошибка при сохранении заявки", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("По заказу №%d подана заявка на возврат №%d", order.ID, created.ID)
+	http.Redirect(w, r, "/order/"+publicID+"#returns", http.StatusSeeOther)
+}
+
+func (srv *server) adminReturns(w http.ResponseWriter, r *http.Request) {
+	status := returns.Status(r.URL.Query().Get("status"))
+	if status != "" && !returns.Known(status) {
+		http.Error(w, "Неизвестный статус возврата", http.StatusBadRequest)
+		return
+	}
+
+	list, err := srv.returns.ListReturns(r.Context(), status)
+	if err != nil {
+		log.Println("Ошибка при загрузке возвратов:", err)
+		http.Error(w, "Ошибка при загрузке возвратов", http.StatusInternalServerError)
+		return
+	}
+
+	err = adminReturnsTpl.Execute(w, map[string]interface{}{
+		"Returns":   list,
+		"Statuses":  returns.Statuses,
+		"Status":    status,
+		"CSRFToken": srv.csrfToken(w, r),
+	})
+	if err != nil {
+		log.Println("Ошибка при рендеринге списка возвратов:", err)
+		http.Error(w, "Ошибка при отображении страницы", http.StatusInternalServerError)
+	}
+}
+
+// loadReturn загружает заявку из адреса запроса; при ошибке ответ уже
+// записан.
+func (srv *server) loadReturn(w http.ResponseWriter, r *http.Request) (repository.Return, bool) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	ret, err := srv.returns.ReturnByID(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return ret, false
+	}
+	if err != nil {
+		log.Println("Ошибка при загрузке заявки на возврат:", err)
+		http.Error(w, "Ошибка при загрузке заявки", http.StatusInternalServerError)
+		return ret, false
+	}
+	return ret, true
+}
+
+// setReturnStatus одобряет или отклоняет заявку.
+func (srv *server) setReturnStatus(w http.ResponseWriter, r *http.Request) {
+	ret, ok := srv.loadReturn(w, r)
+	if !ok {
+		return
+	}
+	status := returns.Status(r.FormValue("status"))
+	if status == returns.Completed {
+		http.Error(w, "Заявка завершается после получения товара", http.StatusBadRequest)
+		return
+	}
+
+	ret, err := srv.returns.SetReturnStatus(r.Context(), ret.ID, status)
+	if errors.Is(err, returns.ErrTransition) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при смене статуса возврата:", err)
+		http.Error(w, "Ошибка при смене статуса возврата", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Возврат №%d по заказу №%d: %s", ret.ID, ret.OrderID, ret.Status.Label())
+	http.Redirect(w, r, "/admin/orders/"+strconv.Itoa(ret.OrderID)+"#returns", http.StatusSeeOther)
+}
+
+// completeReturn завершает одобренную заявку, когда товар получен: деньги
+// за возвращённые позиции уходят покупателю через платёжный шлюз, а товар,
+// если он не бракованный, возвращается на склад.
+func (srv *server) completeReturn(w http.ResponseWriter, r *http.Request) {
+	ret, ok := srv.loadReturn(w, r)
+	if !ok {
+		return
+	}
+	order, err := srv.orders.OrderByID(r.Context(), ret.OrderID)
+	if err != nil {
+		log.Println("Ошибка при загрузке заказа:", err)
+		http.Error(w, "Ошибка при загрузке заказа", http.StatusInternalServerError)
+		return
+	}
+
+	// Без онлайн-оплаты сумма только записывается: деньги возвращают
+	// вручную. Иначе возврат резервируется под блокировкой заявки, и
+	// повторное завершение получит тот же резерв, а не второй возврат.
+	refund := ret.Total()
+	if payment := order.Payment; payment != nil && (payment.State == payments.Paid || payment.State == payments.Refunded) {
+		reserved, err := srv.returns.ReserveReturnRefund(r.Context(), ret.ID, payment.ID)
+		if errors.Is(err, returns.ErrTransition) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка при резервировании возврата №%d: %v", ret.ID, err)
+			http.Error(w, "Ошибка при возврате оплаты", http.StatusInternalServerError)
+			return
+		}
+		if err := srv.refundPayment(r.Context(), *payment, reserved); err != nil {
+			log.Printf("Не удалось вернуть оплату по возврату №%d: %v", ret.ID, err)
+			http.Error(w, "Не удалось вернуть оплату, попробуйте позже", http.StatusBadGateway)
+			return
+		}
+		refund = reserved.Amount
+	}
+
+	restock := r.FormValue("restock") != ""
+	ret, err = srv.returns.CompleteReturn(r.Context(), ret.ID, refund, restock)
+	if errors.Is(err, returns.ErrTransition) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при завершении возврата:", err)
+		http.Error(w, "Ошибка при завершении возврата", http.StatusInternalServerError)
+		return
+	}
+	if restock {
+		srv.catalog.Invalidate()
+	}
+
+	log.Printf("Возврат №%d по заказу №%d завершён, возвращено %.2f", ret.ID, ret.OrderID, ret.Refund)
+	http.Redirect(w, r, "/admin/orders/"+strconv.Itoa(ret.OrderID)+"#returns", http.StatusSeeOther)
+}
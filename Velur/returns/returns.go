@@ -0,0 +1,93 @@
+// Package returns описывает возвраты товаров: статусы заявки, допустимые
+// переходы и причины возврата.
+package returns
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Status string
+
+const (
+	Requested Status = "requested"
+	Approved  Status = "approved"
+	Rejected  Status = "rejected"
+	// Completed — товар получен магазином, деньги возвращены.
+	Completed Status = "completed"
+)
+
+var Statuses = []Status{Requested, Approved, Rejected, Completed}
+
+var labels = map[Status]string{
+	Requested: "На рассмотрении",
+	Approved:  "Одобрен, ждём товар",
+	Rejected:  "Отклонён",
+	Completed: "Завершён",
+}
+
+var transitions = map[Status][]Status{
+	Requested: {Approved, Rejected},
+	Approved:  {Completed},
+}
+
+var ErrTransition = errors.New("недопустимая смена статуса возврата")
+
+func Known(s Status) bool {
+	_, ok := labels[s]
+	return ok
+}
+
+func (s Status) Label() string {
+	if label, ok := labels[s]; ok {
+		return label
+	}
+	return string(s)
+}
+
+func (s Status) Next() []Status {
+	return transitions[s]
+}
+
+// Transition проверяет переход заявки из from в to.
+func Transition(from, to Status) error {
+	for _, next := range transitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: из «%s» в «%s»", ErrTransition, from.Label(), to.Label())
+}
+
+// Reason — причина возврата, которую выбирает покупатель.
+type Reason string
+
+const (
+	WrongSize      Reason = "size"
+	Defect         Reason = "defect"
+	NotAsDescribed Reason = "not_as_described"
+	ChangedMind    Reason = "changed_mind"
+	Other          Reason = "other"
+)
+
+var Reasons = []Reason{WrongSize, Defect, NotAsDescribed, ChangedMind, Other}
+
+var reasonLabels = map[Reason]string{
+	WrongSize:      "Не подошёл размер",
+	Defect:         "Брак",
+	NotAsDescribed: "Не соответствует описанию",
+	ChangedMind:    "Передумал(а)",
+	Other:          "Другое",
+}
+
+func KnownReason(r Reason) bool {
+	_, ok := reasonLabels[r]
+	return ok
+}
+
+func (r Reason) Label() string {
+	if label, ok := reasonLabels[r]; ok {
+		return label
+	}
+	return string(r)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"golangify.com/snippetbox/orders"
+	"golangify.com/snippetbox/payments"
+	"golangify.com/snippetbox/returns"
+	"golangify.com/snippetbox/roles"
+)
+
+// deliver проводит заказ по статусам до доставки.
+func deliver(t *testing.T, admin *testClient, id int) {
+	t.Helper()
+	for _, status := range []string{"confirmed", "packed", "shipped", "delivered"} {
+		assertRedirect(t, admin.postForm(orderPath(id)+"/status", url.Values{"status": {status}}), orderPath(id))
+	}
+}
+
+func returnForm(itemID int, quantity, reason string) url.Values {
+	return url.Values{
+		"quantity_" + strconv.Itoa(itemID): {quantity},
+		"reason":                           {reason},
+		"comment":                          {"Не подошло"},
+	}
+}
+
+func TestReturnRequest(t *testing.T) {
+	app := newTestApp(t)
+	clothing := app.seedClothing(t, "Льняное платье", 2)
+	id := placeOrder(t, app, clothingItem(clothing, 0, "2"), "+79990000000")
+	order, _ := app.store.OrderByID(context.Background(), id)
+	item := order.Items[0]
+	path := "/order/" + order.PublicID
+	c := app.client(t)
+	admin := app.adminClient(t)
+
+	assertStatus(t, c.postForm(path+"/returns", returnForm(item.ID, "1", "size")), http.StatusConflict)
+
+	deliver(t, admin, id)
+	assertContains(t, c.get(path).body, "Оформить возврат")
+	assertStatus(t, c.postForm(path+"/returns", returnForm(item.ID, "3", "size")), http.StatusBadRequest)
+	assertStatus(t, c.postForm(path+"/returns", returnForm(item.ID, "0", "size")), http.StatusBadRequest)
+	assertStatus(t, c.postForm(path+"/returns", returnForm(item.ID, "1", "boredom")), http.StatusBadRequest)
+
+	assertRedirect(t, c.postForm(path+"/returns", returnForm(item.ID, "1", "size")), path+"#returns")
+	assertRedirect(t, c.postForm(path+"/returns", returnForm(item.ID, "1", "defect")), path+"#returns")
+	// Обе штуки уже в заявках.
+	assertStatus(t, c.postForm(path+"/returns", returnForm(item.ID, "1", "size")), http.StatusBadRequest)
+
+	list, err := app.store.OrderReturns(context.Background(), id)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("заявки %+v, ошибка %v", list, err)
+	}
+	first, second := list[0], list[1]
+	if first.Status != returns.Requested || first.Reason != returns.WrongSize || first.Lines[0].Quantity != 1 {
+		t.Fatalf("заявка %+v", first)
+	}
+	assertContains(t, admin.get("/admin/returns?status=requested").body, orderPath(id)+"#returns")
+	assertContains(t, admin.get(orderPath(id)).body, "Не подошёл размер")
+
+	// Завершить можно только одобренную заявку.
+	returnPath := "/admin/returns/" + strconv.Itoa(first.ID)
+	assertStatus(t, admin.postForm(returnPath+"/complete", url.Values{"restock": {"1"}}), http.StatusConflict)
+	assertRedirect(t, admin.postForm(returnPath+"/status", url.Values{"status": {"approved"}}), orderPath(id)+"#returns")
+	assertRedirect(t, admin.postForm(returnPath+"/complete", url.Values{"restock": {"1"}}), orderPath(id)+"#returns")
+	assertStatus(t, admin.postForm(returnPath+"/status", url.Values{"status": {"rejected"}}), http.StatusConflict)
+
+	app.reload(t)
+	if variant, _ := app.srv.catalog.Snapshot().Clothes[clothing.ID].Variant(clothing.Variants[0].ID); variant.Stock != 1 {
+		t.Fatalf("остаток после возврата %d, ожидался 1", variant.Stock)
+	}
+	// Без онлайн-оплаты сумма только фиксируется для ручного возврата.
+	if ret, _ := app.store.ReturnByID(context.Background(), first.ID); ret.Status != returns.Completed || ret.Refund != item.Price || !ret.Restocked {
+		t.Fatalf("завершённая заявка %+v", ret)
+	}
+
+	// Отклонённая заявка освобождает товар для новой.
+	assertRedirect(t, admin.postForm("/admin/returns/"+strconv.Itoa(second.ID)+"/status", url.Values{"status": {"rejected"}}), orderPath(id)+"#returns")
+	assertRedirect(t, c.postForm(path+"/returns", returnForm(item.ID, "1", "other")), path+"#returns")
+}
+
+func TestReturnPartialRefund(t *testing.T) {
+	app, _ := newPaymentApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 3)
+	admin := app.adminClient(t)
+	c := app.client(t)
+
+	c.postForm("/cart/add", accessoryItem(accessory, "2"))
+	resp := c.postForm("/order", orderForm())
+	c.postForm(resp.header.Get("Location"), url.Values{"action": {"pay"}})
+	order := lastOrder(t, app)
+	deliver(t, admin, order.ID)
+
+	path := "/order/" + order.PublicID
+	assertRedirect(t, c.postForm(path+"/returns", returnForm(order.Items[0].ID, "1", "defect")), path+"#returns")
+	list, _ := app.store.OrderReturns(context.Background(), order.ID)
+	returnPath := "/admin/returns/" + strconv.Itoa(list[0].ID)
+	admin.postForm(returnPath+"/status", url.Values{"status": {"approved"}})
+	assertRedirect(t, admin.postForm(returnPath+"/complete", url.Values{}), orderPath(order.ID)+"#returns")
+
+	order = lastOrder(t, app)
+	if order.Payment.State != payments.Paid || order.Payment.Refunded != 300 {
+		t.Fatalf("платёж после частичного возврата: %+v", order.Payment)
+	}
+	app.reload(t)
+	if a, _ := app.srv.catalog.Accessory(accessory.ID); a.Stock != 1 {
+		t.Fatalf("брак вернулся на склад: остаток %d", a.Stock)
+	}
+	assertContains(t, c.get(path).body, "возвращено 300.00 ₽")
+
+	// Возврат всего заказа возвращает только остаток.
+	assertRedirect(t, admin.postForm(orderPath(order.ID)+"/status", url.Values{"status": {"returned"}}), orderPath(order.ID))
+	if order = lastOrder(t, app); order.Status != orders.Returned || order.Payment.State != payments.Refunded || order.Payment.Refunded != 600 {
+		t.Fatalf("статус %q, платёж %+v", order.Status, order.Payment)
+	}
+}
+
+func TestReturnsPermissions(t *testing.T) {
+	app := newTestApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 3)
+	id := placeOrder(t, app, accessoryItem(accessory, "1"), "+79990000000")
+	deliver(t, app.adminClient(t), id)
+	order, _ := app.store.OrderByID(context.Background(), id)
+	c := app.client(t)
+	path := "/order/" + order.PublicID
+	c.postForm(path+"/returns", returnForm(order.Items[0].ID, "1", "changed_mind"))
+	list, _ := app.store.OrderReturns(context.Background(), id)
+	returnPath := "/admin/returns/" + strconv.Itoa(list[0].ID)
+
+	for _, role := range []string{roles.Customer, roles.Support} {
+		app.createUser(t, role, "secret", role)
+		staff := app.client(t)
+		staff.login(role, "secret")
+
+		view := http.StatusForbidden
+		if role == roles.Support {
+			view = http.StatusOK
+		}
+		assertStatus(t, staff.get("/admin/returns"), view)
+		assertStatus(t, staff.postForm(returnPath+"/status", url.Values{"status": {"approved"}}), http.StatusForbidden)
+		assertStatus(t, staff.postForm(returnPath+"/complete", url.Values{}), http.StatusForbidden)
+	}
+	if ret, _ := app.store.ReturnByID(context.Background(), list[0].ID); ret.Status != returns.Requested {
+		t.Fatalf("статус заявки %q", ret.Status)
+	}
+}
+
+func TestReturnDoubleCompleteRefundsOnce(t *testing.T) {
+	app, fake := newPaymentApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 3)
+	admin := app.adminClient(t)
+	admin.token()
+	c := app.client(t)
+
+	c.postForm("/cart/add", accessoryItem(accessory, "2"))
+	resp := c.postForm("/order", orderForm())
+	c.postForm(resp.header.Get("Location"), url.Values{"action": {"pay"}})
+	order := lastOrder(t, app)
+	deliver(t, admin, order.ID)
+
+	path := "/order/" + order.PublicID
+	assertRedirect(t, c.postForm(path+"/returns", returnForm(order.Items[0].ID, "1", "defect")), path+"#returns")
+	list, _ := app.store.OrderReturns(context.Background(), order.ID)
+	returnPath := "/admin/returns/" + strconv.Itoa(list[0].ID)
+	admin.postForm(returnPath+"/status", url.Values{"status": {"approved"}})
+
+	gateway := &heldGateway{
+		Fake:    fake,
+		held:    make(chan struct{}),
+		release: make(chan struct{}),
+		refunds: map[string]int64{},
+	}
+	app.srv.gateway = gateway
+
+	// Первое завершение ждёт ответа шлюза, а второе успевает пройти целиком.
+	first := make(chan testResponse)
+	go func() { first <- admin.postForm(returnPath+"/complete", url.Values{}) }()
+	<-gateway.held
+	assertRedirect(t, admin.postForm(returnPath+"/complete", url.Values{}), orderPath(order.ID)+"#returns")
+	close(gateway.release)
+	assertStatus(t, <-first, http.StatusConflict)
+	// Завершённую заявку шлюз уже не видит.
+	assertStatus(t, admin.postForm(returnPath+"/complete", url.Values{}), http.StatusConflict)
+
+	if len(gateway.refunds) != 1 || gateway.refunds["return-"+strconv.Itoa(list[0].ID)] != 30000 {
+		t.Fatalf("возвраты шлюза: %v", gateway.refunds)
+	}
+	if order = lastOrder(t, app); order.Payment.State != payments.Paid || order.Payment.Refunded != 300 {
+		t.Fatalf("платёж после возврата: %+v", order.Payment)
+	}
+}
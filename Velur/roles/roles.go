@@ -0,0 +1,57 @@
+// Package roles описывает роли сотрудников и права, которые они дают.
+// Справочник хранится в таблицах roles, permissions и role_permissions;
+// здесь — имена, на которые ссылается код, и набор прав по умолчанию.
+package roles
+
+import "slices"
+
+type Permission string
+
+const (
+	// CatalogManage — добавление, правка и удаление товаров, вариантов и
+	// остатков.
+	CatalogManage Permission = "catalog.manage"
+	OrdersView    Permission = "orders.view"
+	OrdersManage  Permission = "orders.manage"
+	// JobsManage — просмотр и перезапуск фоновых заданий.
+	JobsManage Permission = "jobs.manage"
+)
+
+const (
+	Customer       = "user"
+	Admin          = "admin"
+	CatalogManager = "catalog_manager"
+	OrderManager   = "order_manager"
+	Support        = "support"
+)
+
+// Defaults — права ролей в том виде, в каком их создают миграции
+// 0002_roles и 0011_jobs. Используется хранилищем в памяти.
+var Defaults = map[string][]Permission{
+	Customer:       nil,
+	Admin:          {CatalogManage, OrdersView, OrdersManage, JobsManage},
+	CatalogManager: {CatalogManage},
+	OrderManager:   {OrdersView, OrdersManage},
+	Support:        {OrdersView},
+}
+
+// Known сообщает, есть ли такая роль в справочнике.
+func Known(role string) bool {
+	_, ok := Defaults[role]
+	return ok
+}
+
+// Home возвращает страницу админ-панели, которую откроет роль с правами
+// permissions, или пустую строку, если ни одной такой страницы нет.
+// Используется только для ссылки на админку; доступ проверяется маршрутами.
+func Home(permissions []Permission) string {
+	switch {
+	case slices.Contains(permissions, CatalogManage):
+		return "/admin"
+	case slices.Contains(permissions, OrdersView):
+		return "/admin/orders"
+	case slices.Contains(permissions, JobsManage):
+		return "/admin/jobs"
+	}
+	return ""
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"golangify.com/snippetbox/catalog"
+	"golangify.com/snippetbox/config"
+	"golangify.com/snippetbox/images"
+	"golangify.com/snippetbox/notify"
+	"golangify.com/snippetbox/payments"
+	"golangify.com/snippetbox/repository"
+	"golangify.com/snippetbox/roles"
+	"golangify.com/snippetbox/storage"
+)
+
+// server хранит всё, что нужно обработчикам: настройки, сессии, каталог и
+// репозитории. Обработчики не обращаются к базе напрямую, поэтому тот же
+// server работает и поверх repository.Memory.
+type server struct {
+	cfg     config.Config
+	store   *sessions.CookieStore
+	catalog *catalog.Catalog
+	images  storage.Storage
+	// gateway — платёжный шлюз; nil, если заказы оплачиваются при получении.
+	gateway payments.Provider
+	mailer  notify.Sender
+	// jobQueued будит исполнителя заданий, когда в очереди появилось
+	// задание.
+	jobQueued chan struct{}
+
+	products repository.ProductRepository
+	users    repository.UserRepository
+	carts    repository.CartRepository
+	orders   repository.OrderRepository
+	payments repository.PaymentRepository
+	returns  repository.ReturnRepository
+	jobs     repository.JobRepository
+	resets   repository.PasswordResetRepository
+}
+
+func newServer(cfg config.Config, store repository.Store) *server {
+	return &server{
+		cfg:       cfg,
+		store:     newSessionStore(cfg),
+		catalog:   catalog.New(store.LoadCatalog),
+		images:    imageStorage(cfg),
+		gateway:   paymentProvider(cfg),
+		mailer:    mailSender(cfg),
+		jobQueued: make(chan struct{}, 1),
+		products:  store,
+		users:     store,
+		carts:     store,
+		orders:    store,
+		payments:  store,
+		returns:   store,
+		jobs:      store,
+		resets:    store,
+	}
+}
+
+// imageStorage выбирает хранилище изображений товаров. Локальный каталог
+// загрузок раздаётся самим сервером по /uploads/. Раньше загрузки лежали
+// среди ресурсов и в базе остались ссылки вида /assets/product_images/…;
+// если каталог загрузок по-прежнему внутри ресурсов, такие ссылки тоже
+// считаются ссылками на файлы хранилища.
+func imageStorage(cfg config.Config) storage.Storage {
+	if cfg.ImageStorage == config.ImageStorageS3 {
+		return &storage.S3{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PublicURL: cfg.S3PublicURL,
+		}
+	}
+	local := &storage.Local{Dir: cfg.UploadDir, BaseURL: "/uploads/"}
+	if rel, err := filepath.Rel(cfg.AssetsDir, cfg.UploadDir); err == nil && rel != "." && filepath.IsLocal(rel) {
+		local.LegacyURL = "/assets/" + filepath.ToSlash(rel) + "/"
+	}
+	return local
+}
+
+// paymentProvider выбирает платёжный шлюз. Учебный шлюз присылает
+// уведомления самому магазину по его публичному адресу.
+func paymentProvider(cfg config.Config) payments.Provider {
+	if cfg.PaymentProvider == config.PaymentFake {
+		return &payments.Fake{Secret: cfg.PaymentSecret, WebhookURL: cfg.URL("/webhooks/payments/fake")}
+	}
+	return nil
+}
+
+// mailSender выбирает отправителя писем. По умолчанию письма только
+// записываются в журнал.
+func mailSender(cfg config.Config) notify.Sender {
+	switch cfg.MailSender {
+	case config.MailSMTP:
+		return &notify.SMTP{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		}
+	case config.MailFile:
+		return &notify.File{Dir: cfg.MailDir, From: cfg.MailFrom}
+	}
+	return notify.Log{}
+}
+
+func newSessionStore(cfg config.Config) *sessions.CookieStore {
+	keys := [][]byte{[]byte(cfg.SessionAuthKey)}
+	if cfg.SessionEncryptionKey != "" {
+		keys = append(keys, []byte(cfg.SessionEncryptionKey))
+	}
+
+	s := sessions.NewCookieStore(keys...)
+	s.Options.Secure = cfg.CookieSecure
+	s.Options.HttpOnly = true
+	s.Options.SameSite = cfg.SameSite()
+	return s
+}
+
+// maxRequestBody — наибольший размер тела запроса: изображение и поля
+// формы товара.
+const maxRequestBody = images.MaxSize + 1<<20
+
+func limitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (srv *server) routes() http.Handler {
+	r := mux.NewRouter()
+	r.Use(limitRequestBody, srv.verifyCSRF)
+
+	fs := http.FileServer(http.Dir(srv.cfg.AssetsDir))
+	r.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", fs))
+	if local, ok := srv.images.(*storage.Local); ok {
+		uploads := http.FileServer(http.Dir(local.Dir))
+		r.PathPrefix(local.BaseURL).Handler(http.StripPrefix(local.BaseURL, uploads))
+	}
+	if fake, ok := srv.gateway.(*payments.Fake); ok {
+		r.PathPrefix(payments.FakePath).Handler(fake)
+	}
+
+	// Страницы магазина проверяют сессию по базе; статические файлы выше
+	// отдаются без этого запроса.
+	pages := r.NewRoute().Subrouter()
+	pages.Use(srv.checkSession)
+	pages.HandleFunc("/", srv.marketHandler).Methods("GET")
+	pages.HandleFunc("/about", srv.aboutHandler).Methods("GET")
+	pages.HandleFunc("/registration", srv.registrationHandler).Methods("GET", "POST")
+	pages.HandleFunc("/login", srv.loginHandler).Methods("GET", "POST")
+	pages.HandleFunc("/logout", srv.logoutHandler).Methods("POST")
+	pages.HandleFunc("/password/forgot", srv.forgotPasswordHandler).Methods("GET", "POST")
+	pages.HandleFunc("/password/reset/{token:[A-Za-z0-9_-]{43}}", srv.resetPasswordHandler).Methods("GET", "POST")
+	pages.HandleFunc("/clothing/{id:[0-9]+}", srv.clothingHandler).Methods("GET")
+	pages.HandleFunc("/accessory/{id:[0-9]+}", srv.accessoryHandler).Methods("GET")
+	pages.HandleFunc("/cart", srv.cartHandler).Methods("GET")
+	pages.HandleFunc("/cart/add", srv.addToCartHandler).Methods("POST")
+	pages.HandleFunc("/cart/update", srv.updateCartHandler).Methods("POST")
+	pages.HandleFunc("/cart/remove", srv.removeFromCartHandler).Methods("POST")
+	pages.HandleFunc("/cart/change-variant", srv.changeVariantHandler).Methods("POST")
+	pages.HandleFunc("/checkout", srv.checkoutHandler).Methods("GET")
+	pages.HandleFunc("/order", srv.submitOrderHandler).Methods("POST")
+	pages.HandleFunc("/order/{public_id:[0-9a-f]{32}}", srv.trackOrderHandler).Methods("GET")
+	pages.HandleFunc("/order/{public_id:[0-9a-f]{32}}/returns", srv.createReturnHandler).Methods("POST")
+	pages.HandleFunc("/account/orders", srv.accountOrdersHandler).Methods("GET")
+	pages.HandleFunc("/webhooks/payments/{provider}", srv.paymentWebhookHandler).Methods("POST")
+
+	admin := pages.PathPrefix("/admin").Subrouter()
+	admin.Use(srv.requireStaff)
+	manage := func(h http.HandlerFunc) http.Handler { return srv.require(roles.CatalogManage, h) }
+	admin.Handle("", manage(srv.adminHandler)).Methods("GET")
+	admin.Handle("/add-clothing", manage(srv.addClothing)).Methods("POST")
+	admin.Handle("/add-accessory", manage(srv.addAccessory)).Methods("POST")
+	admin.Handle("/edit-clothing/{id:[0-9]+}", manage(srv.editClothingForm)).Methods("GET")
+	admin.Handle("/edit-clothing/{id:[0-9]+}", manage(srv.updateClothing)).Methods("POST")
+	admin.Handle("/edit-accessory/{id:[0-9]+}", manage(srv.editAccessoryForm)).Methods("GET")
+	admin.Handle("/edit-accessory/{id:[0-9]+}", manage(srv.updateAccessory)).Methods("POST")
+	admin.Handle("/delete-clothing/{id:[0-9]+}", manage(srv.deleteClothing)).Methods("POST")
+	admin.Handle("/delete-accessory/{id:[0-9]+}", manage(srv.deleteAccessory)).Methods("POST")
+	admin.Handle("/add-variant/{id:[0-9]+}", manage(srv.addVariant)).Methods("POST")
+	admin.Handle("/update-variant/{id:[0-9]+}", manage(srv.updateVariant)).Methods("POST")
+	admin.Handle("/delete-variant/{id:[0-9]+}", manage(srv.deleteVariant)).Methods("POST")
+	admin.Handle("/set-stock-accessory/{id:[0-9]+}", manage(srv.setAccessoryStock)).Methods("POST")
+	admin.Handle("/add-clothing-image/{id:[0-9]+}", manage(srv.addClothingImage)).Methods("POST")
+	admin.Handle("/add-accessory-image/{id:[0-9]+}", manage(srv.addAccessoryImage)).Methods("POST")
+	admin.Handle("/update-image/{id:[0-9]+}", manage(srv.updateImage)).Methods("POST")
+	admin.Handle("/move-image/{id:[0-9]+}", manage(srv.moveImage)).Methods("POST")
+	admin.Handle("/delete-image/{id:[0-9]+}", manage(srv.deleteImage)).Methods("POST")
+	admin.Handle("/orders", srv.require(roles.OrdersView, srv.adminOrders)).Methods("GET")
+	admin.Handle("/orders/{id:[0-9]+}", srv.require(roles.OrdersView, srv.adminOrder)).Methods("GET")
+	admin.Handle("/orders/{id:[0-9]+}/status", srv.require(roles.OrdersManage, srv.setOrderStatus)).Methods("POST")
+	admin.Handle("/returns", srv.require(roles.OrdersView, srv.adminReturns)).Methods("GET")
+	admin.Handle("/returns/{id:[0-9]+}/status", srv.require(roles.OrdersManage, srv.setReturnStatus)).Methods("POST")
+	admin.Handle("/returns/{id:[0-9]+}/complete", srv.require(roles.OrdersManage, srv.completeReturn)).Methods("POST")
+	admin.Handle("/jobs", srv.require(roles.JobsManage, srv.adminJobs)).Methods("GET")
+	admin.Handle("/jobs/{id:[0-9]+}/retry", srv.require(roles.JobsManage, srv.retryJob)).Methods("POST")
+
+	return r
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Local хранит файлы в каталоге Dir, который веб-сервер раздаёт по адресу
+// BaseURL, например "/uploads/".
+type Local struct {
+	Dir     string
+	BaseURL string
+	// LegacyURL — прежний адрес того же каталога, например
+	// "/assets/product_images/": по нему на файлы ссылаются загрузки,
+	// сделанные до появления хранилища. Пусто, если такого адреса нет.
+	LegacyURL string
+}
+
+// Put записывает файл во временный и переименовывает его, чтобы читатели
+// никогда не видели недописанное изображение.
+func (l *Local) Put(ctx context.Context, name string, data []byte, contentType string) error {
+	if err := os.MkdirAll(l.Dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(l.Dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(l.Dir, name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (l *Local) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(l.Dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (l *Local) Delete(ctx context.Context, name string) error {
+	err := os.Remove(filepath.Join(l.Dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List перечисляет файлы каталога, включая временные файлы прерванных
+// записей: на них тоже никто не ссылается.
+func (l *Local) List(ctx context.Context) ([]File, error) {
+	entries, err := os.ReadDir(l.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []File
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, File{Name: entry.Name(), Modified: info.ModTime()})
+	}
+	return files, nil
+}
+
+func (l *Local) URL(name string) string {
+	return l.BaseURL + name
+}
+
+func (l *Local) Name(url string) (string, bool) {
+	if name, ok := nameFromURL(l.BaseURL, url); ok || l.LegacyURL == "" {
+		return name, ok
+	}
+	return nameFromURL(l.LegacyURL, url)
+}
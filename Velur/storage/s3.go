@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 хранит файлы в бакете S3-совместимого хранилища (Amazon S3, MinIO,
+// Yandex Object Storage). Запросы подписываются по AWS Signature Version 4,
+// адреса объектов строятся в path-style: Endpoint/Bucket/имя.
+type S3 struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PublicURL — адрес, по которому объекты видят браузеры, например CDN.
+	// Если пуст, используется Endpoint/Bucket/.
+	PublicURL string
+
+	// Client по умолчанию http.DefaultClient.
+	Client *http.Client
+}
+
+func (s *S3) Put(ctx context.Context, name string, data []byte, contentType string) error {
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	// Имена вычисляются по содержимому, поэтому файл под именем не меняется.
+	header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	_, err := s.do(ctx, http.MethodPut, s.objectURL(name), data, header)
+	return err
+}
+
+func (s *S3) Get(ctx context.Context, name string) ([]byte, error) {
+	return s.do(ctx, http.MethodGet, s.objectURL(name), nil, nil)
+}
+
+func (s *S3) Delete(ctx context.Context, name string) error {
+	_, err := s.do(ctx, http.MethodDelete, s.objectURL(name), nil, nil)
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// listResult — ответ ListObjectsV2.
+type listResult struct {
+	Contents []struct {
+		Key          string
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List перечисляет объекты бакета постранично через ListObjectsV2.
+func (s *S3) List(ctx context.Context) ([]File, error) {
+	var files []File
+	query := url.Values{"list-type": {"2"}}
+	for {
+		data, err := s.do(ctx, http.MethodGet, s.bucketURL()+"?"+query.Encode(), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page listResult
+		if err := xml.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("s3: разбор списка объектов: %w", err)
+		}
+		for _, object := range page.Contents {
+			files = append(files, File{Name: object.Key, Modified: object.LastModified})
+		}
+		if !page.IsTruncated {
+			return files, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+func (s *S3) URL(name string) string {
+	return s.baseURL() + name
+}
+
+func (s *S3) Name(url string) (string, bool) {
+	return nameFromURL(s.baseURL(), url)
+}
+
+func (s *S3) baseURL() string {
+	if s.PublicURL != "" {
+		return strings.TrimSuffix(s.PublicURL, "/") + "/"
+	}
+	return s.objectURL("")
+}
+
+func (s *S3) bucketURL() string {
+	return strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket
+}
+
+func (s *S3) objectURL(name string) string {
+	return s.bucketURL() + "/" + url.PathEscape(name)
+}
+
+// do выполняет подписанный запрос и возвращает тело ответа.
+func (s *S3) do(ctx context.Context, method, target string, body []byte, header http.Header) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	s.sign(req, body, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("s3 %s %s: %s: %.200s", method, req.URL.Path, resp.Status, data)
+	}
+	return data, nil
+}
+
+// sign добавляет к запросу подпись AWS Signature Version 4. Подписываются
+// Host, Content-Type, Range и заголовки X-Amz-*.
+func (s *S3) sign(req *http.Request, body []byte, t time.Time) {
+	payloadHash := hashHex(body)
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		key = strings.ToLower(key)
+		if key == "content-type" || key == "range" || strings.HasPrefix(key, "x-amz-") {
+			headers[key] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hashHex([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + s.SecretKey)
+	for _, part := range []string{date, s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
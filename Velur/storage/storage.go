@@ -0,0 +1,47 @@
+// Package storage хранит файлы изображений товаров: на локальном диске или
+// в S3-совместимом хранилище. Ссылки на файлы строит само хранилище, поэтому
+// в базе лежат готовые URL, а не пути в каталоге.
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("файл не найден в хранилище")
+
+// Storage — хранилище файлов с плоским пространством имён. Имена файлов
+// вычисляются по содержимому, поэтому повторный Put с тем же именем
+// безопасен.
+type Storage interface {
+	Put(ctx context.Context, name string, data []byte, contentType string) error
+	// Get возвращает содержимое файла или ErrNotFound.
+	Get(ctx context.Context, name string) ([]byte, error)
+	// Delete удаляет файл; отсутствие файла ошибкой не считается.
+	Delete(ctx context.Context, name string) error
+	// URL — публичная ссылка на файл.
+	URL(name string) string
+	// Name восстанавливает имя файла по ссылке; false, если ссылка ведёт не
+	// в это хранилище.
+	Name(url string) (string, bool)
+	// List перечисляет все файлы хранилища.
+	List(ctx context.Context) ([]File, error)
+}
+
+// File — файл в хранилище и время его последней записи.
+type File struct {
+	Name     string
+	Modified time.Time
+}
+
+// nameFromURL отрезает от ссылки базовый адрес хранилища. Вложенные пути
+// хранилищу не принадлежат.
+func nameFromURL(baseURL, url string) (string, bool) {
+	name, ok := strings.CutPrefix(url, baseURL)
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"golangify.com/snippetbox/config"
+	"golangify.com/snippetbox/products"
+	"golangify.com/snippetbox/repository"
+)
+
+func TestMain(m *testing.M) {
+	// Обработчики пишут в лог каждую ошибку; в тестах это только шум.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// testApp — сервер магазина поверх repository.Memory.
+type testApp struct {
+	srv    *server
+	store  *repository.Memory
+	ts     *httptest.Server
+	upload string
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+	return newTestAppWith(t, func(*server) {})
+}
+
+// newTestAppWith даёт тесту настроить сервер до того, как построены маршруты.
+func newTestAppWith(t *testing.T, setup func(srv *server)) *testApp {
+	t.Helper()
+
+	cfg := config.Config{
+		Storage:        config.StorageMemory,
+		SessionAuthKey: strings.Repeat("k", 32),
+		AssetsDir:      "assets",
+		UploadDir:      t.TempDir(),
+		CookieSameSite: "lax",
+	}
+	store := repository.NewMemory()
+	srv := newServer(cfg, store)
+	setup(srv)
+	ts := httptest.NewServer(srv.routes())
+	t.Cleanup(ts.Close)
+
+	return &testApp{srv: srv, store: store, ts: ts, upload: cfg.UploadDir}
+}
+
+// reload перечитывает каталог, как это делает фоновый Run после заказа.
+func (app *testApp) reload(t *testing.T) {
+	t.Helper()
+	if err := app.srv.catalog.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (app *testApp) createUser(t *testing.T, username, password, role string) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = app.store.CreateUser(context.Background(), repository.User{
+		Username:     username,
+		Email:        username + "@example.com",
+		PasswordHash: hash,
+		Role:         role,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// seedClothing добавляет платье размеров S и M одного цвета с остатком stock
+// у каждого варианта.
+func (app *testApp) seedClothing(t *testing.T, name string, stock int) products.Clothing {
+	t.Helper()
+	clothing, err := app.store.CreateClothing(context.Background(), products.Clothing{
+		Name:     name,
+		Price:    1000,
+		ImageURL: "/uploads/dress.jpg",
+		Type:     "Платье",
+		Season:   "Лето",
+		Variants: buildVariants([]string{"S", "M"}, []string{"Белый"}, stock),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.reload(t)
+	return clothing
+}
+
+func (app *testApp) seedAccessory(t *testing.T, name string, stock int) products.Accessory {
+	t.Helper()
+	accessory, err := app.store.CreateAccessory(context.Background(), products.Accessory{
+		Name:     name,
+		Price:    300,
+		ImageURL: "/uploads/bag.jpg",
+		Type:     "Сумка",
+		Stock:    stock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.reload(t)
+	return accessory
+}
+
+// testPNG возвращает настоящее PNG-изображение: загрузки проверяются
+// декодированием, поэтому произвольные байты не подходят.
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := range width {
+		img.Set(x, x%height, color.RGBA{R: 200, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testClient — отдельный посетитель со своими cookie. Редиректы не
+// выполняются, чтобы тесты видели ответ обработчика.
+type testClient struct {
+	t      *testing.T
+	client *http.Client
+	base   string
+	csrf   string
+}
+
+type testResponse struct {
+	status int
+	header http.Header
+	body   string
+}
+
+func (app *testApp) client(t *testing.T) *testClient {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{
+		Transport:     app.ts.Client().Transport,
+		Jar:           jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	return &testClient{t: t, client: client, base: app.ts.URL}
+}
+
+func (c *testClient) do(req *http.Request) testResponse {
+	c.t.Helper()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return testResponse{status: resp.StatusCode, header: resp.Header, body: string(body)}
+}
+
+func (c *testClient) get(path string) testResponse {
+	c.t.Helper()
+	req, err := http.NewRequest(http.MethodGet, c.base+path, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return c.do(req)
+}
+
+var csrfInput = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// token возвращает CSRF-токен сессии клиента, открывая страницу входа.
+func (c *testClient) token() string {
+	c.t.Helper()
+	if c.csrf == "" {
+		m := csrfInput.FindStringSubmatch(c.get("/login").body)
+		if m == nil {
+			c.t.Fatal("на странице входа нет CSRF-токена")
+		}
+		c.csrf = m[1]
+	}
+	return c.csrf
+}
+
+// withToken добавляет в форму CSRF-токен, если тест не задал его сам.
+func (c *testClient) withToken(form url.Values) url.Values {
+	c.t.Helper()
+	if _, ok := form[csrfField]; ok {
+		return form
+	}
+	withToken := url.Values{csrfField: {c.token()}}
+	for key, values := range form {
+		withToken[key] = values
+	}
+	return withToken
+}
+
+func (c *testClient) postForm(path string, form url.Values) testResponse {
+	c.t.Helper()
+	form = c.withToken(form)
+	req, err := http.NewRequest(http.MethodPost, c.base+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.do(req)
+}
+
+// postMultipart отправляет форму с файлом image. Пустое имя файла — форма
+// без изображения.
+func (c *testClient) postMultipart(path string, form url.Values, filename string, image []byte) testResponse {
+	c.t.Helper()
+
+	form = c.withToken(form)
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for key, values := range form {
+		for _, value := range values {
+			mw.WriteField(key, value)
+		}
+	}
+	if filename != "" {
+		fw, err := mw.CreateFormFile("image", filename)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		fw.Write(image)
+	}
+	mw.Close()
+
+	req, err := http.NewRequest(http.MethodPost, c.base+path, &body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return c.do(req)
+}
+
+func (c *testClient) login(username, password string) {
+	c.t.Helper()
+	resp := c.postForm("/login", url.Values{"username": {username}, "password": {password}})
+	if resp.status != http.StatusSeeOther {
+		c.t.Fatalf("вход %s: статус %d, ожидался %d", username, resp.status, http.StatusSeeOther)
+	}
+	// После входа сессия получает новый токен.
+	c.csrf = ""
+}
+
+// adminClient создаёт администратора и входит под ним.
+func (app *testApp) adminClient(t *testing.T) *testClient {
+	t.Helper()
+	app.createUser(t, "admin", "secret", "admin")
+	c := app.client(t)
+	c.login("admin", "secret")
+	return c
+}
+
+func assertStatus(t *testing.T, resp testResponse, want int) {
+	t.Helper()
+	if resp.status != want {
+		t.Fatalf("статус %d, ожидался %d; тело: %.200s", resp.status, want, resp.body)
+	}
+}
+
+func assertRedirect(t *testing.T, resp testResponse, location string) {
+	t.Helper()
+	assertStatus(t, resp, http.StatusSeeOther)
+	if got := resp.header.Get("Location"); got != location {
+		t.Fatalf("редирект на %q, ожидался %q", got, location)
+	}
+}
+
+func assertContains(t *testing.T, body, want string) {
+	t.Helper()
+	if !strings.Contains(body, want) {
+		t.Fatalf("в ответе нет %q", want)
+	}
+}
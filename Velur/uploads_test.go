@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golangify.com/snippetbox/images"
+	"golangify.com/snippetbox/products"
+)
+
+// imageName — имя, под которым сохраняется загруженный PNG.
+func imageName(data []byte) string {
+	return images.Upload{Data: data, Ext: ".png"}.Name()
+}
+
+func uploadedFiles(t *testing.T, app *testApp) []string {
+	t.Helper()
+	entries, err := os.ReadDir(app.upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestUploadRejectsBadImages(t *testing.T) {
+	png := testPNG(t, 40, 30)
+	gif := []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+
+	tests := []struct {
+		name   string
+		data   []byte
+		status int
+	}{
+		{"text", []byte("<script>alert(1)</script>"), http.StatusBadRequest},
+		{"gif", gif, http.StatusBadRequest},
+		{"truncated png", png[:len(png)/2], http.StatusBadRequest},
+		{"too large", append(bytes.Clone(png), make([]byte, images.MaxSize)...), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			admin := app.adminClient(t)
+
+			resp := admin.postMultipart("/admin/add-clothing", clothingForm(), "photo.jpg", tt.data)
+			assertStatus(t, resp, tt.status)
+			if files := uploadedFiles(t, app); len(files) != 0 {
+				t.Fatalf("после отклонённой загрузки остались файлы %v", files)
+			}
+			if n := len(app.srv.catalog.Snapshot().Clothes); n != 0 {
+				t.Fatalf("товар добавлен: %d", n)
+			}
+		})
+	}
+}
+
+func TestUploadNamedByContent(t *testing.T) {
+	app := newTestApp(t)
+	admin := app.adminClient(t)
+	image := testPNG(t, 40, 30)
+
+	form := clothingForm()
+	assertRedirect(t, admin.postMultipart("/admin/add-clothing", form, "../../evil.jpg", image), "/admin")
+	form.Set("name", "Вторая блузка")
+	assertRedirect(t, admin.postMultipart("/admin/add-clothing", form, "other.png", image), "/admin")
+
+	for _, clothing := range app.srv.catalog.Snapshot().Clothes {
+		if clothing.ImageURL != "/uploads/"+imageName(image) {
+			t.Fatalf("изображение сохранено как %q", clothing.ImageURL)
+		}
+	}
+	if files := uploadedFiles(t, app); !slices.Contains(files, imageName(image)) {
+		t.Fatalf("в каталоге загрузок нет исходника: %v", files)
+	}
+}
+
+func TestUploadBuildsImageSizes(t *testing.T) {
+	app := newTestApp(t)
+	admin := app.adminClient(t)
+
+	assertRedirect(t, admin.postMultipart("/admin/add-clothing", clothingForm(), "photo.png", testPNG(t, 600, 400)), "/admin")
+
+	var clothing products.Clothing
+	for _, c := range app.srv.catalog.Snapshot().Clothes {
+		clothing = c
+	}
+	for url, width := range map[string]int{
+		clothing.ImageSizes.Thumb: products.ThumbWidth,
+		clothing.ImageSizes.Card:  products.CardWidth,
+		clothing.ImageSizes.Zoom:  600,
+	} {
+		f, err := os.Open(filepath.Join(app.upload, strings.TrimPrefix(url, "/uploads/")))
+		if err != nil {
+			t.Fatalf("копия %q не сохранена: %v", url, err)
+		}
+		cfg, format, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil || format != "jpeg" || cfg.Width != width {
+			t.Fatalf("копия %q: %s %dx%d, ожидалась ширина %d", url, format, cfg.Width, cfg.Height, width)
+		}
+	}
+
+	srcset := clothing.ImageSizes.SrcSet()
+	assertContains(t, admin.get("/").body, `srcset="`+srcset+`"`)
+	assertContains(t, admin.get("/clothing/"+clothing.ID).body, `srcset="`+srcset+`"`)
+}
+
+func TestFailedSaveLeavesNoFiles(t *testing.T) {
+	app := newTestApp(t)
+	admin := app.adminClient(t)
+
+	resp := admin.postMultipart("/admin/edit-clothing/999", clothingForm(), "photo.png", testPNG(t, 40, 30))
+	assertStatus(t, resp, http.StatusNotFound)
+	if files := uploadedFiles(t, app); len(files) != 0 {
+		t.Fatalf("после ошибки сохранения остались файлы %v", files)
+	}
+}
+
+// clothingFiles — имена файлов всех фотографий товара в каталоге загрузок.
+func clothingFiles(c products.Clothing) []string {
+	var names []string
+	for _, image := range c.Images {
+		for _, url := range []string{image.URL, image.Sizes.Thumb, image.Sizes.Card, image.Sizes.Zoom} {
+			names = append(names, strings.TrimPrefix(url, "/uploads/"))
+		}
+	}
+	return names
+}
+
+func TestDeleteRemovesImages(t *testing.T) {
+	app := newTestApp(t)
+	admin := app.adminClient(t)
+	shared := testPNG(t, 600, 400)
+
+	form := clothingForm()
+	for _, name := range []string{"Первая блузка", "Вторая блузка"} {
+		form.Set("name", name)
+		assertRedirect(t, admin.postMultipart("/admin/add-clothing", form, "shared.png", shared), "/admin")
+	}
+	form.Set("name", "Третья блузка")
+	assertRedirect(t, admin.postMultipart("/admin/add-clothing", form, "own.png", testPNG(t, 300, 200)), "/admin")
+
+	byName := map[string]products.Clothing{}
+	for _, c := range app.srv.catalog.Snapshot().Clothes {
+		byName[c.Name] = c
+	}
+	third := byName["Третья блузка"]
+	admin.postMultipart("/admin/add-clothing-image/"+third.ID, nil, "back.png", testPNG(t, 200, 300))
+	third, _ = app.srv.catalog.Clothing(third.ID)
+
+	assertRedirect(t, admin.postForm("/admin/delete-clothing/"+third.ID, nil), "/admin")
+	files := uploadedFiles(t, app)
+	for _, name := range clothingFiles(third) {
+		if slices.Contains(files, name) {
+			t.Fatalf("файл %s удалённого товара остался", name)
+		}
+	}
+
+	// Одинаковые изображения хранятся одним файлом: он нужен второму товару.
+	first := byName["Первая блузка"]
+	assertRedirect(t, admin.postForm("/admin/delete-clothing/"+first.ID, nil), "/admin")
+	files = uploadedFiles(t, app)
+	for _, name := range clothingFiles(first) {
+		if !slices.Contains(files, name) {
+			t.Fatalf("удалён файл %s, который нужен другому товару", name)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"golangify.com/snippetbox/catalog"
+	"golangify.com/snippetbox/products"
+	"golangify.com/snippetbox/repository"
+)
+
+func parseColors(value string) []string {
+	var colors []string
+	for _, color := range strings.Split(value, ",") {
+		if color = strings.TrimSpace(color); color != "" {
+			colors = append(colors, color)
+		}
+	}
+	return colors
+}
+
+// buildVariants составляет по варианту на каждое сочетание размера и цвета.
+// Артикулы проставляет репозиторий, когда становится известен ID товара.
+func buildVariants(sizes, colors []string, stock int) []products.Variant {
+	var variants []products.Variant
+	for _, size := range sizes {
+		for _, color := range colors {
+			variants = append(variants, products.Variant{Size: size, Color: color, Stock: stock})
+		}
+	}
+	return variants
+}
+
+// parseVariantForm читает артикул, остаток и необязательную цену варианта.
+// Пустая цена (0) означает, что вариант продаётся по цене товара.
+func parseVariantForm(r *http.Request) (products.Variant, error) {
+	variant := products.Variant{SKU: strings.TrimSpace(r.FormValue("sku"))}
+
+	var err error
+	variant.Stock, err = strconv.Atoi(r.FormValue("stock"))
+	if err != nil || variant.Stock < 0 {
+		return variant, fmt.Errorf("некорректный остаток на складе")
+	}
+
+	if priceStr := strings.TrimSpace(r.FormValue("price")); priceStr != "" {
+		variant.PriceOverride, err = parsePrice(priceStr)
+		if err != nil {
+			return variant, fmt.Errorf("некорректная цена варианта")
+		}
+	}
+	return variant, nil
+}
+
+func (srv *server) storeVariant(clothingID string, variant products.Variant) {
+	srv.catalog.Update(func(s *catalog.Snapshot) {
+		clothing, exists := s.Clothes[clothingID]
+		if !exists {
+			return
+		}
+
+		variants := make([]products.Variant, 0, len(clothing.Variants)+1)
+		replaced := false
+		for _, v := range clothing.Variants {
+			if v.ID == variant.ID {
+				v, replaced = variant, true
+			}
+			variants = append(variants, v)
+		}
+		if !replaced {
+			variants = append(variants, variant)
+		}
+
+		clothing.Variants = variants
+		s.Clothes[clothingID] = clothing
+	})
+}
+
+func (srv *server) addVariant(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clothingID := vars["id"]
+
+	size := strings.TrimSpace(r.FormValue("size"))
+	color := strings.TrimSpace(r.FormValue("color"))
+	if size == "" || color == "" {
+		http.Error(w, "Укажите размер и цвет", http.StatusBadRequest)
+		return
+	}
+
+	variant, err := parseVariantForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	variant.Size, variant.Color = size, color
+	if variant.SKU == "" {
+		variant.SKU = fmt.Sprintf("VL-%s-%s-%s", clothingID, size, color)
+	}
+
+	variant, err = srv.products.AddVariant(r.Context(), clothingID, variant)
+	if errors.Is(err, repository.ErrConflict) {
+		http.Error(w, "Такой размер и цвет или такой артикул уже есть", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при добавлении варианта одежды:", err)
+		http.Error(w, "Ошибка при добавлении варианта", http.StatusInternalServerError)
+		return
+	}
+
+	srv.storeVariant(clothingID, variant)
+	log.Printf("Вариант %s добавлен к одежде %s", variant.SKU, clothingID)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func (srv *server) updateVariant(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	variant, err := parseVariantForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if variant.SKU == "" {
+		http.Error(w, "Артикул не может быть пустым", http.StatusBadRequest)
+		return
+	}
+
+	variant.ID = vars["id"]
+	clothingID, variant, err := srv.products.UpdateVariant(r.Context(), variant)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if errors.Is(err, repository.ErrConflict) {
+		http.Error(w, "Такой артикул уже есть", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при обновлении варианта одежды:", err)
+		http.Error(w, "Ошибка при обновлении варианта", http.StatusInternalServerError)
+		return
+	}
+
+	srv.storeVariant(clothingID, variant)
+	log.Printf("Вариант %s обновлён: остаток %d", variant.SKU, variant.Stock)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func (srv *server) deleteVariant(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	variantID := vars["id"]
+
+	clothingID, err := srv.products.DeleteVariant(r.Context(), variantID)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Println("Ошибка при удалении варианта одежды:", err)
+		http.Error(w, "Ошибка при удалении варианта", http.StatusInternalServerError)
+		return
+	}
+
+	srv.catalog.Update(func(s *catalog.Snapshot) {
+		if clothing, exists := s.Clothes[clothingID]; exists {
+			variants := make([]products.Variant, 0, len(clothing.Variants))
+			for _, v := range clothing.Variants {
+				if v.ID != variantID {
+					variants = append(variants, v)
+				}
+			}
+			clothing.Variants = variants
+			s.Clothes[clothingID] = clothing
+		}
+	})
+
+	log.Println("Вариант одежды успешно удалён:", variantID)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
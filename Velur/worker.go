@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"golangify.com/snippetbox/jobs"
+	"golangify.com/snippetbox/repository"
+)
+
+// Виды фоновых заданий.
+const (
+	jobSendMail    = "send_mail"
+	jobOrderPlaced = "order_placed"
+	jobCleanup     = "cleanup_jobs"
+)
+
+const (
+	// jobInterval — как часто исполнитель проверяет очередь, если его не
+	// разбудили раньше.
+	jobInterval = 30 * time.Second
+	jobBatch    = 20
+	// jobLease — сколько задание числится за исполнителем; должно с запасом
+	// превышать время выполнения одного задания.
+	jobLease = 5 * time.Minute
+	// jobRetention — сколько хранятся выполненные задания.
+	jobRetention = 7 * 24 * time.Hour
+)
+
+// schedules — периодические задания.
+var schedules = []jobs.Schedule{
+	{Kind: jobCleanup, Every: 24 * time.Hour},
+}
+
+type jobHandler func(ctx context.Context, payload []byte) error
+
+func (srv *server) jobHandlers() map[string]jobHandler {
+	return map[string]jobHandler{
+		jobSendMail:    srv.sendMailJob,
+		jobOrderPlaced: srv.orderPlacedJob,
+		jobCleanup:     srv.cleanupJob,
+	}
+}
+
+// enqueueJobs ставит задания в очередь и будит исполнителя.
+func (srv *server) enqueueJobs(ctx context.Context, list ...jobs.New) error {
+	if len(list) == 0 {
+		return nil
+	}
+	if err := srv.jobs.EnqueueJobs(ctx, list...); err != nil {
+		return err
+	}
+	srv.wakeWorker()
+	return nil
+}
+
+func (srv *server) wakeWorker() {
+	select {
+	case srv.jobQueued <- struct{}{}:
+	default:
+	}
+}
+
+// runJobs выполняет задания, время которых наступило, и возвращает, сколько
+// заданий было взято из очереди.
+func (srv *server) runJobs(ctx context.Context) (int, error) {
+	list, err := srv.jobs.ClaimJobs(ctx, jobBatch, jobLease)
+	if err != nil {
+		return 0, err
+	}
+	handlers := srv.jobHandlers()
+	for _, job := range list {
+		err := runJob(ctx, handlers[job.Kind], job)
+		if err == nil {
+			if err := srv.jobs.CompleteJob(ctx, job.ID); err != nil {
+				log.Println("Ошибка при записи выполненного задания:", err)
+			}
+			continue
+		}
+
+		retry := jobs.Backoff(job.Attempts)
+		if job.Attempts >= job.MaxAttempts || errors.Is(err, jobs.ErrPermanent) {
+			retry = 0
+			log.Printf("Задание №%d (%s) не выполнено после %d попыток: %v", job.ID, job.Kind, job.Attempts, err)
+		} else {
+			log.Printf("Задание №%d (%s) не выполнено, повтор через %s: %v", job.ID, job.Kind, retry, err)
+		}
+		if err := srv.jobs.FailJob(ctx, job.ID, err.Error(), retry); err != nil {
+			log.Println("Ошибка при записи невыполненного задания:", err)
+		}
+	}
+	return len(list), nil
+}
+
+// runJob выполняет задание, превращая панику обработчика в ошибку.
+func runJob(ctx context.Context, handler jobHandler, job repository.Job) (err error) {
+	if handler == nil {
+		return jobs.Permanent(fmt.Errorf("неизвестный вид задания %q", job.Kind))
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("паника: %v", r)
+		}
+	}()
+	return handler(ctx, job.Payload)
+}
+
+// runWorker разбирает очередь заданий раз в interval и сразу после
+// постановки нового задания. Заодно он ставит в очередь периодические
+// задания: ключ не даёт им размножиться, даже если магазин запущен в
+// нескольких экземплярах.
+func (srv *server) runWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, s := range schedules {
+			if err := srv.jobs.EnqueueJobs(ctx, s.Next()); err != nil {
+				log.Println("Ошибка при постановке периодического задания:", err)
+			}
+		}
+		for {
+			claimed, err := srv.runJobs(ctx)
+			if err != nil {
+				log.Println("Ошибка при разборе очереди заданий:", err)
+			}
+			if err != nil || claimed < jobBatch {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-srv.jobQueued:
+		}
+	}
+}
+
+func (srv *server) cleanupJob(ctx context.Context, payload []byte) error {
+	n, err := srv.jobs.DeleteJobs(ctx, jobRetention)
+	if err != nil {
+		return err
+	}
+	log.Printf("Удалено выполненных заданий: %d", n)
+
+	n, err = srv.resets.DeletePasswordResets(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Удалено устаревших ссылок восстановления пароля: %d", n)
+	return nil
+}
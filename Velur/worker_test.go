@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"golangify.com/snippetbox/jobs"
+	"golangify.com/snippetbox/notify"
+	"golangify.com/snippetbox/repository"
+	"golangify.com/snippetbox/roles"
+)
+
+func jobByID(t *testing.T, app *testApp, id int) repository.Job {
+	t.Helper()
+	for _, job := range app.store.Jobs() {
+		if job.ID == id {
+			return job
+		}
+	}
+	t.Fatalf("задание №%d не найдено", id)
+	return repository.Job{}
+}
+
+func TestOrderJobQueuedWithOrder(t *testing.T) {
+	app := newTestApp(t)
+	accessory := app.seedAccessory(t, "Кожаная сумка", 3)
+	id := placeOrder(t, app, accessoryItem(accessory, "1"), "+79990000000")
+
+	list := app.store.Jobs()
+	if len(list) != 1 || list[0].Kind != jobOrderPlaced || string(list[0].Payload) != `{"order_id":`+strconv.Itoa(id)+`}` {
+		t.Fatalf("задания после заказа: %+v", list)
+	}
+
+	// Товара не хватило — нет ни заказа, ни задания.
+	c := app.client(t)
+	c.postForm("/cart/add", accessoryItem(accessory, "3"))
+	assertStatus(t, c.postForm("/order", orderForm()), http.StatusConflict)
+	if n := len(app.store.Jobs()); n != 1 {
+		t.Fatalf("заданий после неудачного заказа: %d", n)
+	}
+}
+
+func TestJobRetries(t *testing.T) {
+	app, box := newMailApp(t)
+	ctx := context.Background()
+	box.fail = 1
+	app.srv.enqueueMail(ctx, notify.Welcome, "anna@example.com", map[string]interface{}{"Username": "anna", "URL": "/"})
+
+	if sent := box.deliver(t, app); len(sent) != 0 {
+		t.Fatalf("отправлено при недоступном сервере: %+v", sent)
+	}
+	job := app.store.Jobs()[0]
+	if job.Status != jobs.Pending || job.Attempts != 1 || job.LastError == "" || time.Until(job.RunAt) < 50*time.Second {
+		t.Fatalf("задание после неудачи: %+v", job)
+	}
+	// Следующая попытка — только после паузы.
+	if sent := box.deliver(t, app); len(sent) != 0 {
+		t.Fatalf("повтор без паузы: %+v", sent)
+	}
+
+	// Задание, исчерпавшее попытки, больше не выполняется, пока его не
+	// перезапустят из админ-панели.
+	box.fail = 1
+	app.srv.enqueueMail(ctx, notify.Welcome, "maria@example.com", map[string]interface{}{"Username": "maria", "URL": "/"})
+	dead := app.store.Jobs()[1]
+	for range jobs.DefaultMaxAttempts - 1 {
+		app.store.ClaimJobs(ctx, jobBatch, 0)
+	}
+	box.deliver(t, app)
+	if dead = jobByID(t, app, dead.ID); dead.Status != jobs.Dead || dead.Attempts != jobs.DefaultMaxAttempts {
+		t.Fatalf("задание после последней попытки: %+v", dead)
+	}
+
+	admin := app.adminClient(t)
+	assertContains(t, admin.get("/admin/jobs").body, "сервер недоступен")
+	retryPath := "/admin/jobs/" + strconv.Itoa(dead.ID) + "/retry"
+	assertRedirect(t, admin.postForm(retryPath, nil), "/admin/jobs")
+	assertStatus(t, admin.postForm(retryPath, nil), http.StatusNotFound)
+	if sent := box.deliver(t, app); len(sent) != 1 || sent[0].To != "maria@example.com" {
+		t.Fatalf("после перезапуска отправлено: %+v", sent)
+	}
+	if job := jobByID(t, app, dead.ID); job.Status != jobs.Done || job.LastError != "" {
+		t.Fatalf("перезапущенное задание: %+v", job)
+	}
+}
+
+func TestJobFailures(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+	app.store.EnqueueJobs(ctx, jobs.New{Kind: "unknown"}, jobs.New{Kind: jobSendMail, Payload: []byte(`"broken"`)})
+	if _, err := app.srv.runJobs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range app.store.Jobs() {
+		if job.Status != jobs.Dead || job.Attempts != 1 {
+			t.Fatalf("задание без повтора: %+v", job)
+		}
+	}
+
+	err := runJob(ctx, func(context.Context, []byte) error { panic("сбой") }, repository.Job{Kind: "panic"})
+	if err == nil || errors.Is(err, jobs.ErrPermanent) {
+		t.Fatalf("паника обработчика: %v", err)
+	}
+}
+
+func TestScheduledJobs(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+	schedule := jobs.Schedule{Kind: jobCleanup, Every: time.Hour}
+	for range 2 {
+		app.store.EnqueueJobs(ctx, schedule.Next())
+	}
+	list := app.store.Jobs()
+	if len(list) != 1 || time.Until(list[0].RunAt) < 59*time.Minute {
+		t.Fatalf("периодические задания: %+v", list)
+	}
+
+	// Выполненные задания удаляет очистка; после неё расписание ставит
+	// следующее.
+	app.store.EnqueueJobs(ctx, jobs.New{Kind: jobCleanup})
+	if _, err := app.srv.runJobs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := app.store.DeleteJobs(ctx, 0); n != 1 {
+		t.Fatalf("удалено выполненных заданий: %d", n)
+	}
+}
+
+func TestAdminJobsPermissions(t *testing.T) {
+	app := newTestApp(t)
+	app.createUser(t, "manager", "secret", roles.OrderManager)
+	c := app.client(t)
+	c.login("manager", "secret")
+	assertStatus(t, c.get("/admin/jobs"), http.StatusForbidden)
+	assertStatus(t, c.postForm("/admin/jobs/1/retry", nil), http.StatusForbidden)
+
+	admin := app.adminClient(t)
+	assertStatus(t, admin.get("/admin/jobs?status=done"), http.StatusOK)
+	assertStatus(t, admin.get("/admin/jobs?status=lost"), http.StatusBadRequest)
+	assertContains(t, admin.get("/admin").body, `href="/admin/jobs"`)
+}